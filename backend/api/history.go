@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"rdr/common/config"
+	"rdr/common/marketdata"
+	"rdr/common/orderbook"
+	"rdr/common/symbols"
+)
+
+const (
+	defaultHistoryDepth = 20
+	// Deltas archived this long before a checkpoint are still considered, since their venue
+	// event times can trail the checkpoint's local time
+	historyDeltaSlack = 5 * time.Second
+)
+
+type OrderBookHistoryResponse struct {
+	Symbol       string    `json:"symbol"`
+	At           time.Time `json:"at"`
+	SnapshotTime time.Time `json:"snapshotTime"`
+	// BookTime is the time of the last replayed delta (SnapshotTime if none). It trails At when the
+	// market was quiet, when the archive stops short of At, or when the venue began a new session
+	// that has no checkpoint before At yet
+	BookTime     time.Time `json:"bookTime"`
+	LastUpdateID int64     `json:"lastUpdateId"`
+	// DeltasApplied counts the archived depth deltas replayed on top of the snapshot
+	DeltasApplied int               `json:"deltasApplied"`
+	Bids          []orderbook.Level `json:"bids"`
+	Asks          []orderbook.Level `json:"asks"`
+}
+
+// parseAt accepts RFC 3339 (with optional fractional seconds) or Unix milliseconds
+func parseAt(v string) (time.Time, error) {
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.UnixMilli(ms).UTC(), nil
+	}
+	return time.Parse(time.RFC3339Nano, v)
+}
+
+// getOrderBookHistoryHandler: GET /orderbook/history?symbol=BTCUSDT&at=2025-06-01T14:03:22.150Z&depth=20
+// rebuilds the book as it was at `at` from the nearest earlier archived snapshot plus depth deltas,
+// using the same orderbook.Book apply rules as orderbook_manager. Only exchange's book is rebuilt.
+func getOrderBookHistoryHandler(dbpool *pgxpool.Pool, exchange string, market config.MarketConfig, registry *symbols.Registry, maxDepth int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		symbol := strings.ToUpper(query.Get("symbol"))
+		if symbol == "" || query.Get("at") == "" {
+			http.Error(w, "Missing params", http.StatusBadRequest)
+			return
+		}
+		if !validSymbol(market, registry, symbol) {
+			http.Error(w, "Unknown symbol", http.StatusBadRequest)
+			return
+		}
+		at, err := parseAt(query.Get("at"))
+		if err != nil {
+			http.Error(w, "Invalid at parameter (RFC 3339 or Unix milliseconds)", http.StatusBadRequest)
+			return
+		}
+		depth := defaultHistoryDepth
+		if v := query.Get("depth"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > maxDepth {
+				http.Error(w, fmt.Sprintf("depth must be between 1 and %d", maxDepth), http.StatusBadRequest)
+				return
+			}
+			depth = n
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		response, err := reconstructBook(ctx, dbpool, registry, exchange, symbol, at, depth)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			http.Error(w, "No archived order book before the requested time", http.StatusNotFound)
+			return
+		case errors.Is(err, orderbook.ErrGap):
+			http.Error(w, "Archived depth deltas have a gap before the requested time", http.StatusNotFound)
+			return
+		case err != nil:
+			log.Printf("Order book reconstruction for %s at %s failed: %v", symbol, at, err)
+			http.Error(w, "DB query failed", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func reconstructBook(ctx context.Context, dbpool *pgxpool.Pool, registry *symbols.Registry, exchange, symbol string, at time.Time, depth int) (OrderBookHistoryResponse, error) {
+	var checkpoint archivedCheckpoint
+	err := dbpool.QueryRow(ctx, `
+		SELECT time, last_update_id, bids, asks FROM orderbook_snapshots
+		WHERE symbol = $1 AND exchange = $2 AND time <= $3 ORDER BY time DESC LIMIT 1`, symbol, exchange, at).Scan(&checkpoint.Time, &checkpoint.LastUpdateID, &checkpoint.Bids, &checkpoint.Asks)
+	if err != nil {
+		return OrderBookHistoryResponse{}, err
+	}
+
+	// Venues with a local sequence (Kraken) restart it on every resubscribe, so update IDs alone
+	// cannot tell this checkpoint's session from an earlier one; replay sorts that out in time order
+	rows, err := dbpool.Query(ctx, `
+		SELECT time, first_update_id, final_update_id, bids, asks FROM depth_deltas
+		WHERE symbol = $1 AND exchange = $2 AND time > $3 AND time <= $4
+		ORDER BY time ASC, final_update_id ASC`, symbol, exchange, checkpoint.Time.Add(-historyDeltaSlack), at)
+	if err != nil {
+		return OrderBookHistoryResponse{}, err
+	}
+	defer rows.Close()
+	var deltas []archivedDelta
+	for rows.Next() {
+		d := archivedDelta{Delta: marketdata.DepthDelta{Exchange: exchange, Symbol: symbol}}
+		if err := rows.Scan(&d.Time, &d.Delta.FirstUpdateID, &d.Delta.FinalUpdateID, &d.Delta.Bids, &d.Delta.Asks); err != nil {
+			return OrderBookHistoryResponse{}, err
+		}
+		deltas = append(deltas, d)
+	}
+	if err := rows.Err(); err != nil {
+		return OrderBookHistoryResponse{}, err
+	}
+
+	book := orderbook.NewBook(registry.Precision(symbol))
+	result, err := replay(book, checkpoint, deltas)
+	if err != nil {
+		return OrderBookHistoryResponse{}, err
+	}
+	return OrderBookHistoryResponse{
+		Symbol:        symbol,
+		At:            at,
+		SnapshotTime:  checkpoint.Time,
+		BookTime:      result.bookTime,
+		LastUpdateID:  book.LastUpdateID,
+		DeltasApplied: result.applied,
+		Bids:          book.Bids.Top(depth),
+		Asks:          book.Asks.Top(depth),
+	}, nil
+}
+
+// archivedCheckpoint is an orderbook_snapshots row
+type archivedCheckpoint struct {
+	Time         time.Time
+	LastUpdateID int64
+	Bids, Asks   []marketdata.Level
+}
+
+// archivedDelta is a depth_deltas row
+type archivedDelta struct {
+	Time  time.Time
+	Delta marketdata.DepthDelta
+}
+
+type replayResult struct {
+	applied int
+	// bookTime is the time of the last applied delta, or the checkpoint's if none applied
+	bookTime time.Time
+}
+
+// replay resets book to checkpoint and applies the deltas of the checkpoint's session, which
+// must be sorted by time. Deltas archived shortly before the checkpoint are only there to catch
+// clock skew between the venue and orderbook_manager; before the replay starts, anything that
+// does not continue the checkpoint is skipped. Once it has started, a sequence that goes back
+// means the venue began a new session, and the replay stops there.
+func replay(book *orderbook.Book, checkpoint archivedCheckpoint, deltas []archivedDelta) (replayResult, error) {
+	reset := func() (replayResult, error) {
+		if err := book.Reset(checkpoint.LastUpdateID, checkpoint.Bids, checkpoint.Asks); err != nil {
+			return replayResult{}, fmt.Errorf("archived snapshot: %w", err)
+		}
+		return replayResult{bookTime: checkpoint.Time}, nil
+	}
+	result, err := reset()
+	if err != nil {
+		return replayResult{}, err
+	}
+	for _, d := range deltas {
+		if result.applied > 0 && d.Delta.FinalUpdateID <= book.LastUpdateID {
+			if !result.bookTime.Before(checkpoint.Time) {
+				log.Printf("Archived %s deltas restart at update %d after %s; stopping the replay there", d.Delta.Symbol, d.Delta.FinalUpdateID, result.bookTime.Format(time.RFC3339Nano))
+				break
+			}
+			// everything applied so far came from the session before the checkpoint's
+			if result, err = reset(); err != nil {
+				return replayResult{}, err
+			}
+		}
+		if result.applied == 0 {
+			next := checkpoint.LastUpdateID + 1
+			continues := d.Delta.FirstUpdateID <= next && next <= d.Delta.FinalUpdateID
+			if !continues && (d.Time.Before(checkpoint.Time) || d.Delta.FinalUpdateID <= checkpoint.LastUpdateID) {
+				continue
+			}
+		}
+		if err := book.Apply(d.Delta); err != nil {
+			if errors.Is(err, orderbook.ErrGap) {
+				return replayResult{}, err
+			}
+			// orderbook_manager drops malformed updates the same way
+			log.Printf("Skipping malformed archived %s delta %d: %v", d.Delta.Symbol, d.Delta.FinalUpdateID, err)
+			continue
+		}
+		result.applied++
+		result.bookTime = d.Time
+	}
+	return result, nil
+}
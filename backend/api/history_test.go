@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"rdr/common/decimal"
+	"rdr/common/marketdata"
+	"rdr/common/orderbook"
+)
+
+var checkpointTime = time.Date(2025, 6, 1, 14, 0, 0, 0, time.UTC)
+
+// delta returns an archived delta offset from the checkpoint that sets the bid at price to its final update ID
+func delta(offset time.Duration, first, final int64, price string) archivedDelta {
+	return archivedDelta{
+		Time: checkpointTime.Add(offset),
+		Delta: marketdata.DepthDelta{
+			Symbol: "BTCUSDT", FirstUpdateID: first, FinalUpdateID: final,
+			Bids: []marketdata.Level{{price, strconv.FormatInt(final, 10)}},
+		},
+	}
+}
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name       string
+		last       int64
+		deltas     []archivedDelta
+		err        error
+		applied    int
+		lastUpdate int64
+		bookTime   time.Duration
+		// bids after the replay: price x quantity, best first
+		bids string
+	}{
+		{
+			name:       "no deltas",
+			last:       100,
+			lastUpdate: 100,
+			bids:       "100x1",
+		},
+		{
+			name: "binance",
+			last: 100,
+			deltas: []archivedDelta{
+				// already in the checkpoint
+				delta(-2*time.Second, 95, 99, "99"),
+				// straddles the checkpoint and was stamped by the venue just before it
+				delta(-time.Second, 99, 101, "100"),
+				delta(time.Second, 102, 104, "100"),
+				delta(2*time.Second, 105, 105, "100"),
+			},
+			applied:    3,
+			lastUpdate: 105,
+			bookTime:   2 * time.Second,
+			bids:       "100x105",
+		},
+		{
+			name:   "gap after the checkpoint",
+			last:   100,
+			deltas: []archivedDelta{delta(time.Second, 101, 101, "100"), delta(2*time.Second, 103, 104, "100")},
+			err:    orderbook.ErrGap,
+		},
+		{
+			name:   "first delta missing",
+			last:   100,
+			deltas: []archivedDelta{delta(-time.Second, 90, 95, "100"), delta(time.Second, 102, 102, "100")},
+			err:    orderbook.ErrGap,
+		},
+		{
+			// the local sequence restarted shortly before the checkpoint; the earlier session's
+			// deltas 4 and 5 must not be applied on top of it
+			name: "earlier kraken session",
+			last: 3,
+			deltas: []archivedDelta{
+				delta(-4*time.Second, 4, 4, "90"),
+				delta(-3500*time.Millisecond, 5, 5, "90"),
+				delta(-3*time.Second, 2, 2, "100"),
+				delta(-2*time.Second, 3, 3, "100"),
+				delta(time.Second, 4, 4, "100"),
+				delta(2*time.Second, 5, 5, "100"),
+			},
+			applied:    2,
+			lastUpdate: 5,
+			bookTime:   2 * time.Second,
+			bids:       "100x5",
+		},
+		{
+			// a new session after the checkpoint ends the replay, since its deltas need their own checkpoint
+			name: "later kraken session",
+			last: 3,
+			deltas: []archivedDelta{
+				delta(time.Second, 4, 4, "100"),
+				delta(2*time.Second, 5, 5, "100"),
+				delta(3*time.Second, 2, 2, "90"),
+				delta(4*time.Second, 3, 3, "90"),
+				delta(5*time.Second, 6, 6, "90"),
+			},
+			applied:    2,
+			lastUpdate: 5,
+			bookTime:   2 * time.Second,
+			bids:       "100x5",
+		},
+		{
+			name: "malformed delta",
+			last: 100,
+			deltas: []archivedDelta{
+				delta(time.Second, 101, 101, "100"),
+				delta(2*time.Second, 102, 102, "abc"),
+				delta(3*time.Second, 102, 102, "100"),
+			},
+			applied:    2,
+			lastUpdate: 102,
+			bookTime:   3 * time.Second,
+			bids:       "100x102",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkpoint := archivedCheckpoint{
+				Time:         checkpointTime,
+				LastUpdateID: tt.last,
+				Bids:         []marketdata.Level{{"100", "1"}},
+				Asks:         []marketdata.Level{{"101", "1"}},
+			}
+			book := orderbook.NewBook(decimal.Precision{})
+			result, err := replay(book, checkpoint, tt.deltas)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("replay error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.applied != tt.applied || book.LastUpdateID != tt.lastUpdate || !result.bookTime.Equal(checkpointTime.Add(tt.bookTime)) {
+				t.Errorf("applied %d up to %d at %s, want %d up to %d at %s", result.applied, book.LastUpdateID, result.bookTime,
+					tt.applied, tt.lastUpdate, checkpointTime.Add(tt.bookTime))
+			}
+			var bids string
+			for i, l := range book.Bids.Top(10) {
+				if i > 0 {
+					bids += " "
+				}
+				bids += l.Price.String() + "x" + l.Quantity.String()
+			}
+			if bids != tt.bids {
+				t.Errorf("bids = %s, want %s", bids, tt.bids)
+			}
+		})
+	}
+}
+
+func TestReplayInvalidCheckpoint(t *testing.T) {
+	checkpoint := archivedCheckpoint{Time: checkpointTime, Bids: []marketdata.Level{{"abc", "1"}}}
+	if _, err := replay(orderbook.NewBook(decimal.Precision{}), checkpoint, nil); err == nil {
+		t.Error("replay accepted a malformed checkpoint")
+	}
+}
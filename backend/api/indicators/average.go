@@ -0,0 +1,102 @@
+package indicators
+
+import "math"
+
+// emaSettle is the weight an exponential average's seed may still carry when
+// its value is first used.
+const emaSettle = 0.005
+
+// sma is the simple moving average of the close.
+type sma struct{ w *window }
+
+func newSMA(period int) *sma { return &sma{w: newWindow(period)} }
+
+func (s *sma) Update(bar Bar) ([]float64, bool) {
+	s.w.push(bar.Close)
+	if !s.w.full() {
+		return nil, false
+	}
+	return []float64{s.w.mean()}, true
+}
+
+func (s *sma) WarmUp() int { return len(s.w.values) - 1 }
+
+func (s *sma) Clone() Indicator { return &sma{w: s.w.clone()} }
+
+// ema is an exponential average seeded with the simple average of its first
+// period values; alpha is 2/(period+1) for EMA and 1/period for Wilder's
+// smoothing (RSI, ATR).
+type ema struct {
+	period int
+	alpha  float64
+	seed   float64
+	count  int
+	value  float64
+}
+
+func newEMA(period int) *ema    { return &ema{period: period, alpha: 2 / float64(period+1)} }
+func newWilder(period int) *ema { return &ema{period: period, alpha: 1 / float64(period)} }
+
+// add feeds v and reports whether the average is seeded.
+func (e *ema) add(v float64) bool {
+	if e.count < e.period {
+		e.seed += v
+		e.count++
+		if e.count < e.period {
+			return false
+		}
+		e.value = e.seed / float64(e.period)
+		return true
+	}
+	e.value += e.alpha * (v - e.value)
+	return true
+}
+
+func (e *ema) Update(bar Bar) ([]float64, bool) {
+	if !e.add(bar.Close) {
+		return nil, false
+	}
+	return []float64{e.value}, true
+}
+
+// WarmUp covers the seed period and then enough updates for the seed's
+// weight, (1-alpha)^n, to drop below emaSettle: about 3.6 periods for EMA and
+// 6.3 for Wilder's smoothing.
+func (e *ema) WarmUp() int {
+	return e.period - 1 + int(math.Ceil(math.Log(emaSettle)/math.Log(1-e.alpha)))
+}
+
+func (e *ema) Clone() Indicator { return e.clone() }
+
+func (e *ema) clone() *ema {
+	c := *e
+	return &c
+}
+
+// wma is the linearly weighted moving average of the close: the newest value
+// has weight period, the oldest weight 1.
+type wma struct {
+	w        *window
+	weighted float64
+}
+
+func newWMA(period int) *wma { return &wma{w: newWindow(period)} }
+
+func (m *wma) Update(bar Bar) ([]float64, bool) {
+	n := float64(len(m.w.values))
+	sum := m.w.sum
+	if _, full := m.w.push(bar.Close); full {
+		// every value loses one weight, so the evicted one drops out, and the new one gets n
+		m.weighted += n*bar.Close - sum
+	} else {
+		m.weighted += float64(m.w.count) * bar.Close
+	}
+	if !m.w.full() {
+		return nil, false
+	}
+	return []float64{m.weighted / (n * (n + 1) / 2)}, true
+}
+
+func (m *wma) WarmUp() int { return len(m.w.values) - 1 }
+
+func (m *wma) Clone() Indicator { return &wma{w: m.w.clone(), weighted: m.weighted} }
@@ -0,0 +1,178 @@
+// Package indicators computes technical indicators over klines.
+//
+// Every indicator is a small state machine fed one bar at a time, oldest
+// first, so a series of n bars costs O(n) whatever the period. An indicator
+// reports no value until it has seen enough bars; WarmUp tells the caller how
+// many bars to feed before the first bar it wants a (settled) value for.
+//
+// Indicators are selected by spec strings of the form name[:param...], e.g.
+// "ema:50", "rsi:14" or "macd:12:26:9"; omitted parameters take the defaults
+// listed in the registry.
+package indicators
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxPeriod bounds every period parameter, and with it the extra history a
+// request has to read.
+const MaxPeriod = 500
+
+// MaxIndicators bounds the number of indicators of one request.
+const MaxIndicators = 10
+
+// Bar is one kline as the indicators see it.
+type Bar struct {
+	Time   time.Time
+	Open   float64
+	High   float64
+	Low    float64
+	Close  float64
+	Volume float64
+}
+
+// Indicator is the incremental state of one indicator.
+type Indicator interface {
+	// Update feeds the next bar and returns the indicator's outputs for it;
+	// ok is false while the indicator is still warming up.
+	Update(bar Bar) (values []float64, ok bool)
+	// WarmUp is the number of bars to feed before the first bar whose
+	// value is wanted.
+	WarmUp() int
+	// Clone returns an independent copy of the state, e.g. to preview the
+	// value of a bar that is still forming without feeding it for good.
+	Clone() Indicator
+}
+
+type definition struct {
+	defaults []float64
+	outputs  []string
+	build    func(params []float64) Indicator
+	// float marks parameters that may be fractional; the others are periods
+	float []bool
+}
+
+var registry = map[string]definition{
+	"sma":   {defaults: []float64{20}, outputs: []string{"value"}, build: func(p []float64) Indicator { return newSMA(int(p[0])) }},
+	"ema":   {defaults: []float64{20}, outputs: []string{"value"}, build: func(p []float64) Indicator { return newEMA(int(p[0])) }},
+	"wma":   {defaults: []float64{20}, outputs: []string{"value"}, build: func(p []float64) Indicator { return newWMA(int(p[0])) }},
+	"rsi":   {defaults: []float64{14}, outputs: []string{"value"}, build: func(p []float64) Indicator { return newRSI(int(p[0])) }},
+	"macd":  {defaults: []float64{12, 26, 9}, outputs: []string{"macd", "signal", "histogram"}, build: func(p []float64) Indicator { return newMACD(int(p[0]), int(p[1]), int(p[2])) }},
+	"bb":    {defaults: []float64{20, 2}, outputs: []string{"middle", "upper", "lower"}, float: []bool{false, true}, build: func(p []float64) Indicator { return newBollinger(int(p[0]), p[1]) }},
+	"atr":   {defaults: []float64{14}, outputs: []string{"value"}, build: func(p []float64) Indicator { return newATR(int(p[0])) }},
+	"vwap":  {defaults: []float64{20}, outputs: []string{"value"}, build: func(p []float64) Indicator { return newVWAP(int(p[0])) }},
+	"stoch": {defaults: []float64{14, 3}, outputs: []string{"k", "d"}, build: func(p []float64) Indicator { return newStochastic(int(p[0]), int(p[1])) }},
+}
+
+// Instance is one indicator selected by a request.
+type Instance struct {
+	// Name is the canonical spec with every parameter spelled out, e.g. "bb:20:2".
+	Name      string
+	Outputs   []string
+	Indicator Indicator
+}
+
+// Parse parses a comma separated list of specs such as "ema:50,rsi:14".
+// Duplicate specs are returned once.
+func Parse(list string) ([]Instance, error) {
+	var out []Instance
+	seen := make(map[string]bool)
+	for _, spec := range strings.Split(list, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		inst, err := New(spec)
+		if err != nil {
+			return nil, err
+		}
+		if seen[inst.Name] {
+			continue
+		}
+		seen[inst.Name] = true
+		out = append(out, inst)
+	}
+	if len(out) > MaxIndicators {
+		return nil, fmt.Errorf("at most %d indicators", MaxIndicators)
+	}
+	return out, nil
+}
+
+// New builds the indicator described by spec.
+func New(spec string) (Instance, error) {
+	parts := strings.Split(strings.ToLower(spec), ":")
+	def, ok := registry[parts[0]]
+	if !ok {
+		return Instance{}, fmt.Errorf("unknown indicator %q", parts[0])
+	}
+	if len(parts)-1 > len(def.defaults) {
+		return Instance{}, fmt.Errorf("%s takes at most %d parameters", parts[0], len(def.defaults))
+	}
+	params := append([]float64(nil), def.defaults...)
+	for i, raw := range parts[1:] {
+		p, err := strconv.ParseFloat(raw, 64)
+		// ParseFloat accepts "nan" and "inf", which no range check below rejects
+		if err != nil || math.IsNaN(p) || math.IsInf(p, 0) {
+			return Instance{}, fmt.Errorf("%s: invalid parameter %q", parts[0], raw)
+		}
+		if i < len(def.float) && def.float[i] {
+			if p <= 0 || p > 10 {
+				return Instance{}, fmt.Errorf("%s: parameter %q must be in (0, 10]", parts[0], raw)
+			}
+		} else if p != float64(int(p)) || p < 1 || p > MaxPeriod {
+			return Instance{}, fmt.Errorf("%s: period %q must be an integer from 1 to %d", parts[0], raw, MaxPeriod)
+		}
+		params[i] = p
+	}
+	if parts[0] == "macd" && params[0] >= params[1] {
+		return Instance{}, fmt.Errorf("macd: fast period must be shorter than the slow one")
+	}
+
+	name := parts[0]
+	for _, p := range params {
+		name += ":" + strconv.FormatFloat(p, 'f', -1, 64)
+	}
+	return Instance{Name: name, Outputs: def.outputs, Indicator: def.build(params)}, nil
+}
+
+// WarmUp returns the most bars any of instances needs before its first value.
+func WarmUp(instances []Instance) int {
+	n := 0
+	for _, inst := range instances {
+		n = max(n, inst.Indicator.WarmUp())
+	}
+	return n
+}
+
+// Point is the value of an indicator at one bar, in the order of its outputs.
+type Point struct {
+	Time   time.Time `json:"time"`
+	Values []float64 `json:"values"`
+}
+
+// Series is the output of one indicator instance.
+type Series struct {
+	Name    string   `json:"name"`
+	Outputs []string `json:"outputs"`
+	Points  []Point  `json:"points"`
+}
+
+// Compute feeds bars to every instance and returns their values for
+// bars[from:]; the bars before from only warm the indicators up.
+func Compute(instances []Instance, bars []Bar, from int) []Series {
+	out := make([]Series, len(instances))
+	for i, inst := range instances {
+		out[i] = Series{Name: inst.Name, Outputs: inst.Outputs, Points: []Point{}}
+		for j, bar := range bars {
+			values, ok := inst.Indicator.Update(bar)
+			if ok && j >= from {
+				out[i].Points = append(out[i].Points, Point{Time: bar.Time, Values: values})
+			}
+		}
+	}
+	return out
+}
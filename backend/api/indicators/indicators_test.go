@@ -0,0 +1,228 @@
+package indicators
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testBars are eight small klines whose indicator values were worked out by
+// hand from the textbook definitions.
+var testBars = func() []Bar {
+	ohlcv := [][5]float64{
+		{10, 11, 9, 10, 1},
+		{10, 12, 10, 11, 2},
+		{11, 13, 11, 12, 1},
+		{12, 12, 10, 11, 3},
+		{11, 14, 11, 13, 2},
+		{13, 15, 13, 14, 1},
+		{14, 14, 11, 12, 2},
+		{12, 16, 12, 15, 4},
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	bars := make([]Bar, len(ohlcv))
+	for i, v := range ohlcv {
+		bars[i] = Bar{Time: start.Add(time.Duration(i) * time.Minute), Open: v[0], High: v[1], Low: v[2], Close: v[3], Volume: v[4]}
+	}
+	return bars
+}()
+
+func TestIndicatorValues(t *testing.T) {
+	tests := []struct {
+		spec  string
+		first int // index of the first bar with a value
+		want  [][]float64
+	}{
+		{"sma:3", 2, [][]float64{{11}, {34.0 / 3}, {12}, {38.0 / 3}, {13}, {41.0 / 3}}},
+		{"ema:3", 2, [][]float64{{11}, {11}, {12}, {13}, {12.5}, {13.75}}},
+		{"wma:3", 2, [][]float64{{68.0 / 6}, {68.0 / 6}, {73.0 / 6}, {79.0 / 6}, {77.0 / 6}, {83.0 / 6}}},
+		{"rsi:3", 3, [][]float64{{200.0 / 3}, {250.0 / 3}, {87.87878787878788}, {48.333333333333336}, {74.3271221532091}}},
+		{"macd:2:3:2", 3, [][]float64{
+			{1.0 / 6, 1.0 / 3, -1.0 / 6},
+			{0.3888888888888893, 0.3703703703703705, 0.018518518518518767},
+			{0.4629629629629637, 0.4320987654320993, 0.03086419753086439},
+			{-0.012345679012344846, 0.13580246913580324, -0.14814814814814808},
+			{0.412551440329219, 0.32030178326474706, 0.09224965706447191},
+		}},
+		{"bb:3:2", 2, [][]float64{
+			{11, 12.632993161855453, 9.367006838144547},
+			{34.0 / 3, 12.276142374915397, 10.390524291751271},
+			{12, 13.632993161855453, 10.367006838144547},
+			{38.0 / 3, 15.16110492451596, 10.172228408817372},
+			{13, 14.632993161855453, 11.367006838144547},
+			{41.0 / 3, 16.16110492451596, 11.172228408817372},
+		}},
+		{"atr:3", 2, [][]float64{{2}, {2}, {7.0 / 3}, {20.0 / 9}, {67.0 / 27}, {242.0 / 81}}},
+		{"vwap:3", 2, [][]float64{{11}, {67.0 / 6}, {211.0 / 18}, {217.0 / 18}, {12.8}, {96.0 / 7}}},
+		{"stoch:3:2", 3, [][]float64{{100.0 / 3, 325.0 / 6}, {75, 325.0 / 6}, {80, 77.5}, {25, 52.5}, {80, 52.5}}},
+	}
+	for _, tt := range tests {
+		inst, err := New(tt.spec)
+		if err != nil {
+			t.Fatalf("New(%q): %v", tt.spec, err)
+		}
+		for i, bar := range testBars {
+			values, ok := inst.Indicator.Update(bar)
+			if i < tt.first {
+				if ok {
+					t.Errorf("%s: bar %d has value %v during warm-up", tt.spec, i, values)
+				}
+				continue
+			}
+			want := tt.want[i-tt.first]
+			if !ok || !approxEqual(values, want) {
+				t.Errorf("%s: bar %d = %v (ok %v), want %v", tt.spec, i, values, ok, want)
+			}
+		}
+	}
+}
+
+func approxEqual(got, want []float64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if math.Abs(got[i]-want[i]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRSIWithoutLosses(t *testing.T) {
+	inst, _ := New("rsi:2")
+	var values []float64
+	for i := range 4 {
+		values, _ = inst.Indicator.Update(Bar{Close: float64(100 + i)})
+	}
+	if len(values) != 1 || values[0] != 100 {
+		t.Fatalf("rsi of a rising close = %v, want 100", values)
+	}
+}
+
+func TestStochasticFlatRange(t *testing.T) {
+	inst, _ := New("stoch:2:1")
+	var values []float64
+	for range 2 {
+		values, _ = inst.Indicator.Update(Bar{High: 5, Low: 5, Close: 5})
+	}
+	if len(values) != 2 || values[0] != 50 || values[1] != 50 {
+		t.Fatalf("stoch of a flat range = %v, want [50 50]", values)
+	}
+}
+
+func TestWarmUp(t *testing.T) {
+	tests := []struct {
+		spec   string
+		warmUp int
+	}{
+		{"sma:20", 19},
+		{"ema:20", 72},
+		{"wma:10", 9},
+		{"rsi:14", 86},
+		{"macd:12:26:9", 126},
+		{"bb:20:2", 19},
+		{"atr:14", 85},
+		{"vwap:20", 19},
+		{"stoch:14:3", 15},
+	}
+	for _, tt := range tests {
+		inst, err := New(tt.spec)
+		if err != nil {
+			t.Fatalf("New(%q): %v", tt.spec, err)
+		}
+		if got := inst.Indicator.WarmUp(); got != tt.warmUp {
+			t.Errorf("%s: WarmUp() = %d, want %d", tt.spec, got, tt.warmUp)
+		}
+	}
+
+	instances, err := Parse("sma:20,rsi:14,stoch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := WarmUp(instances); got != 86 {
+		t.Errorf("WarmUp of sma:20,rsi:14,stoch = %d, want 86", got)
+	}
+}
+
+// TestWarmUpSettles checks that once WarmUp bars have been fed, an
+// exponential indicator no longer depends noticeably on where it started.
+func TestWarmUpSettles(t *testing.T) {
+	closes := make([]float64, 200)
+	for i := range closes {
+		closes[i] = 100 + 10*math.Sin(float64(i)/7)
+	}
+	for _, spec := range []string{"ema:10", "rsi:10", "atr:10", "macd:5:10:4"} {
+		full, _ := New(spec)
+		late, _ := New(spec)
+		skip := 50
+		var fullValues, lateValues []float64
+		for i, c := range closes {
+			bar := Bar{High: c + 1, Low: c - 1, Close: c}
+			fullValues, _ = full.Indicator.Update(bar)
+			if i >= skip {
+				lateValues, _ = late.Indicator.Update(bar)
+			}
+			if i == skip+late.Indicator.WarmUp() {
+				break
+			}
+		}
+		for i := range fullValues {
+			scale := max(math.Abs(fullValues[i]), 1)
+			if math.Abs(fullValues[i]-lateValues[i])/scale > 0.01 {
+				t.Errorf("%s: after warm-up got %v, the long run has %v", spec, lateValues, fullValues)
+				break
+			}
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	instances, err := Parse("EMA:50, rsi, bb:20:2.5, ema:50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, inst := range instances {
+		names = append(names, inst.Name)
+	}
+	if got := strings.Join(names, ","); got != "ema:50,rsi:14,bb:20:2.5" {
+		t.Errorf("names = %s, want ema:50,rsi:14,bb:20:2.5", got)
+	}
+
+	for _, list := range []string{
+		"foo",
+		"ema:0",
+		"ema:501",
+		"ema:2.5",
+		"ema:x",
+		"ema:10:20",
+		"bb:20:11",
+		"bb:20:nan",
+		"bb:20:inf",
+		"ema:nan",
+		"macd:26:12:9",
+		"sma:1,sma:2,sma:3,sma:4,sma:5,sma:6,sma:7,sma:8,sma:9,sma:10,sma:11",
+	} {
+		if _, err := Parse(list); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", list)
+		}
+	}
+}
+
+func TestCompute(t *testing.T) {
+	instances, _ := Parse("sma:3,stoch:3:2")
+	series := Compute(instances, testBars, 4)
+	if len(series) != 2 || series[0].Name != "sma:3" || series[1].Name != "stoch:3:2" {
+		t.Fatalf("got series %+v", series)
+	}
+	for _, s := range series {
+		if len(s.Points) != 4 || !s.Points[0].Time.Equal(testBars[4].Time) {
+			t.Errorf("%s: got %d points from %v, want 4 from bar 4", s.Name, len(s.Points), s.Points)
+		}
+	}
+	if v := series[0].Points[0].Values; !approxEqual(v, []float64{12}) {
+		t.Errorf("sma:3 at bar 4 = %v, want [12]", v)
+	}
+}
@@ -0,0 +1,106 @@
+package indicators
+
+// rsi is Wilder's relative strength index of the close.
+type rsi struct {
+	period  int
+	prev    float64
+	started bool
+	gain    *ema
+	loss    *ema
+}
+
+func newRSI(period int) *rsi {
+	return &rsi{period: period, gain: newWilder(period), loss: newWilder(period)}
+}
+
+func (r *rsi) Update(bar Bar) ([]float64, bool) {
+	if !r.started {
+		r.prev, r.started = bar.Close, true
+		return nil, false
+	}
+	change := bar.Close - r.prev
+	r.prev = bar.Close
+	r.gain.add(max(change, 0))
+	if !r.loss.add(max(-change, 0)) {
+		return nil, false
+	}
+	if r.loss.value == 0 {
+		return []float64{100}, true
+	}
+	return []float64{100 - 100/(1+r.gain.value/r.loss.value)}, true
+}
+
+func (r *rsi) WarmUp() int { return r.loss.WarmUp() + 1 }
+
+func (r *rsi) Clone() Indicator {
+	c := *r
+	c.gain, c.loss = r.gain.clone(), r.loss.clone()
+	return &c
+}
+
+// macd is the difference of a fast and a slow EMA of the close, its EMA
+// (the signal line) and their difference.
+type macd struct {
+	fast, slow, signal *ema
+}
+
+func newMACD(fast, slow, signal int) *macd {
+	return &macd{fast: newEMA(fast), slow: newEMA(slow), signal: newEMA(signal)}
+}
+
+func (m *macd) Update(bar Bar) ([]float64, bool) {
+	m.fast.add(bar.Close)
+	if !m.slow.add(bar.Close) {
+		return nil, false
+	}
+	line := m.fast.value - m.slow.value
+	if !m.signal.add(line) {
+		return nil, false
+	}
+	return []float64{line, m.signal.value, line - m.signal.value}, true
+}
+
+func (m *macd) WarmUp() int { return m.slow.WarmUp() + m.signal.WarmUp() }
+
+func (m *macd) Clone() Indicator {
+	return &macd{fast: m.fast.clone(), slow: m.slow.clone(), signal: m.signal.clone()}
+}
+
+// stochastic is the fast stochastic oscillator: %K places the close within
+// the high-low range of the last kPeriod bars, %D is the SMA of %K.
+type stochastic struct {
+	kPeriod int
+	count   int
+	highest *extremes
+	lowest  *extremes
+	d       *window
+}
+
+func newStochastic(kPeriod, dPeriod int) *stochastic {
+	return &stochastic{kPeriod: kPeriod, highest: newExtremes(kPeriod, true), lowest: newExtremes(kPeriod, false), d: newWindow(dPeriod)}
+}
+
+func (s *stochastic) Update(bar Bar) ([]float64, bool) {
+	s.highest.push(bar.High)
+	s.lowest.push(bar.Low)
+	if s.count++; s.count < s.kPeriod {
+		return nil, false
+	}
+	k := 50.0 // a flat range has no position in it
+	if hi, lo := s.highest.get(), s.lowest.get(); hi > lo {
+		k = 100 * (bar.Close - lo) / (hi - lo)
+	}
+	s.d.push(k)
+	if !s.d.full() {
+		return nil, false
+	}
+	return []float64{k, s.d.mean()}, true
+}
+
+func (s *stochastic) WarmUp() int { return s.kPeriod - 1 + len(s.d.values) - 1 }
+
+func (s *stochastic) Clone() Indicator {
+	c := *s
+	c.highest, c.lowest, c.d = s.highest.clone(), s.lowest.clone(), s.d.clone()
+	return &c
+}
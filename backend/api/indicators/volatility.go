@@ -0,0 +1,67 @@
+package indicators
+
+import "math"
+
+// bollinger is an SMA of the close with bands width standard deviations
+// above and below it.
+type bollinger struct {
+	w     *window
+	sumSq float64
+	width float64
+}
+
+func newBollinger(period int, width float64) *bollinger {
+	return &bollinger{w: newWindow(period), width: width}
+}
+
+func (b *bollinger) Update(bar Bar) ([]float64, bool) {
+	if evicted, full := b.w.push(bar.Close); full {
+		b.sumSq -= evicted * evicted
+	}
+	b.sumSq += bar.Close * bar.Close
+	if !b.w.full() {
+		return nil, false
+	}
+	mean := b.w.mean()
+	// population variance; clamp the rounding error of the running sums
+	sd := math.Sqrt(max(b.sumSq/float64(b.w.count)-mean*mean, 0))
+	return []float64{mean, mean + b.width*sd, mean - b.width*sd}, true
+}
+
+func (b *bollinger) WarmUp() int { return len(b.w.values) - 1 }
+
+func (b *bollinger) Clone() Indicator {
+	c := *b
+	c.w = b.w.clone()
+	return &c
+}
+
+// atr is Wilder's average true range.
+type atr struct {
+	period    int
+	prevClose float64
+	started   bool
+	avg       *ema
+}
+
+func newATR(period int) *atr { return &atr{period: period, avg: newWilder(period)} }
+
+func (a *atr) Update(bar Bar) ([]float64, bool) {
+	tr := bar.High - bar.Low
+	if a.started {
+		tr = max(tr, math.Abs(bar.High-a.prevClose), math.Abs(bar.Low-a.prevClose))
+	}
+	a.prevClose, a.started = bar.Close, true
+	if !a.avg.add(tr) {
+		return nil, false
+	}
+	return []float64{a.avg.value}, true
+}
+
+func (a *atr) WarmUp() int { return a.avg.WarmUp() }
+
+func (a *atr) Clone() Indicator {
+	c := *a
+	c.avg = a.avg.clone()
+	return &c
+}
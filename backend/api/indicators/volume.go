@@ -0,0 +1,32 @@
+package indicators
+
+// vwap is the volume weighted average of the typical price (high+low+close)/3
+// over the last period bars. A rolling window rather than a session anchor
+// keeps its warm-up fixed, so a page of klines never needs a whole day before it.
+type vwap struct {
+	priceVolume *window
+	volume      *window
+}
+
+func newVWAP(period int) *vwap {
+	return &vwap{priceVolume: newWindow(period), volume: newWindow(period)}
+}
+
+func (v *vwap) Update(bar Bar) ([]float64, bool) {
+	typical := (bar.High + bar.Low + bar.Close) / 3
+	v.priceVolume.push(typical * bar.Volume)
+	v.volume.push(bar.Volume)
+	if !v.volume.full() {
+		return nil, false
+	}
+	if v.volume.sum <= 0 {
+		return []float64{typical}, true
+	}
+	return []float64{v.priceVolume.sum / v.volume.sum}, true
+}
+
+func (v *vwap) WarmUp() int { return len(v.volume.values) - 1 }
+
+func (v *vwap) Clone() Indicator {
+	return &vwap{priceVolume: v.priceVolume.clone(), volume: v.volume.clone()}
+}
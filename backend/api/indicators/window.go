@@ -0,0 +1,67 @@
+package indicators
+
+// window is a fixed-size ring of the latest values with their running sum.
+type window struct {
+	values []float64
+	next   int
+	count  int
+	sum    float64
+}
+
+func newWindow(size int) *window { return &window{values: make([]float64, size)} }
+
+// push adds v and returns the value it evicted, if the window was full.
+func (w *window) push(v float64) (evicted float64, full bool) {
+	if w.count == len(w.values) {
+		evicted, full = w.values[w.next], true
+		w.sum -= evicted
+	} else {
+		w.count++
+	}
+	w.values[w.next] = v
+	w.next = (w.next + 1) % len(w.values)
+	w.sum += v
+	return evicted, full
+}
+
+func (w *window) clone() *window {
+	c := *w
+	c.values = append([]float64(nil), w.values...)
+	return &c
+}
+
+func (w *window) full() bool    { return w.count == len(w.values) }
+func (w *window) mean() float64 { return w.sum / float64(w.count) }
+
+// extremes tracks the maximum or minimum of the latest size values with a
+// monotonic deque, in amortised O(1) per value.
+type extremes struct {
+	size  int
+	max   bool
+	seq   int
+	index []int
+	value []float64
+}
+
+func newExtremes(size int, max bool) *extremes { return &extremes{size: size, max: max} }
+
+func (e *extremes) push(v float64) {
+	for n := len(e.value); n > 0 && (e.max && e.value[n-1] <= v || !e.max && e.value[n-1] >= v); n-- {
+		e.value, e.index = e.value[:n-1], e.index[:n-1]
+	}
+	e.value, e.index = append(e.value, v), append(e.index, e.seq)
+	if e.index[0] <= e.seq-e.size {
+		e.value, e.index = e.value[1:], e.index[1:]
+	}
+	e.seq++
+}
+
+func (e *extremes) clone() *extremes {
+	c := *e
+	c.index = append([]int(nil), e.index...)
+	c.value = append([]float64(nil), e.value...)
+	return &c
+}
+
+// get returns the extreme of the values in the window.
+func (e *extremes) get() float64 { return e.value[0] }
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"rdr/api/indicators"
+	"rdr/common/config"
+	"rdr/common/symbols"
+)
+
+const (
+	defaultKlineLimit = 500
+	maxKlineLimit     = 1000
+	// month buckets are at most 31 days long
+	maxMonth = 31 * 24 * time.Hour
+)
+
+// Only the configured intervals are archived (klines_<interval>). Every other interval in
+// config.ValidKlineIntervals is aggregated on request with time_bucket from the largest stored
+// interval that divides it, so the stored tables stay the single source of truth and nothing
+// has to be kept in sync with them.
+
+// intervalDurations are the fixed-length Binance intervals; 1M is a calendar month
+var intervalDurations = map[string]time.Duration{
+	"1s": time.Second, "1m": time.Minute, "3m": 3 * time.Minute, "5m": 5 * time.Minute,
+	"15m": 15 * time.Minute, "30m": 30 * time.Minute, "1h": time.Hour, "2h": 2 * time.Hour,
+	"4h": 4 * time.Hour, "6h": 6 * time.Hour, "8h": 8 * time.Hour, "12h": 12 * time.Hour,
+	"1d": 24 * time.Hour, "3d": 72 * time.Hour, "1w": 7 * 24 * time.Hour,
+}
+
+// Bucket origins matching Binance: intervals up to 3d are aligned to the Unix epoch,
+// weeks start on Monday and months on the 1st
+var (
+	epochOrigin = time.Unix(0, 0).UTC()
+	weekOrigin  = time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC)
+	monthOrigin = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// klineSource is where the klines of one interval are read from
+type klineSource struct {
+	Table string
+	// Bucket is the Postgres interval to aggregate the table's rows into, empty when the
+	// requested interval is stored as is
+	Bucket string
+	Origin time.Time
+	// Width is the bucket length, at its longest for months
+	Width time.Duration
+}
+
+// divides reports whether klines of interval src add up exactly to klines of interval dst
+func divides(src, dst string) bool {
+	s, ok := intervalDurations[src]
+	if !ok {
+		return false
+	}
+	if dst == "1M" {
+		// Months start at midnight UTC, so any interval that divides a day fits them
+		return (24*time.Hour)%s == 0
+	}
+	d, ok := intervalDurations[dst]
+	return ok && d%s == 0
+}
+
+// resolveKlineSource picks the table serving interval: its own table when it is archived,
+// otherwise the largest archived interval it can be aggregated from
+func resolveKlineSource(market config.MarketConfig, interval string) (klineSource, bool) {
+	if !slices.Contains(config.ValidKlineIntervals, interval) {
+		return klineSource{}, false
+	}
+	if slices.Contains(market.KlineIntervals, interval) {
+		return klineSource{Table: fmt.Sprintf("klines_%s", interval)}, true
+	}
+	var best string
+	for _, stored := range market.KlineIntervals {
+		if divides(stored, interval) && (best == "" || intervalDurations[stored] > intervalDurations[best]) {
+			best = stored
+		}
+	}
+	if best == "" {
+		return klineSource{}, false
+	}
+	source := klineSource{Table: fmt.Sprintf("klines_%s", best), Origin: epochOrigin, Width: intervalDurations[interval]}
+	switch interval {
+	case "1M":
+		source.Bucket, source.Origin, source.Width = "1 month", monthOrigin, maxMonth
+	case "1w":
+		source.Bucket, source.Origin = "7 days", weekOrigin
+	default:
+		source.Bucket = fmt.Sprintf("%d seconds", int64(intervalDurations[interval]/time.Second))
+	}
+	return source, true
+}
+
+// KlinesResponse is one page of GET /klines. Klines are in ascending time order; pages run from
+// the newest klines to older ones.
+type KlinesResponse struct {
+	Symbol   string        `json:"symbol"`
+	Interval string        `json:"interval"`
+	Klines   []KlineRecord `json:"klines"`
+	// Indicators holds one series per requested indicator, covering the klines of this page
+	Indicators []indicators.Series `json:"indicators,omitempty"`
+	// NextCursor requests the page before this one; it is omitted on the oldest page
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// Cursors are opaque to clients; they carry the open time the next page ends before
+func encodeCursor(before time.Time) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(before.UnixMilli(), 10)))
+}
+
+func decodeCursor(cursor string) (time.Time, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, err
+	}
+	ms, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(ms).UTC(), nil
+}
+
+// querier runs the kline queries; *pgxpool.Pool in the service
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// queryKlines returns up to n klines of symbol on exchange opening in [from, to), newest first.
+// Aggregated intervals only read source rows from scanFrom on, which bounds the work of one page.
+func queryKlines(ctx context.Context, db querier, source klineSource, exchange, symbol string, from, to, scanFrom time.Time, n int) ([]KlineRecord, error) {
+	querySQL := fmt.Sprintf(`
+		SELECT time, symbol, open, high, low, close, volume FROM %s
+		WHERE symbol = $1 AND exchange = $5 AND time >= $2 AND time < $3
+		ORDER BY time DESC LIMIT $4;`, source.Table)
+	args := []any{symbol, from, to, n, exchange}
+	if source.Bucket != "" {
+		// Source rows are read in whole buckets, so the oldest and newest buckets are complete
+		querySQL = fmt.Sprintf(`
+			SELECT bucket, symbol, open, high, low, close, volume FROM (
+				SELECT time_bucket($6::interval, time, $7::timestamptz) AS bucket, symbol,
+					first(open, time) AS open, max(high) AS high, min(low) AS low, last(close, time) AS close,
+					sum(volume) AS volume
+				FROM %s
+				WHERE symbol = $1 AND exchange = $5
+					AND time >= time_bucket($6::interval, $8::timestamptz, $7::timestamptz)
+					AND time < time_bucket($6::interval, $3::timestamptz, $7::timestamptz) + $6::interval
+				GROUP BY bucket, symbol
+			) k
+			WHERE bucket >= $2 AND bucket < $3
+			ORDER BY bucket DESC LIMIT $4;`, source.Table)
+		args = append(args, source.Bucket, source.Origin, scanFrom)
+	}
+	rows, err := db.Query(ctx, querySQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var klines []KlineRecord
+	for rows.Next() {
+		var k KlineRecord
+		if err := rows.Scan(&k.Time, &k.Symbol, &k.Open, &k.High, &k.Low, &k.Close, &k.Volume); err != nil {
+			log.Printf("DB Scan Error: %v", err)
+			continue
+		}
+		klines = append(klines, k)
+	}
+	return klines, rows.Err()
+}
+
+// toBars converts klines, oldest first, for the indicators
+func toBars(klines []KlineRecord) []indicators.Bar {
+	bars := make([]indicators.Bar, len(klines))
+	for i, k := range klines {
+		bars[i] = indicators.Bar{Time: k.Time, Open: k.Open.Float64(), High: k.High.Float64(), Low: k.Low.Float64(), Close: k.Close.Float64(), Volume: k.Volume.Float64()}
+	}
+	return bars
+}
+
+// getKlinesHandler: GET /klines?symbol=BTCUSDT&interval=1h[&from=..][&to=..][&limit=500][&cursor=..][&indicators=ema:50,rsi:14]
+// returns the newest `limit` klines opening in [from, to); from and to are RFC 3339 or Unix
+// milliseconds. The response's nextCursor, passed back as cursor, returns the klines before them.
+// Indicators are warmed up on older klines, so their series cover every kline of the page.
+// Only klines of exchange, the venue the ingestor streams from, are served.
+func getKlinesHandler(db querier, exchange string, market config.MarketConfig, registry *symbols.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		symbol := strings.ToUpper(query.Get("symbol"))
+		interval := query.Get("interval")
+		if symbol == "" || interval == "" {
+			http.Error(w, "Missing params", http.StatusBadRequest)
+			return
+		}
+		if !validSymbol(market, registry, symbol) {
+			http.Error(w, "Unknown symbol", http.StatusBadRequest)
+			return
+		}
+		// Intervals not archived are aggregated from a finer one; the source ends up in a
+		// table name, so only known intervals are accepted
+		source, ok := resolveKlineSource(market, interval)
+		if !ok {
+			http.Error(w, "Unsupported interval", http.StatusBadRequest)
+			return
+		}
+
+		from, to := epochOrigin, time.Now().UTC()
+		var err error
+		if v := query.Get("from"); v != "" {
+			if from, err = parseAt(v); err != nil {
+				http.Error(w, "Invalid from", http.StatusBadRequest)
+				return
+			}
+		}
+		if v := query.Get("to"); v != "" {
+			if to, err = parseAt(v); err != nil {
+				http.Error(w, "Invalid to", http.StatusBadRequest)
+				return
+			}
+		}
+		if v := query.Get("cursor"); v != "" {
+			before, err := decodeCursor(v)
+			if err != nil {
+				http.Error(w, "Invalid cursor", http.StatusBadRequest)
+				return
+			}
+			if before.Before(to) {
+				to = before
+			}
+		}
+		instances, err := indicators.Parse(query.Get("indicators"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid indicators: %v", err), http.StatusBadRequest)
+			return
+		}
+		limit := defaultKlineLimit
+		if v := query.Get("limit"); v != "" {
+			limit, err = strconv.Atoi(v)
+			if err != nil || limit < 1 || limit > maxKlineLimit {
+				http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxKlineLimit), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// One extra kline tells whether there is an older page; the indicators warm up on the ones before it
+		n := limit + 1 + indicators.WarmUp(instances)
+		// An aggregated page reads at most twice the source rows it needs, leaving room for gaps
+		scanFrom := from
+		if source.Bucket != "" {
+			if window := to.Add(-2 * time.Duration(n) * source.Width); window.After(from) {
+				scanFrom = window
+			}
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+		klines, err := queryKlines(ctx, db, source, exchange, symbol, from, to, scanFrom, n)
+		if err != nil {
+			log.Printf("Kline query for %s %s failed: %v", symbol, interval, err)
+			http.Error(w, "DB query failed", http.StatusInternalServerError)
+			return
+		}
+		slices.Reverse(klines)
+
+		resp := KlinesResponse{Symbol: symbol, Interval: interval, Klines: klines}
+		if len(klines) > limit {
+			resp.Klines = klines[len(klines)-limit:]
+			resp.NextCursor = encodeCursor(resp.Klines[0].Time)
+		} else if scanFrom.After(from) {
+			// The scan window ended the page early; continue below it if anything older exists
+			before := scanFrom
+			if len(klines) > 0 {
+				before = klines[0].Time
+			}
+			var older bool
+			err := db.QueryRow(ctx, fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s WHERE symbol = $1 AND exchange = $4 AND time >= $2 AND time < $3)`, source.Table), symbol, from, before, exchange).Scan(&older)
+			if err != nil {
+				log.Printf("Kline query for %s %s failed: %v", symbol, interval, err)
+			}
+			if older {
+				resp.NextCursor = encodeCursor(before)
+			}
+		}
+		if len(instances) > 0 {
+			resp.Indicators = indicators.Compute(instances, toBars(klines), len(klines)-len(resp.Klines))
+		}
+		if resp.Klines == nil {
+			resp.Klines = []KlineRecord{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"rdr/common/config"
+	"rdr/common/decimal"
+	"rdr/common/symbols"
+)
+
+func TestResolveKlineSource(t *testing.T) {
+	tests := []struct {
+		name     string
+		stored   []string
+		interval string
+		ok       bool
+		want     klineSource
+	}{
+		{
+			name:     "stored",
+			stored:   []string{"1m", "5m"},
+			interval: "5m",
+			ok:       true,
+			want:     klineSource{Table: "klines_5m"},
+		},
+		{
+			name:     "15m from 5m",
+			stored:   []string{"1m", "5m"},
+			interval: "15m",
+			ok:       true,
+			want:     klineSource{Table: "klines_5m", Bucket: "900 seconds", Origin: epochOrigin, Width: 15 * time.Minute},
+		},
+		{
+			name:     "1h from 1m",
+			stored:   []string{"1m"},
+			interval: "1h",
+			ok:       true,
+			want:     klineSource{Table: "klines_1m", Bucket: "3600 seconds", Origin: epochOrigin, Width: time.Hour},
+		},
+		{
+			// 3m does not divide 5m, so the finer 1m is used
+			name:     "largest divisor",
+			stored:   []string{"1m", "3m"},
+			interval: "5m",
+			ok:       true,
+			want:     klineSource{Table: "klines_1m", Bucket: "300 seconds", Origin: epochOrigin, Width: 5 * time.Minute},
+		},
+		{
+			name:     "1w starts on Monday",
+			stored:   []string{"1m", "1d"},
+			interval: "1w",
+			ok:       true,
+			want:     klineSource{Table: "klines_1d", Bucket: "7 days", Origin: weekOrigin, Width: 7 * 24 * time.Hour},
+		},
+		{
+			name:     "1M from 1d",
+			stored:   []string{"1m", "1d"},
+			interval: "1M",
+			ok:       true,
+			want:     klineSource{Table: "klines_1d", Bucket: "1 month", Origin: monthOrigin, Width: maxMonth},
+		},
+		{
+			// 3d does not fit months, so 1M falls back to 1h
+			name:     "1M skips 3d",
+			stored:   []string{"1h", "3d"},
+			interval: "1M",
+			ok:       true,
+			want:     klineSource{Table: "klines_1h", Bucket: "1 month", Origin: monthOrigin, Width: maxMonth},
+		},
+		{
+			name:     "no stored interval divides",
+			stored:   []string{"3m"},
+			interval: "5m",
+		},
+		{
+			name:     "finer than stored",
+			stored:   []string{"1m"},
+			interval: "1s",
+		},
+		{
+			name:     "unknown interval",
+			stored:   []string{"1m"},
+			interval: "7m",
+		},
+		{
+			name:     "table name injection",
+			stored:   []string{"1m"},
+			interval: "1m; DROP TABLE trades",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := resolveKlineSource(config.MarketConfig{KlineIntervals: tt.stored}, tt.interval)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("resolveKlineSource(%v, %q) = %+v, %v; want %+v, %v", tt.stored, tt.interval, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDivides(t *testing.T) {
+	tests := []struct {
+		src, dst string
+		want     bool
+	}{
+		{"5m", "15m", true},
+		{"1m", "1h", true},
+		{"3m", "1h", true},
+		{"3m", "5m", false},
+		{"1h", "1m", false},
+		{"8h", "1d", true},
+		{"1d", "1w", true},
+		{"1d", "1M", true},
+		{"3d", "1M", false},
+		{"1w", "1M", false},
+		{"1M", "1M", false},
+		{"7m", "1h", false},
+	}
+	for _, tt := range tests {
+		if got := divides(tt.src, tt.dst); got != tt.want {
+			t.Errorf("divides(%s, %s) = %v, want %v", tt.src, tt.dst, got, tt.want)
+		}
+	}
+}
+
+// fakeKlineDB serves Binance klines of a stored interval the way the plain SELECT of
+// queryKlines would, and records the arguments of every query
+type fakeKlineDB struct {
+	klines []KlineRecord
+	args   [][]any
+}
+
+func (db *fakeKlineDB) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	db.args = append(db.args, args)
+	symbol, from, to, n, exchange := args[0].(string), args[1].(time.Time), args[2].(time.Time), args[3].(int), args[4].(string)
+	var rows []KlineRecord
+	for _, k := range slices.Backward(db.klines) {
+		if exchange == "binance" && k.Symbol == symbol && !k.Time.Before(from) && k.Time.Before(to) && len(rows) < n {
+			rows = append(rows, k)
+		}
+	}
+	return &fakeKlineRows{rows: rows, i: -1}, nil
+}
+
+func (db *fakeKlineDB) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	panic("unexpected QueryRow for a stored interval")
+}
+
+type fakeKlineRows struct {
+	pgx.Rows
+	rows []KlineRecord
+	i    int
+}
+
+func (r *fakeKlineRows) Next() bool { r.i++; return r.i < len(r.rows) }
+func (r *fakeKlineRows) Close()     {}
+func (r *fakeKlineRows) Err() error { return nil }
+
+func (r *fakeKlineRows) Scan(dest ...any) error {
+	k := r.rows[r.i]
+	*dest[0].(*time.Time), *dest[1].(*string) = k.Time, k.Symbol
+	for i, v := range []decimal.Decimal{k.Open, k.High, k.Low, k.Close, k.Volume} {
+		*dest[2+i].(*decimal.Decimal) = v
+	}
+	return nil
+}
+
+var klineStart = time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+// newFakeKlineDB holds n BTCUSDT 1m klines from klineStart on
+func newFakeKlineDB(n int) *fakeKlineDB {
+	db := &fakeKlineDB{}
+	for i := range n {
+		price := decimal.Decimal(100+i) * decimal.Scale
+		db.klines = append(db.klines, KlineRecord{Time: klineStart.Add(time.Duration(i) * time.Minute), Symbol: "BTCUSDT", Open: price, High: price, Low: price, Close: price, Volume: decimal.Scale})
+	}
+	return db
+}
+
+func getKlines(t *testing.T, db querier, query string) (*httptest.ResponseRecorder, KlinesResponse) {
+	t.Helper()
+	market := config.MarketConfig{Symbols: []string{"BTCUSDT"}, KlineIntervals: []string{"1m"}}
+	rec := httptest.NewRecorder()
+	getKlinesHandler(db, "binance", market, symbols.NewRegistry(nil))(rec, httptest.NewRequest(http.MethodGet, "/klines?symbol=BTCUSDT&interval=1m&"+query, nil))
+	var resp KlinesResponse
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec, resp
+}
+
+func klineMinutes(klines []KlineRecord) []int {
+	minutes := make([]int, len(klines))
+	for i, k := range klines {
+		minutes[i] = int(k.Time.Sub(klineStart) / time.Minute)
+	}
+	return minutes
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	at := time.Date(2025, 6, 1, 14, 3, 22, 150_000_000, time.UTC)
+	got, err := decodeCursor(encodeCursor(at))
+	if err != nil || !got.Equal(at) {
+		t.Errorf("decodeCursor(encodeCursor(%s)) = %s, %v", at, got, err)
+	}
+	for _, cursor := range []string{"not base64!", encodeCursor(at) + "==", "YWJj"} {
+		if _, err := decodeCursor(cursor); err == nil {
+			t.Errorf("decodeCursor(%q) accepted a malformed cursor", cursor)
+		}
+	}
+}
+
+func TestGetKlinesInvalidParams(t *testing.T) {
+	for _, query := range []string{"cursor=YWJj", "cursor=%21%21", "limit=0", "limit=1001", "limit=-5", "limit=ten", "from=yesterday", "to=later", "indicators=bb:20:nan"} {
+		db := &fakeKlineDB{}
+		if rec, _ := getKlines(t, db, query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", query, rec.Code)
+		}
+		if len(db.args) != 0 {
+			t.Errorf("%s: queried the database for an invalid request", query)
+		}
+	}
+}
+
+func TestGetKlinesPages(t *testing.T) {
+	db := newFakeKlineDB(5)
+	_, page := getKlines(t, db, "limit=3")
+	if got := klineMinutes(page.Klines); !slices.Equal(got, []int{2, 3, 4}) {
+		t.Fatalf("first page = minutes %v, want the newest 2-4", got)
+	}
+	if page.NextCursor != encodeCursor(page.Klines[0].Time) {
+		t.Fatalf("nextCursor = %q, want one ending before minute 2", page.NextCursor)
+	}
+
+	_, page = getKlines(t, db, "limit=3&cursor="+page.NextCursor)
+	if got := klineMinutes(page.Klines); !slices.Equal(got, []int{0, 1}) {
+		t.Errorf("last page = minutes %v, want 0-1", got)
+	}
+	if page.NextCursor != "" {
+		t.Errorf("nextCursor = %q on the last page, want none", page.NextCursor)
+	}
+
+	// klines of another venue with the same symbol are not served
+	klines, err := queryKlines(context.Background(), db, klineSource{Table: "klines_1m"}, "kraken", "BTCUSDT", klineStart, klineStart.Add(time.Hour), klineStart, 10)
+	if err != nil || len(klines) != 0 {
+		t.Errorf("kraken klines = %v, %v; want none", klines, err)
+	}
+
+	// a page that ends exactly at the oldest kline has nothing before it either
+	_, page = getKlines(t, newFakeKlineDB(3), "limit=3")
+	if len(page.Klines) != 3 || page.NextCursor != "" {
+		t.Errorf("got %d klines and nextCursor %q, want 3 and none", len(page.Klines), page.NextCursor)
+	}
+}
+
+func TestGetKlinesCursorClampsTo(t *testing.T) {
+	to := klineStart.Add(10 * time.Minute)
+	tests := []struct {
+		name   string
+		cursor time.Time
+		want   time.Time
+	}{
+		{"cursor before to", klineStart.Add(3 * time.Minute), klineStart.Add(3 * time.Minute)},
+		{"cursor after to", klineStart.Add(20 * time.Minute), to},
+	}
+	for _, tt := range tests {
+		db := newFakeKlineDB(30)
+		_, page := getKlines(t, db, "to="+to.Format(time.RFC3339)+"&cursor="+encodeCursor(tt.cursor))
+		if got := db.args[0][2].(time.Time); !got.Equal(tt.want) {
+			t.Errorf("%s: queried up to %s, want %s", tt.name, got, tt.want)
+		}
+		if last := page.Klines[len(page.Klines)-1].Time; !last.Before(tt.want) {
+			t.Errorf("%s: page ends at %s, want before %s", tt.name, last, tt.want)
+		}
+	}
+}
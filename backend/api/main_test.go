@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/nats-io/nats.go"
+
+	"rdr/common/config"
+	"rdr/common/lifecycle/lifecycletest"
+	"rdr/common/symbols"
+)
+
+// Shutdown drains NATS and sends every websocket client a going-away close frame
+func TestShutdownClosesWebsockets(t *testing.T) {
+	fake := lifecycletest.NewFakeNATS(t)
+	nc, err := nats.Connect(fake.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nc.Subscribe("md.v1.trade.*.*", func(*nats.Msg) {}); err != nil {
+		t.Fatal(err)
+	}
+	sid := lifecycletest.Receive(t, fake.Subs, "SUB")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	market := config.MarketConfig{Symbols: []string{"BTCUSDT"}, KlineIntervals: []string{"1m"}}
+	server := &http.Server{Handler: &wsServer{market: market, registry: symbols.NewRegistry(nil), bookDepth: 20}}
+	go server.Serve(l)
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the ack means the client is registered
+	request(t, conn, `{"op":"subscribe","channel":"trades","symbols":["BTCUSDT"]}`)
+
+	shutdown(server, nc)
+
+	if !nc.IsClosed() {
+		t.Error("NATS connection still open after shutdown")
+	}
+	if got := lifecycletest.Receive(t, fake.Unsubs, "UNSUB"); got != sid {
+		t.Errorf("unsubscribed %s, want %s", got, sid)
+	}
+	_, _, err = conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway {
+		t.Errorf("read after shutdown: %v, want close code %d", err, websocket.CloseGoingAway)
+	}
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	if len(clients) != 0 {
+		t.Errorf("%d clients left after shutdown", len(clients))
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"rdr/api/indicators"
+	"rdr/common/config"
+	"rdr/common/marketdata"
+)
+
+// IndicatorValue is the value of one indicator at the kline it is sent with, in the order of its outputs
+type IndicatorValue struct {
+	Name    string    `json:"name"`
+	Outputs []string  `json:"outputs"`
+	Values  []float64 `json:"values"`
+}
+
+// indicatorStream is the incremental indicator state of one websocket subscription. It is warmed
+// up from the archived klines when the client subscribes and then fed the live klines: closed
+// klines are added for good, updates of the forming kline are evaluated on a copy of the state.
+// It is guarded by clientsMutex like the subscription that owns it.
+type indicatorStream struct {
+	instances []indicators.Instance
+	// last is the open time of the last kline added for good
+	last time.Time
+	// pending is the newest forming kline; it is added for good once a later kline arrives,
+	// in case its closing update was missed
+	pending *indicators.Bar
+}
+
+// newIndicatorStream parses spec and warms the indicators up on the newest archived klines.
+// Only archived intervals have live klines, so other intervals are rejected.
+func newIndicatorStream(ctx context.Context, dbpool *pgxpool.Pool, exchange string, market config.MarketConfig, symbol, interval, spec string) (*indicatorStream, error) {
+	instances, err := indicators.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	source, ok := resolveKlineSource(market, interval)
+	if !ok || source.Bucket != "" {
+		return nil, fmt.Errorf("no live klines for interval %q", interval)
+	}
+	klines, err := queryKlines(ctx, dbpool, source, exchange, symbol, epochOrigin, time.Now().UTC(), epochOrigin, indicators.WarmUp(instances)+1)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(klines)
+
+	s := &indicatorStream{instances: instances}
+	bars := toBars(klines)
+	for i := range bars {
+		// the newest archived kline may still be forming
+		if i == len(bars)-1 {
+			s.pending = &bars[i]
+			break
+		}
+		s.add(bars[i])
+	}
+	return s, nil
+}
+
+func (s *indicatorStream) add(bar indicators.Bar) []IndicatorValue {
+	out := make([]IndicatorValue, 0, len(s.instances))
+	for _, inst := range s.instances {
+		if values, ok := inst.Indicator.Update(bar); ok {
+			out = append(out, IndicatorValue{Name: inst.Name, Outputs: inst.Outputs, Values: values})
+		}
+	}
+	s.last = bar.Time
+	return out
+}
+
+// update feeds a live kline and returns the indicator values at it; klines older than the
+// state are ignored
+func (s *indicatorStream) update(kline marketdata.Kline) []IndicatorValue {
+	bar, err := klineBar(kline)
+	if err != nil || !bar.Time.After(s.last) {
+		return nil
+	}
+	if s.pending != nil && s.pending.Time.Before(bar.Time) {
+		s.add(*s.pending)
+	}
+	s.pending = nil
+	if kline.Closed {
+		return s.add(bar)
+	}
+	s.pending = &bar
+	out := make([]IndicatorValue, 0, len(s.instances))
+	for _, inst := range s.instances {
+		if values, ok := inst.Indicator.Clone().Update(bar); ok {
+			out = append(out, IndicatorValue{Name: inst.Name, Outputs: inst.Outputs, Values: values})
+		}
+	}
+	return out
+}
+
+// klineBar converts a live kline message for the indicators
+func klineBar(k marketdata.Kline) (indicators.Bar, error) {
+	var values [5]float64
+	for i, v := range []string{k.Open, k.High, k.Low, k.Close, k.Volume} {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return indicators.Bar{}, err
+		}
+		values[i] = f
+	}
+	return indicators.Bar{Time: time.UnixMilli(k.OpenTime).UTC(), Open: values[0], High: values[1], Low: values[2], Close: values[3], Volume: values[4]}, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"rdr/api/indicators"
+	"rdr/common/marketdata"
+)
+
+const streamSpec = "sma:3,ema:3,rsi:2"
+
+var streamStart = time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+// liveKline is the kline at minute i closing at price
+func liveKline(i int, price float64, closed bool) marketdata.Kline {
+	p := strconv.FormatFloat(price, 'f', -1, 64)
+	return marketdata.Kline{
+		Symbol: "BTCUSDT", Interval: "1m", OpenTime: streamStart.Add(time.Duration(i) * time.Minute).UnixMilli(),
+		Open: p, High: p, Low: p, Close: p, Volume: "1", Closed: closed,
+	}
+}
+
+func newTestStream(t *testing.T) *indicatorStream {
+	t.Helper()
+	instances, err := indicators.Parse(streamSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &indicatorStream{instances: instances}
+}
+
+// checkValues compares live values with fresh indicators run over series, at its last kline
+func checkValues(t *testing.T, step string, got []IndicatorValue, series []marketdata.Kline) {
+	t.Helper()
+	instances, err := indicators.Parse(streamSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bars := make([]indicators.Bar, len(series))
+	for i, k := range series {
+		if bars[i], err = klineBar(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := indicators.Compute(instances, bars, len(bars)-1)
+	var n int
+	for _, s := range want {
+		if len(s.Points) == 0 {
+			continue
+		}
+		if n >= len(got) || got[n].Name != s.Name {
+			t.Errorf("%s: got %+v, want a value for %s", step, got, s.Name)
+			return
+		}
+		for j, v := range s.Points[0].Values {
+			if math.Abs(got[n].Values[j]-v) > 1e-9 {
+				t.Errorf("%s: %s = %v, want %v", step, s.Name, got[n].Values, s.Points[0].Values)
+				break
+			}
+		}
+		n++
+	}
+	if n != len(got) {
+		t.Errorf("%s: got %d values, want %d", step, len(got), n)
+	}
+}
+
+func TestIndicatorStream(t *testing.T) {
+	s := newTestStream(t)
+	var closed []marketdata.Kline
+	for i, price := range []float64{100, 102, 101} {
+		k := liveKline(i, price, true)
+		closed = append(closed, k)
+		checkValues(t, "closed kline "+strconv.Itoa(i), s.update(k), closed)
+	}
+
+	// updates of the forming kline are previews; each replaces the last
+	checkValues(t, "first preview", s.update(liveKline(3, 105, false)), append(closed, liveKline(3, 105, false)))
+	checkValues(t, "second preview", s.update(liveKline(3, 98, false)), append(closed, liveKline(3, 98, false)))
+	closed = append(closed, liveKline(3, 99, true))
+	checkValues(t, "closed after previews", s.update(liveKline(3, 99, true)), closed)
+
+	// kline 4's closing update is missed: its last preview is added once kline 5 arrives
+	s.update(liveKline(4, 103, false))
+	closed = append(closed, liveKline(4, 103, true))
+	checkValues(t, "after missed close", s.update(liveKline(5, 104, false)), append(closed, liveKline(5, 104, false)))
+
+	// stale and out-of-order klines leave the state alone
+	for _, k := range []marketdata.Kline{liveKline(2, 50, true), liveKline(4, 200, true), liveKline(4, 200, false)} {
+		if got := s.update(k); got != nil {
+			t.Errorf("kline at minute %d after minute 5: got %+v, want it ignored", (k.OpenTime-streamStart.UnixMilli())/60000, got)
+		}
+	}
+	closed = append(closed, liveKline(5, 106, true))
+	checkValues(t, "closed after stale klines", s.update(liveKline(5, 106, true)), closed)
+}
+
+func TestIndicatorStreamWarmUp(t *testing.T) {
+	s := newTestStream(t)
+	// sma:3 needs three klines; ema:3 and rsi:2 fewer
+	if got := s.update(liveKline(0, 100, true)); len(got) != 0 {
+		t.Errorf("first kline: got %+v, want no values yet", got)
+	}
+	got := s.update(liveKline(1, 101, false))
+	for _, v := range got {
+		if v.Name == "sma:3" {
+			t.Errorf("sma:3 has a value after two klines: %+v", v)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"rdr/common/config"
+	"rdr/common/symbols"
+)
+
+// The archiver keeps the symbols table current from Binance exchangeInfo; the api only reads it
+func loadSymbols(dbpool *pgxpool.Pool, registry *symbols.Registry) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	infos, err := symbols.LoadStored(ctx, dbpool)
+	if err != nil {
+		log.Printf("Unable to load symbol metadata: %v", err)
+		return
+	}
+	if len(infos) == 0 {
+		log.Println("Symbol metadata is not stored yet; only configured symbols are validated.")
+		return
+	}
+	registry.Set(infos)
+}
+
+// validSymbol accepts configured symbols, and once metadata is loaded only those the exchange lists
+func validSymbol(market config.MarketConfig, registry *symbols.Registry, symbol string) bool {
+	if !slices.Contains(market.Symbols, symbol) {
+		return false
+	}
+	if registry.Len() == 0 {
+		return true
+	}
+	_, ok := registry.Get(symbol)
+	return ok
+}
+
+// getSymbolsHandler serves tick/step sizes and display decimals so clients format prices and amounts per instrument
+func getSymbolsHandler(market config.MarketConfig, registry *symbols.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := []symbols.Info{}
+		for _, info := range registry.All() {
+			if slices.Contains(market.Symbols, info.Symbol) {
+				response = append(response, info)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"rdr/common/config"
+	"rdr/common/marketdata"
+	"rdr/common/orderbook"
+	"rdr/common/symbols"
+)
+
+// --- WebSocket protocol ---
+// Clients send subscribe/unsubscribe requests naming a channel and any number of symbols; every
+// request is answered with an ack or an error carrying the request's id. Market data then arrives
+// as one message per channel and symbol. The protocol and its JSON schema are documented in
+// websocket.md.
+
+// Channels; kline and book channels end in the interval and the depth, e.g. kline:1m or book:20
+const (
+	channelTrades      = "trades"
+	channelKlinePrefix = "kline:"
+	channelBookPrefix  = "book:"
+	channelTicker      = "ticker"
+)
+
+const (
+	maxSubscriptions     = 100
+	indicatorLoadTimeout = 10 * time.Second
+	// writeTimeout bounds a single write; a client that stalls longer is dropped
+	writeTimeout = 5 * time.Second
+	// sendQueueSize is how many messages may wait for a client's writer before it is dropped
+	sendQueueSize = 256
+)
+
+var upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+
+// WsRequest is a client request
+type WsRequest struct {
+	Op string `json:"op"`
+	// ID is echoed in the ack or error; any JSON value
+	ID      json.RawMessage `json:"id,omitempty"`
+	Channel string          `json:"channel"`
+	Symbols []string        `json:"symbols"`
+	// Indicators optionally lists indicator specs (see the indicators package) to compute on a
+	// kline channel and send with every kline
+	Indicators string `json:"indicators,omitempty"`
+}
+
+// WsMessage is every message the server sends: "ack" and "error" answer a request,
+// "trade", "kline", "book" and "ticker" carry channel data
+type WsMessage struct {
+	Type    string          `json:"type"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Op      string          `json:"op,omitempty"`
+	Channel string          `json:"channel,omitempty"`
+	Symbol  string          `json:"symbol,omitempty"`
+	Symbols []string        `json:"symbols,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	// Indicators are the subscription's live indicator values at a kline
+	Indicators []IndicatorValue `json:"indicators,omitempty"`
+	Error      string           `json:"error,omitempty"`
+}
+
+type subKey struct {
+	Channel string
+	Symbol  string
+}
+
+type subscription struct {
+	// depth is the number of levels per side on book channels
+	depth  int
+	stream *indicatorStream
+}
+
+// wsClient is one connection. Its subscriptions and its send queue are guarded by clientsMutex;
+// writeLoop is the only goroutine writing messages to conn, as websocket.Conn requires
+type wsClient struct {
+	conn *websocket.Conn
+	subs map[subKey]*subscription
+	// send holds the messages waiting for writeLoop; it is closed when the client is dropped
+	send    chan []byte
+	dropped bool
+}
+
+func newWsClient(conn *websocket.Conn) *wsClient {
+	return &wsClient{conn: conn, subs: make(map[subKey]*subscription), send: make(chan []byte, sendQueueSize)}
+}
+
+var clients = make(map[*websocket.Conn]*wsClient)
+var clientsMutex = &sync.Mutex{}
+
+// enqueue queues msg for writeLoop without waiting for the client. A client whose queue is full
+// is not keeping up and is dropped. clientsMutex must be held
+func (c *wsClient) enqueue(msg []byte) {
+	if c.dropped {
+		return
+	}
+	select {
+	case c.send <- msg:
+	default:
+		log.Printf("WS client fell %d messages behind; dropping it", sendQueueSize)
+		c.drop()
+	}
+}
+
+// drop closes the client's queue and connection and forgets it, which ends writeLoop and the read
+// loop in ServeHTTP. clientsMutex must be held
+func (c *wsClient) drop() {
+	if c.dropped {
+		return
+	}
+	c.dropped = true
+	close(c.send)
+	c.conn.Close()
+	delete(clients, c.conn)
+}
+
+// writeLoop writes the queued messages until the client is dropped; a client that cannot be
+// written to within writeTimeout is dropped
+func (c *wsClient) writeLoop() {
+	for msg := range c.send {
+		c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			log.Printf("WS write error to client: %v", err)
+			clientsMutex.Lock()
+			c.drop()
+			clientsMutex.Unlock()
+			return
+		}
+	}
+}
+
+func (c *wsClient) reply(req WsRequest, msg WsMessage) {
+	msg.ID = req.ID
+	data, _ := json.Marshal(msg)
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	c.enqueue(data)
+}
+
+func (c *wsClient) fail(req WsRequest, err error) {
+	c.reply(req, WsMessage{Type: "error", Op: req.Op, Channel: req.Channel, Error: err.Error()})
+}
+
+// wsServer validates requests against the configured market and serves /ws
+type wsServer struct {
+	dbpool *pgxpool.Pool
+	// exchange is the venue whose archived klines warm up indicator subscriptions
+	exchange  string
+	market    config.MarketConfig
+	registry  *symbols.Registry
+	bookDepth int
+}
+
+// parseChannel validates channel and returns the book depth it asks for, if any
+func (s *wsServer) parseChannel(channel string) (depth int, err error) {
+	switch {
+	case channel == channelTrades || channel == channelTicker:
+		return 0, nil
+	case strings.HasPrefix(channel, channelKlinePrefix):
+		// only archived intervals are streamed live
+		if interval := strings.TrimPrefix(channel, channelKlinePrefix); !slices.Contains(s.market.KlineIntervals, interval) {
+			return 0, fmt.Errorf("kline interval %q is not streamed; use one of %v", interval, s.market.KlineIntervals)
+		}
+		return 0, nil
+	case strings.HasPrefix(channel, channelBookPrefix):
+		// only the canonical spelling is accepted, as broadcastBook shares one message per depth
+		suffix := strings.TrimPrefix(channel, channelBookPrefix)
+		depth, err := strconv.Atoi(suffix)
+		if err != nil || strconv.Itoa(depth) != suffix || depth < 1 || depth > s.bookDepth {
+			return 0, fmt.Errorf("book depth must be between 1 and %d", s.bookDepth)
+		}
+		return depth, nil
+	}
+	return 0, fmt.Errorf("unknown channel %q", channel)
+}
+
+func (s *wsServer) subscribe(ctx context.Context, c *wsClient, req WsRequest) error {
+	depth, err := s.parseChannel(req.Channel)
+	if err != nil {
+		return err
+	}
+	if len(req.Symbols) == 0 {
+		return fmt.Errorf("no symbols")
+	}
+	if req.Indicators != "" && !strings.HasPrefix(req.Channel, channelKlinePrefix) {
+		return fmt.Errorf("indicators are only available on kline channels")
+	}
+	for i, symbol := range req.Symbols {
+		req.Symbols[i] = strings.ToUpper(symbol)
+		if !validSymbol(s.market, s.registry, req.Symbols[i]) {
+			return fmt.Errorf("unknown symbol %q", symbol)
+		}
+	}
+
+	// Indicators are warmed up from the database before taking the lock the broadcasts hold
+	subs := make(map[subKey]*subscription, len(req.Symbols))
+	for _, symbol := range req.Symbols {
+		sub := &subscription{depth: depth}
+		if req.Indicators != "" {
+			loadCtx, cancel := context.WithTimeout(ctx, indicatorLoadTimeout)
+			sub.stream, err = newIndicatorStream(loadCtx, s.dbpool, s.exchange, s.market, symbol, strings.TrimPrefix(req.Channel, channelKlinePrefix), req.Indicators)
+			cancel()
+			if err != nil {
+				return fmt.Errorf("indicators: %w", err)
+			}
+		}
+		subs[subKey{Channel: req.Channel, Symbol: symbol}] = sub
+	}
+
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	added := 0
+	for key := range subs {
+		if _, ok := c.subs[key]; !ok {
+			added++
+		}
+	}
+	if len(c.subs)+added > maxSubscriptions {
+		return fmt.Errorf("at most %d subscriptions per connection", maxSubscriptions)
+	}
+	// subscribing again replaces the subscription, e.g. to change its indicators
+	for key, sub := range subs {
+		c.subs[key] = sub
+	}
+	return nil
+}
+
+// unsubscribe removes the channel for the listed symbols, or for every symbol when none are listed
+func (s *wsServer) unsubscribe(c *wsClient, req WsRequest) {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	for key := range c.subs {
+		if key.Channel == req.Channel && (len(req.Symbols) == 0 || slices.Contains(req.Symbols, key.Symbol)) {
+			delete(c.subs, key)
+		}
+	}
+}
+
+func (s *wsServer) handle(ctx context.Context, c *wsClient, message []byte) {
+	var req WsRequest
+	if err := json.Unmarshal(message, &req); err != nil {
+		c.fail(req, fmt.Errorf("invalid request: %v", err))
+		return
+	}
+	switch req.Op {
+	case "subscribe":
+		if err := s.subscribe(ctx, c, req); err != nil {
+			c.fail(req, err)
+			return
+		}
+	case "unsubscribe":
+		if _, err := s.parseChannel(req.Channel); err != nil {
+			c.fail(req, err)
+			return
+		}
+		for i, symbol := range req.Symbols {
+			req.Symbols[i] = strings.ToUpper(symbol)
+		}
+		s.unsubscribe(c, req)
+	default:
+		c.fail(req, fmt.Errorf("unknown op %q", req.Op))
+		return
+	}
+	c.reply(req, WsMessage{Type: "ack", Op: req.Op, Channel: req.Channel, Symbols: req.Symbols})
+}
+
+func (s *wsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("WS upgrade error:", err)
+		return
+	}
+	defer conn.Close()
+
+	c := newWsClient(conn)
+	clientsMutex.Lock()
+	clients[conn] = c
+	total := len(clients)
+	clientsMutex.Unlock()
+	go c.writeLoop()
+	log.Printf("✅ New client connected. Total: %d", total)
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		s.handle(r.Context(), c, message)
+	}
+
+	clientsMutex.Lock()
+	c.drop()
+	total = len(clients)
+	clientsMutex.Unlock()
+	log.Printf("❌ Client disconnected. Total: %d", total)
+}
+
+// closeClients sends every websocket client a going-away close frame and closes the connection;
+// their read loops in ServeHTTP then exit
+func closeClients() {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	for conn, c := range clients {
+		// WriteControl may run alongside writeLoop
+		conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+		c.drop()
+	}
+}
+
+// --- Broadcasts ---
+// Broadcasts only queue messages under clientsMutex; the clients' writeLoops send them.
+
+// broadcast sends data of msgType to every client subscribed to channel for symbol
+func broadcast(channel, symbol, msgType string, data json.RawMessage) {
+	msg, _ := json.Marshal(WsMessage{Type: msgType, Channel: channel, Symbol: symbol, Data: data})
+	key := subKey{Channel: channel, Symbol: symbol}
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	for _, c := range clients {
+		if _, ok := c.subs[key]; ok {
+			c.enqueue(msg)
+		}
+	}
+}
+
+// broadcastKline is broadcast for klines; subscriptions with indicators get their own values
+func broadcastKline(kline marketdata.Kline, data json.RawMessage) {
+	channel := channelKlinePrefix + kline.Interval
+	msg, _ := json.Marshal(WsMessage{Type: "kline", Channel: channel, Symbol: kline.Symbol, Data: data})
+	key := subKey{Channel: channel, Symbol: kline.Symbol}
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	for _, c := range clients {
+		sub, ok := c.subs[key]
+		if !ok {
+			continue
+		}
+		if sub.stream == nil {
+			c.enqueue(msg)
+			continue
+		}
+		own, err := json.Marshal(WsMessage{Type: "kline", Channel: channel, Symbol: kline.Symbol, Data: data, Indicators: sub.stream.update(kline)})
+		if err != nil {
+			log.Printf("Unable to encode %s indicators for %s: %v", channel, kline.Symbol, err)
+			continue
+		}
+		c.enqueue(own)
+	}
+}
+
+// broadcastBook sends each book subscription the snapshot cut to its depth
+func broadcastBook(snapshot orderbook.Snapshot) {
+	byDepth := make(map[int][]byte)
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	for _, c := range clients {
+		for key, sub := range c.subs {
+			if key.Symbol != snapshot.Symbol || !strings.HasPrefix(key.Channel, channelBookPrefix) {
+				continue
+			}
+			msg, ok := byDepth[sub.depth]
+			if !ok {
+				cut := snapshot
+				cut.Bids = cut.Bids[:min(sub.depth, len(cut.Bids))]
+				cut.Asks = cut.Asks[:min(sub.depth, len(cut.Asks))]
+				data, _ := json.Marshal(cut)
+				msg, _ = json.Marshal(WsMessage{Type: "book", Channel: key.Channel, Symbol: snapshot.Symbol, Data: data})
+				byDepth[sub.depth] = msg
+			}
+			c.enqueue(msg)
+		}
+	}
+}
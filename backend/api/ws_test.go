@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"rdr/common/config"
+	"rdr/common/decimal"
+	"rdr/common/orderbook"
+	"rdr/common/symbols"
+)
+
+// dialWs starts a /ws server for BTCUSDT and ETHUSDT and connects to it
+func dialWs(t *testing.T) *websocket.Conn {
+	t.Helper()
+	market := config.MarketConfig{Symbols: []string{"BTCUSDT", "ETHUSDT"}, KlineIntervals: []string{"1m"}}
+	srv := httptest.NewServer(&wsServer{market: market, registry: symbols.NewRegistry(nil), bookDepth: 20})
+	t.Cleanup(srv.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func request(t *testing.T, conn *websocket.Conn, req string) WsMessage {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	return readWs(t, conn)
+}
+
+func readWs(t *testing.T, conn *websocket.Conn) WsMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg WsMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestWsSubscribeAck(t *testing.T) {
+	conn := dialWs(t)
+	msg := request(t, conn, `{"op":"subscribe","id":7,"channel":"trades","symbols":["btcusdt","ETHUSDT"]}`)
+	if msg.Type != "ack" || msg.Op != "subscribe" || string(msg.ID) != "7" || msg.Channel != "trades" {
+		t.Fatalf("got %+v, want subscribe ack with id 7", msg)
+	}
+	if strings.Join(msg.Symbols, ",") != "BTCUSDT,ETHUSDT" {
+		t.Errorf("ack symbols = %v, want upper-cased BTCUSDT,ETHUSDT", msg.Symbols)
+	}
+}
+
+func TestWsRequestErrors(t *testing.T) {
+	conn := dialWs(t)
+	tests := []struct {
+		req  string
+		want string
+	}{
+		{`{"op":"subscribe","id":"a","channel":"nope","symbols":["BTCUSDT"]}`, "unknown channel"},
+		{`{"op":"subscribe","id":"a","channel":"kline:1h","symbols":["BTCUSDT"]}`, "not streamed"},
+		{`{"op":"subscribe","id":"a","channel":"book:50","symbols":["BTCUSDT"]}`, "book depth"},
+		{`{"op":"subscribe","id":"a","channel":"book:020","symbols":["BTCUSDT"]}`, "book depth"},
+		{`{"op":"subscribe","id":"a","channel":"book:+5","symbols":["BTCUSDT"]}`, "book depth"},
+		{`{"op":"subscribe","id":"a","channel":"trades","symbols":["DOGEUSDT"]}`, "unknown symbol"},
+		{`{"op":"subscribe","id":"a","channel":"trades","symbols":[]}`, "no symbols"},
+		{`{"op":"subscribe","id":"a","channel":"trades","symbols":["BTCUSDT"],"indicators":"sma:20"}`, "only available on kline"},
+		{`{"op":"ping","id":"a"}`, "unknown op"},
+		{`not json`, "invalid request"},
+	}
+	for _, tt := range tests {
+		msg := request(t, conn, tt.req)
+		if msg.Type != "error" || !strings.Contains(msg.Error, tt.want) {
+			t.Errorf("%s: got %+v, want error containing %q", tt.req, msg, tt.want)
+		}
+	}
+}
+
+func TestWsBroadcastFollowsSubscriptions(t *testing.T) {
+	conn := dialWs(t)
+	request(t, conn, `{"op":"subscribe","channel":"trades","symbols":["BTCUSDT","ETHUSDT"]}`)
+	request(t, conn, `{"op":"subscribe","channel":"ticker","symbols":["BTCUSDT"]}`)
+
+	broadcast(channelTrades, "SOLUSDT", "trade", json.RawMessage(`{"p":1}`))
+	broadcast(channelTrades, "BTCUSDT", "trade", json.RawMessage(`{"p":2}`))
+	if msg := readWs(t, conn); msg.Type != "trade" || msg.Symbol != "BTCUSDT" || string(msg.Data) != `{"p":2}` {
+		t.Fatalf("got %+v, want the BTCUSDT trade only", msg)
+	}
+
+	msg := request(t, conn, `{"op":"unsubscribe","channel":"trades","symbols":["btcusdt"]}`)
+	if msg.Type != "ack" || msg.Op != "unsubscribe" {
+		t.Fatalf("got %+v, want unsubscribe ack", msg)
+	}
+	broadcast(channelTrades, "BTCUSDT", "trade", json.RawMessage(`{"p":3}`))
+	broadcast(channelTrades, "ETHUSDT", "trade", json.RawMessage(`{"p":4}`))
+	if msg := readWs(t, conn); msg.Symbol != "ETHUSDT" {
+		t.Fatalf("got %+v after unsubscribing BTCUSDT, want the ETHUSDT trade", msg)
+	}
+
+	// unsubscribing without symbols drops the channel for all of them
+	request(t, conn, `{"op":"unsubscribe","channel":"trades"}`)
+	broadcast(channelTrades, "ETHUSDT", "trade", json.RawMessage(`{"p":5}`))
+	broadcast(channelTicker, "BTCUSDT", "ticker", json.RawMessage(`{"c":6}`))
+	if msg := readWs(t, conn); msg.Type != "ticker" {
+		t.Fatalf("got %+v after unsubscribing trades, want the ticker", msg)
+	}
+}
+
+func TestWsBookDepth(t *testing.T) {
+	conn := dialWs(t)
+	request(t, conn, `{"op":"subscribe","channel":"book:2","symbols":["BTCUSDT"]}`)
+
+	level := func(price int64) orderbook.Level {
+		return orderbook.Level{Price: decimal.Decimal(price) * decimal.Scale, Quantity: decimal.Scale}
+	}
+	broadcastBook(orderbook.Snapshot{
+		Symbol: "BTCUSDT",
+		Bids:   []orderbook.Level{level(99), level(98), level(97)},
+		Asks:   []orderbook.Level{level(101)},
+	})
+	msg := readWs(t, conn)
+	if msg.Type != "book" || msg.Channel != "book:2" {
+		t.Fatalf("got %+v, want a book:2 message", msg)
+	}
+	var snapshot orderbook.Snapshot
+	if err := json.Unmarshal(msg.Data, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Bids) != 2 || len(snapshot.Asks) != 1 {
+		t.Errorf("got %d bids and %d asks, want the book cut to 2 levels", len(snapshot.Bids), len(snapshot.Asks))
+	}
+}
+
+func TestWsSlowClientDropped(t *testing.T) {
+	conn := dialWs(t)
+	// No writeLoop runs for this client, so its queue fills up like a client that stopped reading
+	c := newWsClient(conn)
+	c.subs[subKey{Channel: channelTrades, Symbol: "BTCUSDT"}] = &subscription{}
+	clientsMutex.Lock()
+	clients[conn] = c
+	clientsMutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i <= sendQueueSize; i++ {
+			broadcast(channelTrades, "BTCUSDT", "trade", json.RawMessage(`{"p":1}`))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("broadcast blocked on a client with a full queue")
+	}
+
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	if _, ok := clients[conn]; ok || !c.dropped {
+		t.Errorf("client with %d queued messages was kept, want it dropped", sendQueueSize+1)
+	}
+}
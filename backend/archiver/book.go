@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"rdr/common/marketdata"
+	"rdr/common/orderbook"
+)
+
+// --- Order book archive ---
+// Raw depth deltas (with the venue's U/u update IDs) come from the MD_DEPTH stream;
+// checkpoints are the deep snapshots orderbook_manager publishes on core NATS.
+// Levels are stored as JSONB [["price","quantity"], ...] in both tables, exactly as
+// marketdata.Level, so a book can be rebuilt with orderbook.Book.Reset and Apply.
+
+func setupBookTables(dbpool *pgxpool.Pool, exchange string, compressAfter time.Duration) {
+	_, err := dbpool.Exec(context.Background(), `
+	CREATE TABLE IF NOT EXISTS orderbook_snapshots (
+		time TIMESTAMPTZ NOT NULL,
+		exchange TEXT,
+		symbol TEXT,
+		last_update_id BIGINT,
+		bids JSONB,
+		asks JSONB,
+		UNIQUE (time, exchange, symbol)
+	);`)
+	if err != nil { log.Fatalf("Unable to create orderbook_snapshots table: %v\n", err) }
+	_, err = dbpool.Exec(context.Background(), `
+	CREATE TABLE IF NOT EXISTS depth_deltas (
+		time TIMESTAMPTZ NOT NULL,
+		exchange TEXT,
+		symbol TEXT,
+		first_update_id BIGINT,
+		final_update_id BIGINT,
+		bids JSONB,
+		asks JSONB,
+		UNIQUE (time, exchange, symbol, final_update_id)
+	);`)
+	if err != nil { log.Fatalf("Unable to create depth_deltas table: %v\n", err) }
+	migrateExchangeKey(dbpool, exchange, "orderbook_snapshots", []string{"time", "symbol"}, []string{"time", "exchange", "symbol"})
+	migrateExchangeKey(dbpool, exchange, "depth_deltas", []string{"time", "symbol", "final_update_id"}, []string{"time", "exchange", "symbol", "final_update_id"})
+
+	// Rows are read back per symbol in update order, so chunks are segmented by symbol
+	compression := []struct{ table, orderBy string }{
+		{"orderbook_snapshots", "time DESC"},
+		{"depth_deltas", "time DESC, final_update_id DESC"},
+	}
+	for _, c := range compression {
+		_, err := dbpool.Exec(context.Background(), fmt.Sprintf(`SELECT create_hypertable('%s', 'time', if_not_exists => TRUE);`, c.table))
+		if err != nil { log.Fatalf("Unable to create %s hypertable: %v\n", c.table, err) }
+		// Compression settings cannot change once chunks are compressed, so they are only set once
+		var enabled bool
+		err = dbpool.QueryRow(context.Background(), `SELECT compression_enabled FROM timescaledb_information.hypertables WHERE hypertable_name = $1`, c.table).Scan(&enabled)
+		if err != nil { log.Fatalf("Unable to inspect %s hypertable: %v\n", c.table, err) }
+		if !enabled {
+			_, err = dbpool.Exec(context.Background(), fmt.Sprintf(`ALTER TABLE %s SET (timescaledb.compress, timescaledb.compress_segmentby = 'symbol', timescaledb.compress_orderby = '%s');`, c.table, c.orderBy))
+			if err != nil { log.Fatalf("Unable to enable compression on %s: %v\n", c.table, err) }
+		}
+		// The policy is replaced so a changed compressAfter takes effect on restart
+		_, err = dbpool.Exec(context.Background(), `SELECT remove_compression_policy($1, if_exists => TRUE);`, c.table)
+		if err != nil { log.Fatalf("Unable to remove compression policy on %s: %v\n", c.table, err) }
+		_, err = dbpool.Exec(context.Background(), `SELECT add_compression_policy($1, make_interval(secs => $2));`, c.table, compressAfter.Seconds())
+		if err != nil { log.Fatalf("Unable to add compression policy on %s: %v\n", c.table, err) }
+		log.Printf("✅ Database table '%s' is ready (compressed after %s).", c.table, compressAfter)
+	}
+}
+
+// execer is the part of *pgxpool.Pool the row writers need, so tests can record the statements
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+func insertDepthDelta(ctx context.Context, db execer, delta marketdata.DepthDelta) error {
+	bids, err := json.Marshal(delta.Bids)
+	if err != nil { return err }
+	asks, err := json.Marshal(delta.Asks)
+	if err != nil { return err }
+	insertSQL := `INSERT INTO depth_deltas (time, exchange, symbol, first_update_id, final_update_id, bids, asks)
+				  VALUES ($1, $2, $3, $4, $5, $6, $7)
+				  ON CONFLICT (time, exchange, symbol, final_update_id) DO NOTHING`
+	_, err = db.Exec(ctx, insertSQL, time.UnixMilli(delta.Time), delta.Exchange, delta.Symbol, delta.FirstUpdateID, delta.FinalUpdateID, bids, asks)
+	return err
+}
+
+func insertCheckpoint(ctx context.Context, db execer, checkpoint orderbook.Snapshot) error {
+	bids, err := json.Marshal(toMarketLevels(checkpoint.Bids))
+	if err != nil { return err }
+	asks, err := json.Marshal(toMarketLevels(checkpoint.Asks))
+	if err != nil { return err }
+	insertSQL := `INSERT INTO orderbook_snapshots (time, exchange, symbol, last_update_id, bids, asks)
+				  VALUES ($1, $2, $3, $4, $5, $6)
+				  ON CONFLICT (time, exchange, symbol) DO NOTHING`
+	_, err = db.Exec(ctx, insertSQL, time.UnixMilli(checkpoint.Time), checkpoint.Exchange, checkpoint.Symbol, checkpoint.LastUpdateID, bids, asks)
+	return err
+}
+
+func toMarketLevels(levels []orderbook.Level) []marketdata.Level {
+	out := make([]marketdata.Level, len(levels))
+	for i, l := range levels {
+		out[i] = marketdata.Level{l.Price.String(), l.Quantity.String()}
+	}
+	return out
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"rdr/common/decimal"
+	"rdr/common/marketdata"
+	"rdr/common/orderbook"
+)
+
+// recordingDB records the statements run through it, and whether their context had a deadline,
+// and fails them with err
+type recordingDB struct {
+	sql       []string
+	args      [][]any
+	deadlines []bool
+	err       error
+}
+
+func (db *recordingDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	db.sql = append(db.sql, sql)
+	db.args = append(db.args, args)
+	_, ok := ctx.Deadline()
+	db.deadlines = append(db.deadlines, ok)
+	return pgconn.CommandTag{}, db.err
+}
+
+// levelsArg decodes a JSONB levels argument the way a replay reads the column back
+func levelsArg(t *testing.T, arg any) []marketdata.Level {
+	t.Helper()
+	data, ok := arg.([]byte)
+	if !ok {
+		t.Fatalf("levels argument is %T, want JSON bytes", arg)
+	}
+	var levels []marketdata.Level
+	if err := json.Unmarshal(data, &levels); err != nil {
+		t.Fatal(err)
+	}
+	return levels
+}
+
+func TestInsertDepthDelta(t *testing.T) {
+	db := &recordingDB{}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	delta := marketdata.DepthDelta{
+		Exchange: "binance", Symbol: "BTCUSDT", FirstUpdateID: 101, FinalUpdateID: 105, Time: 1_700_000_000_123,
+		Bids: []marketdata.Level{{"64000.10", "0.5"}, {"63999.00", "0"}},
+		Asks: []marketdata.Level{{"64000.20", "1.25"}},
+	}
+	if err := insertDepthDelta(ctx, db, delta); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.sql) != 1 || !strings.Contains(db.sql[0], "INSERT INTO depth_deltas") || !strings.Contains(db.sql[0], "ON CONFLICT (time, exchange, symbol, final_update_id) DO NOTHING") {
+		t.Fatalf("ran %q, want an idempotent insert into depth_deltas", db.sql)
+	}
+	if !db.deadlines[0] {
+		t.Error("depth delta written without the caller's deadline")
+	}
+	args := db.args[0]
+	if !args[0].(time.Time).Equal(time.UnixMilli(1_700_000_000_123)) || args[1] != "binance" || args[2] != "BTCUSDT" || args[3] != int64(101) || args[4] != int64(105) {
+		t.Errorf("args = %v", args[:5])
+	}
+	// levels are stored verbatim, removals included, so a replay applies exactly what the venue sent
+	bids, asks := levelsArg(t, args[5]), levelsArg(t, args[6])
+	if len(bids) != 2 || bids[0] != delta.Bids[0] || bids[1] != delta.Bids[1] || len(asks) != 1 || asks[0] != delta.Asks[0] {
+		t.Errorf("stored bids %v and asks %v, want %v and %v", bids, asks, delta.Bids, delta.Asks)
+	}
+
+	db.err = errors.New("connection refused")
+	if err := insertDepthDelta(ctx, db, delta); !errors.Is(err, db.err) {
+		t.Errorf("insertDepthDelta error = %v, want %v", err, db.err)
+	}
+}
+
+func TestInsertCheckpoint(t *testing.T) {
+	db := &recordingDB{}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	d := decimal.MustParse
+	checkpoint := orderbook.Snapshot{
+		Exchange: "binance", Symbol: "BTCUSDT", LastUpdateID: 105, Time: 1_700_000_000_500,
+		Bids: []orderbook.Level{{Price: d("64000.1"), Quantity: d("0.5")}, {Price: d("63990"), Quantity: d("2")}},
+		Asks: []orderbook.Level{{Price: d("64000.2"), Quantity: d("1.25")}},
+	}
+	if err := insertCheckpoint(ctx, db, checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.sql) != 1 || !strings.Contains(db.sql[0], "INSERT INTO orderbook_snapshots") || !strings.Contains(db.sql[0], "ON CONFLICT (time, exchange, symbol) DO NOTHING") {
+		t.Fatalf("ran %q, want an idempotent insert into orderbook_snapshots", db.sql)
+	}
+	if !db.deadlines[0] {
+		t.Error("checkpoint written without the caller's deadline")
+	}
+	args := db.args[0]
+	if !args[0].(time.Time).Equal(time.UnixMilli(1_700_000_000_500)) || args[1] != "binance" || args[2] != "BTCUSDT" || args[3] != int64(105) {
+		t.Errorf("args = %v", args[:4])
+	}
+	if got := string(args[5].([]byte)); got != `[["64000.2","1.25"]]` {
+		t.Errorf("asks stored as %s", got)
+	}
+
+	// the stored levels rebuild the same book, which a stored delta then continues
+	book := orderbook.NewBook(decimal.Precision{})
+	if err := book.Reset(args[3].(int64), levelsArg(t, args[4]), levelsArg(t, args[5])); err != nil {
+		t.Fatal(err)
+	}
+	if got := book.Bids.Top(10); len(got) != 2 || got[0] != checkpoint.Bids[0] || got[1] != checkpoint.Bids[1] {
+		t.Errorf("rebuilt bids = %v, want %v", got, checkpoint.Bids)
+	}
+	if err := insertDepthDelta(ctx, db, marketdata.DepthDelta{Symbol: "BTCUSDT", FirstUpdateID: 106, FinalUpdateID: 106, Asks: []marketdata.Level{{"64000.2", "0"}}}); err != nil {
+		t.Fatal(err)
+	}
+	delta := db.args[1]
+	if err := book.Apply(marketdata.DepthDelta{FirstUpdateID: delta[3].(int64), FinalUpdateID: delta[4].(int64), Bids: levelsArg(t, delta[5]), Asks: levelsArg(t, delta[6])}); err != nil {
+		t.Fatal(err)
+	}
+	if book.Asks.Len() != 0 || book.LastUpdateID != 106 {
+		t.Errorf("after the delta: %d asks at %d, want none at 106", book.Asks.Len(), book.LastUpdateID)
+	}
+}
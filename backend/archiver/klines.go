@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"rdr/common/archive"
+	"rdr/common/codec"
+	"rdr/common/marketdata"
+	"rdr/common/symbols"
+)
+
+// klineStore upserts one kline into its interval's table
+type klineStore interface {
+	UpsertKline(ctx context.Context, row archive.KlineRow) error
+}
+
+// pgKlineStore is the klineStore of the archiver: the upsert of archive.UpsertKline
+type pgKlineStore struct {
+	dbpool *pgxpool.Pool
+}
+
+func (s pgKlineStore) UpsertKline(ctx context.Context, row archive.KlineRow) error {
+	return archive.UpsertKline(ctx, s.dbpool, row)
+}
+
+// klineHandler is the klines consumer callback: each kline is upserted and acked, or nakked
+// for redelivery when the write fails
+func klineHandler(registry *symbols.Registry, store klineStore) jetstream.MessageHandler {
+	return func(msg jetstream.Msg) {
+		var kline marketdata.Kline
+		if err := codec.DecodeHeader(msg.Headers(), msg.Data(), &kline); err != nil {
+			log.Printf("Error unmarshaling kline: %v", err)
+			msg.Term()
+			return
+		}
+		row, err := archive.NewKlineRow(kline, registry.Precision(kline.Symbol))
+		if err != nil {
+			log.Printf("Dropping kline for %s: %v", kline.Symbol, err)
+			msg.Term()
+			return
+		}
+		if kline.Closed { log.Printf("Archiving closed kline for %s on interval %s", kline.Symbol, kline.Interval) }
+		ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+		defer cancel()
+		if err := store.UpsertKline(ctx, row); err != nil {
+			log.Printf("Failed to insert/update kline into klines_%s: %v", kline.Interval, err)
+			msg.NakWithDelay(retryDelay)
+			return
+		}
+		msg.Ack()
+	}
+}
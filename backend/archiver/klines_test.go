@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"rdr/common/archive"
+	"rdr/common/codec"
+	"rdr/common/marketdata"
+	"rdr/common/symbols"
+)
+
+// fakeKlineStore records the upserted klines and fails them with err
+type fakeKlineStore struct {
+	rows []archive.KlineRow
+	err  error
+}
+
+func (s *fakeKlineStore) UpsertKline(ctx context.Context, row archive.KlineRow) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return errors.New("upsert without a deadline")
+	}
+	s.rows = append(s.rows, row)
+	return s.err
+}
+
+var testKline = marketdata.Kline{
+	Version: 1, Exchange: "binance", Symbol: "BTCUSDT", Interval: "1m",
+	OpenTime: 1699999980000, CloseTime: 1700000039999,
+	Open: "37001.10000000", High: "37015.00000000", Low: "36998.20000000", Close: "37012.34000000",
+	Volume: "12.84100000", Trades: 843, EventTime: 1700000040001,
+}
+
+func TestKlineHandler(t *testing.T) {
+	malformed := testKline
+	malformed.Close = "not a price"
+	tests := []struct {
+		name    string
+		msg     *fakeMsg
+		err     error
+		want    string
+		upserts int
+	}{
+		{"json", encodedMsg(t, codec.JSON, testKline), nil, "ack", 1},
+		{"msgpack", encodedMsg(t, codec.MsgPack, testKline), nil, "ack", 1},
+		{"write fails", encodedMsg(t, codec.JSON, testKline), errors.New("connection reset"), "nak", 1},
+		{"undecodable", &fakeMsg{data: []byte("{")}, nil, "term", 0},
+		{"malformed price", encodedMsg(t, codec.JSON, malformed), nil, "term", 0},
+	}
+	for _, tt := range tests {
+		store := &fakeKlineStore{err: tt.err}
+		klineHandler(symbols.NewRegistry(nil), store)(tt.msg)
+		if got := tt.msg.state(); got != tt.want || len(store.rows) != tt.upserts {
+			t.Errorf("%s: settled as %q after %d upserts, want %q after %d", tt.name, got, len(store.rows), tt.want, tt.upserts)
+		}
+		// klines are keyed by exchange as well as symbol
+		if len(store.rows) > 0 && store.rows[0].Exchange != "binance" {
+			t.Errorf("%s: upserted exchange %q, want binance", tt.name, store.rows[0].Exchange)
+		}
+	}
+}
+
+// The handler benchmarks decode, round and upsert a kline as the klines consumer does
+func benchmarkKlineHandler(b *testing.B, c codec.Codec) {
+	msg := encodedMsg(b, c, testKline)
+	store := &fakeKlineStore{}
+	handle := klineHandler(symbols.NewRegistry(nil), store)
+	b.SetBytes(int64(len(msg.data)))
+	b.ReportAllocs()
+	for b.Loop() {
+		handle(msg)
+		store.rows = store.rows[:0]
+	}
+	if msg.state() != "ack" {
+		b.Fatalf("kline settled as %q, want ack", msg.state())
+	}
+}
+
+func BenchmarkKlineHandlerLegacyJSON(b *testing.B) { benchmarkKlineHandler(b, nil) }
+func BenchmarkKlineHandlerJSON(b *testing.B)       { benchmarkKlineHandler(b, codec.JSON) }
+func BenchmarkKlineHandlerMsgPack(b *testing.B)    { benchmarkKlineHandler(b, codec.MsgPack) }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"rdr/common/archive"
+	"rdr/common/config"
+	"rdr/common/lifecycle/lifecycletest"
+)
+
+// fakeConsumeContext runs onDrain as the callbacks still in flight when it is drained
+type fakeConsumeContext struct {
+	onDrain func()
+	closed  chan struct{}
+}
+
+func (c *fakeConsumeContext) Stop() {}
+
+func (c *fakeConsumeContext) Drain() {
+	go func() {
+		c.onDrain()
+		close(c.closed)
+	}()
+}
+
+func (c *fakeConsumeContext) Closed() <-chan struct{} { return c.closed }
+
+// natsCheckStore records whether the NATS connection was still open when a batch was written
+type natsCheckStore struct {
+	*fakeStore
+	nc         *nats.Conn
+	openWrites chan bool
+}
+
+func (s *natsCheckStore) WriteTrades(ctx context.Context, rows []archive.TradeRow) error {
+	s.openWrites <- !s.nc.IsClosed()
+	return s.fakeStore.WriteTrades(ctx, rows)
+}
+
+// Trades queued before and during the consumer drain are written and acked before NATS is drained
+func TestShutdownFlushesTradesBeforeDrainingNATS(t *testing.T) {
+	fake := lifecycletest.NewFakeNATS(t)
+	nc, err := nats.Connect(fake.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &natsCheckStore{fakeStore: newFakeStore(), nc: nc, openWrites: make(chan bool, 10)}
+	w := newTradeWriter(store, config.TradeBatchConfig{BatchSize: 100, FlushInterval: time.Hour, QueueSize: 10})
+
+	queued := addTrades(w, 1, 2)
+	var inFlight []*fakeMsg
+	consumer := &fakeConsumeContext{closed: make(chan struct{}), onDrain: func() { inFlight = addTrades(w, 3) }}
+
+	shutdown([]jetstream.ConsumeContext{consumer}, w, nc)
+
+	if batch := store.waitBatch(t, time.Second); !slices.Equal(batch, []int64{1, 2, 3}) {
+		t.Errorf("final batch = %v, want trades 1-3", batch)
+	}
+	if open := <-store.openWrites; !open {
+		t.Error("final batch written after the NATS connection was closed")
+	}
+	checkSettled(t, append(queued, inFlight...), "ack")
+	if !nc.IsClosed() {
+		t.Error("NATS connection still open after shutdown")
+	}
+}
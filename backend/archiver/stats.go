@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"rdr/common/orderbook"
+)
+
+// --- Order book stats ---
+// orderbook_manager publishes derived book metrics on core NATS at the stats cadence;
+// they are sampled metrics, so a message lost while the archiver is down is not replayed
+
+func depthColumns(bps int) (bid, ask string) {
+	return fmt.Sprintf("bid_depth_%dbps", bps), fmt.Sprintf("ask_depth_%dbps", bps)
+}
+
+func setupStatsTable(dbpool *pgxpool.Pool, exchange string) {
+	_, err := dbpool.Exec(context.Background(), `
+	CREATE TABLE IF NOT EXISTS orderbook_stats (
+		time TIMESTAMPTZ NOT NULL,
+		exchange TEXT,
+		symbol TEXT,
+		last_update_id BIGINT,
+		best_bid NUMERIC,
+		best_bid_qty NUMERIC,
+		best_ask NUMERIC,
+		best_ask_qty NUMERIC,
+		mid NUMERIC,
+		spread_bps DOUBLE PRECISION,
+		microprice NUMERIC,
+		imbalance DOUBLE PRECISION,
+		UNIQUE (time, exchange, symbol)
+	);`)
+	if err != nil { log.Fatalf("Unable to create orderbook_stats table: %v\n", err) }
+	_, err = dbpool.Exec(context.Background(), `SELECT create_hypertable('orderbook_stats', 'time', if_not_exists => TRUE);`)
+	if err != nil { log.Fatalf("Unable to create orderbook_stats hypertable: %v\n", err) }
+	migrateExchangeKey(dbpool, exchange, "orderbook_stats", []string{"time", "symbol"}, []string{"time", "exchange", "symbol"})
+	// One column pair per depth band, so a band added later only adds columns
+	for _, bps := range orderbook.DepthBands {
+		bid, ask := depthColumns(bps)
+		alterSQL := fmt.Sprintf(`ALTER TABLE orderbook_stats ADD COLUMN IF NOT EXISTS %s NUMERIC, ADD COLUMN IF NOT EXISTS %s NUMERIC;`, bid, ask)
+		if _, err := dbpool.Exec(context.Background(), alterSQL); err != nil { log.Fatalf("Unable to add depth columns for %d bps: %v\n", bps, err) }
+	}
+	log.Println("✅ Database table 'orderbook_stats' is ready.")
+}
+
+func insertStats(ctx context.Context, db execer, s orderbook.Stats) error {
+	columns := []string{"time", "exchange", "symbol", "last_update_id", "best_bid", "best_bid_qty", "best_ask", "best_ask_qty", "mid", "spread_bps", "microprice", "imbalance"}
+	args := []any{time.UnixMilli(s.Time), s.Exchange, s.Symbol, s.LastUpdateID, s.BestBid.Price, s.BestBid.Quantity, s.BestAsk.Price, s.BestAsk.Quantity, s.Mid, s.SpreadBps, s.Microprice, s.Imbalance}
+	for _, band := range s.Depth {
+		bid, ask := depthColumns(band.Bps)
+		columns = append(columns, bid, ask)
+		args = append(args, band.Bid, band.Ask)
+	}
+	placeholders := make([]string, len(args))
+	for i := range placeholders {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	insertSQL := fmt.Sprintf(`INSERT INTO orderbook_stats (%s) VALUES (%s) ON CONFLICT (time, exchange, symbol) DO NOTHING`, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+	_, err := db.Exec(ctx, insertSQL, args...)
+	return err
+}
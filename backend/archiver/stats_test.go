@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"rdr/common/decimal"
+	"rdr/common/orderbook"
+)
+
+func TestInsertStats(t *testing.T) {
+	d := func(s string) decimal.Decimal { return decimal.MustParse(s) }
+	stats := orderbook.Stats{
+		Exchange: "binance", Symbol: "BTCUSDT", LastUpdateID: 105, Time: 1_700_000_000_123,
+		BestBid: orderbook.Level{Price: d("64000.1"), Quantity: d("0.5")},
+		BestAsk: orderbook.Level{Price: d("64000.2"), Quantity: d("1.5")},
+		Mid:     d("64000.15"), SpreadBps: 0.0156, Microprice: d("64000.175"), Imbalance: -0.5,
+		Depth: []orderbook.BandDepth{{Bps: 10, Bid: d("3.25"), Ask: d("4")}},
+	}
+	db := &recordingDB{}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := insertStats(ctx, db, stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.sql) != 1 || !db.deadlines[0] {
+		t.Fatalf("ran %d statements (deadlines %v), want one with the caller's deadline", len(db.sql), db.deadlines)
+	}
+	sql := db.sql[0]
+	wantColumns := "(time, exchange, symbol, last_update_id, best_bid, best_bid_qty, best_ask, best_ask_qty, mid, spread_bps, microprice, imbalance, bid_depth_10bps, ask_depth_10bps)"
+	if !strings.Contains(sql, "INSERT INTO orderbook_stats "+wantColumns) || !strings.Contains(sql, "$14)") || !strings.Contains(sql, "ON CONFLICT (time, exchange, symbol) DO NOTHING") {
+		t.Errorf("ran %q, want an idempotent insert of %s", sql, wantColumns)
+	}
+	args := db.args[0]
+	if len(args) != 14 {
+		t.Fatalf("got %d args, want 14", len(args))
+	}
+	if !args[0].(time.Time).Equal(time.UnixMilli(1_700_000_000_123)) || args[1] != "binance" || args[2] != "BTCUSDT" || args[3] != int64(105) {
+		t.Errorf("args = %v", args[:4])
+	}
+	if args[4] != d("64000.1") || args[7] != d("1.5") || args[10] != d("64000.175") || args[11] != -0.5 {
+		t.Errorf("price args = %v", args[4:12])
+	}
+	if args[12] != d("3.25") || args[13] != d("4") {
+		t.Errorf("depth args = %v, want 3.25 and 4", args[12:])
+	}
+
+	// without depth bands only the fixed columns are written
+	stats.Depth = nil
+	db = &recordingDB{err: errors.New("connection reset")}
+	if err := insertStats(ctx, db, stats); err == nil {
+		t.Error("database error not returned")
+	}
+	if strings.Contains(db.sql[0], "depth") || len(db.args[0]) != 12 {
+		t.Errorf("ran %q with %d args, want no depth columns", db.sql[0], len(db.args[0]))
+	}
+}
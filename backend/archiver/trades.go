@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"rdr/common/archive"
+	"rdr/common/codec"
+	"rdr/common/config"
+	"rdr/common/marketdata"
+	"rdr/common/symbols"
+)
+
+// --- Batched trade writer ---
+// The trades consumer callback only parses and queues; tradeWriter collects trades into
+// batches and writes each with archive.WriteTrades (COPY into a staging table followed by one
+// INSERT ... ON CONFLICT), so redelivered trades are still deduplicated. Messages are acked only after their batch
+// is committed and nakked if it fails, so JetStream redelivers anything not stored.
+
+// tradeStore writes one batch of trades in a single transaction
+type tradeStore interface {
+	WriteTrades(ctx context.Context, rows []archive.TradeRow) error
+}
+
+// pgTradeStore is the tradeStore of the archiver: the COPY and INSERT of archive.WriteTrades
+type pgTradeStore struct {
+	dbpool *pgxpool.Pool
+}
+
+func (s pgTradeStore) WriteTrades(ctx context.Context, rows []archive.TradeRow) error {
+	return archive.WriteTrades(ctx, s.dbpool, rows)
+}
+
+// tradeHandler is the trades consumer callback: it decodes, rounds and queues each trade
+func tradeHandler(registry *symbols.Registry, trades *tradeWriter) jetstream.MessageHandler {
+	return func(msg jetstream.Msg) {
+		var trade marketdata.Trade
+		if err := codec.DecodeHeader(msg.Headers(), msg.Data(), &trade); err != nil {
+			log.Printf("Error unmarshaling trade: %v", err)
+			msg.Term() // a malformed message will never decode; don't redeliver it
+			return
+		}
+		row, err := archive.NewTradeRow(trade, registry.Precision(trade.Symbol))
+		if err != nil {
+			log.Printf("Dropping trade for %s: %v", trade.Symbol, err)
+			msg.Term()
+			return
+		}
+		trades.add(row, msg)
+	}
+}
+
+type pendingTrade struct {
+	row archive.TradeRow
+	msg jetstream.Msg
+}
+
+type tradeWriter struct {
+	store tradeStore
+	cfg   config.TradeBatchConfig
+	queue chan pendingTrade
+	done  chan struct{}
+
+	// Backpressure metrics, reset on every [STATUS] report
+	rows      atomic.Int64
+	batches   atomic.Int64
+	failures  atomic.Int64
+	flushTime atomic.Int64 // nanoseconds spent writing batches
+	maxFlush  atomic.Int64
+	waitTime  atomic.Int64 // nanoseconds consumers were blocked on a full queue
+
+	// closeMu keeps add from sending on the queue once Close has closed it
+	closeMu sync.RWMutex
+	closed  bool
+}
+
+func newTradeWriter(store tradeStore, cfg config.TradeBatchConfig) *tradeWriter {
+	w := &tradeWriter{store: store, cfg: cfg, queue: make(chan pendingTrade, cfg.QueueSize), done: make(chan struct{})}
+	go w.run()
+	return w
+}
+
+// add queues a trade, blocking while the queue is full; the blocked time is reported as backpressure.
+// After Close the trade is nakked for redelivery to the next archiver.
+func (w *tradeWriter) add(row archive.TradeRow, msg jetstream.Msg) {
+	w.closeMu.RLock()
+	defer w.closeMu.RUnlock()
+	if w.closed {
+		msg.Nak()
+		return
+	}
+	p := pendingTrade{row: row, msg: msg}
+	select {
+	case w.queue <- p:
+		return
+	default:
+	}
+	start := time.Now()
+	w.queue <- p
+	w.waitTime.Add(int64(time.Since(start)))
+}
+
+// Close writes whatever is queued and returns once it is committed and acked.
+// Consumers should be drained first so that nothing is left for redelivery.
+func (w *tradeWriter) Close() {
+	w.closeMu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.closeMu.Unlock()
+	<-w.done
+}
+
+func (w *tradeWriter) run() {
+	defer close(w.done)
+	batch := make([]pendingTrade, 0, w.cfg.BatchSize)
+	timer := time.NewTimer(w.cfg.FlushInterval)
+	timer.Stop()
+	for {
+		select {
+		case p, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			// The flush window starts with the first trade of a batch
+			if len(batch) == 0 { timer.Reset(w.cfg.FlushInterval) }
+			batch = append(batch, p)
+			if len(batch) < w.cfg.BatchSize { continue }
+			timer.Stop()
+		case <-timer.C:
+		}
+		w.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+func (w *tradeWriter) flush(batch []pendingTrade) {
+	if len(batch) == 0 { return }
+	start := time.Now()
+	err := w.write(batch)
+	elapsed := int64(time.Since(start))
+	w.flushTime.Add(elapsed)
+	for {
+		prev := w.maxFlush.Load()
+		if elapsed <= prev || w.maxFlush.CompareAndSwap(prev, elapsed) { break }
+	}
+	if err != nil {
+		log.Printf("Failed to write batch of %d trades: %v", len(batch), err)
+		w.failures.Add(1)
+		for _, p := range batch {
+			p.msg.NakWithDelay(retryDelay)
+		}
+		return
+	}
+	w.batches.Add(1)
+	w.rows.Add(int64(len(batch)))
+	for _, p := range batch {
+		p.msg.Ack()
+	}
+}
+
+func (w *tradeWriter) write(batch []pendingTrade) error {
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+	rows := make([]archive.TradeRow, len(batch))
+	for i, p := range batch {
+		rows[i] = p.row
+	}
+	return w.store.WriteTrades(ctx, rows)
+}
+
+// reportStatus logs the writer's throughput and backpressure every interval. pending is the
+// number of trades JetStream holds for the consumer that were not delivered yet.
+func (w *tradeWriter) reportStatus(interval time.Duration, consumer jetstream.Consumer) {
+	for range time.Tick(interval) {
+		rows, batches, failures := w.rows.Swap(0), w.batches.Swap(0), w.failures.Swap(0)
+		flushTime, maxFlush, waitTime := w.flushTime.Swap(0), w.maxFlush.Swap(0), w.waitTime.Swap(0)
+		var avgFlush time.Duration
+		if n := batches + failures; n > 0 { avgFlush = time.Duration(flushTime / n) }
+		pending := int64(-1)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		if info, err := consumer.Info(ctx); err == nil { pending = int64(info.NumPending) }
+		cancel()
+		log.Printf("[STATUS] Trade writer: %d rows in %d batches (%d failed), flush avg=%s max=%s, queue=%d/%d, consumers blocked %s, stream pending=%d",
+			rows, batches, failures, avgFlush.Round(time.Microsecond), time.Duration(maxFlush).Round(time.Microsecond), len(w.queue), cap(w.queue), time.Duration(waitTime).Round(time.Millisecond), pending)
+	}
+}
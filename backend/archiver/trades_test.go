@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"rdr/common/archive"
+	"rdr/common/codec"
+	"rdr/common/config"
+	"rdr/common/marketdata"
+	"rdr/common/symbols"
+)
+
+// fakeStore records the batches it is given and fails them while err is set
+type fakeStore struct {
+	mu      sync.Mutex
+	batches [][]int64
+	err     error
+	written chan struct{}
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{written: make(chan struct{}, 100)}
+}
+
+func (s *fakeStore) WriteTrades(ctx context.Context, rows []archive.TradeRow) error {
+	s.mu.Lock()
+	ids := make([]int64, len(rows))
+	for i, r := range rows {
+		ids[i] = r.TradeID
+	}
+	s.batches = append(s.batches, ids)
+	err := s.err
+	s.mu.Unlock()
+	s.written <- struct{}{}
+	return err
+}
+
+// waitBatch returns the next written batch, or fails the test after timeout
+func (s *fakeStore) waitBatch(t *testing.T, timeout time.Duration) []int64 {
+	t.Helper()
+	select {
+	case <-s.written:
+	case <-time.After(timeout):
+		t.Fatalf("no batch written within %s", timeout)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.batches[len(s.batches)-1]
+}
+
+// fakeMsg carries a payload and records how the message was settled
+type fakeMsg struct {
+	jetstream.Msg
+	headers nats.Header
+	data    []byte
+	mu      sync.Mutex
+	settled string
+}
+
+func (m *fakeMsg) Headers() nats.Header { return m.headers }
+func (m *fakeMsg) Data() []byte         { return m.data }
+
+func (m *fakeMsg) settle(how string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.settled = how
+	return nil
+}
+
+func (m *fakeMsg) Ack() error                             { return m.settle("ack") }
+func (m *fakeMsg) Nak() error                             { return m.settle("nak") }
+func (m *fakeMsg) NakWithDelay(delay time.Duration) error { return m.settle("nak") }
+func (m *fakeMsg) Term() error                            { return m.settle("term") }
+
+func (m *fakeMsg) state() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.settled
+}
+
+func addTrades(w *tradeWriter, ids ...int64) []*fakeMsg {
+	msgs := make([]*fakeMsg, len(ids))
+	for i, id := range ids {
+		msgs[i] = &fakeMsg{}
+		w.add(archive.TradeRow{TradeID: id, Symbol: "BTCUSDT"}, msgs[i])
+	}
+	return msgs
+}
+
+func checkSettled(t *testing.T, msgs []*fakeMsg, want string) {
+	t.Helper()
+	for i, m := range msgs {
+		if got := m.state(); got != want {
+			t.Errorf("message %d settled as %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestTradeWriterFlushOnSize(t *testing.T) {
+	store := newFakeStore()
+	w := newTradeWriter(store, config.TradeBatchConfig{BatchSize: 3, FlushInterval: time.Hour, QueueSize: 10})
+
+	msgs := addTrades(w, 1, 2, 3)
+	if batch := store.waitBatch(t, time.Second); !slices.Equal(batch, []int64{1, 2, 3}) {
+		t.Errorf("batch = %v, want trades 1-3", batch)
+	}
+	w.Close()
+	checkSettled(t, msgs, "ack")
+
+	if w.rows.Load() != 3 || w.batches.Load() != 1 || w.failures.Load() != 0 {
+		t.Errorf("metrics: %d rows in %d batches, %d failed", w.rows.Load(), w.batches.Load(), w.failures.Load())
+	}
+}
+
+func TestTradeWriterFlushOnTimer(t *testing.T) {
+	store := newFakeStore()
+	w := newTradeWriter(store, config.TradeBatchConfig{BatchSize: 100, FlushInterval: 20 * time.Millisecond, QueueSize: 10})
+	defer w.Close()
+
+	start := time.Now()
+	msgs := addTrades(w, 1, 2)
+	if batch := store.waitBatch(t, time.Second); !slices.Equal(batch, []int64{1, 2}) {
+		t.Errorf("batch = %v, want trades 1 and 2", batch)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("partial batch flushed after %s, before the flush interval", elapsed)
+	}
+	// acks follow the write on the writer goroutine
+	deadline := time.Now().Add(time.Second)
+	for msgs[1].state() == "" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	checkSettled(t, msgs, "ack")
+
+	// the next window starts with the next trade
+	addTrades(w, 3)
+	if batch := store.waitBatch(t, time.Second); !slices.Equal(batch, []int64{3}) {
+		t.Errorf("batch = %v, want trade 3", batch)
+	}
+}
+
+func TestTradeWriterNaksFailedBatch(t *testing.T) {
+	store := newFakeStore()
+	store.err = errors.New("connection reset")
+	w := newTradeWriter(store, config.TradeBatchConfig{BatchSize: 2, FlushInterval: time.Hour, QueueSize: 10})
+
+	failed := addTrades(w, 1, 2)
+	store.waitBatch(t, time.Second)
+
+	// once the database is back, later batches are acked again
+	store.mu.Lock()
+	store.err = nil
+	store.mu.Unlock()
+	stored := addTrades(w, 3)
+	w.Close()
+	if batch := store.waitBatch(t, time.Second); !slices.Equal(batch, []int64{3}) {
+		t.Errorf("batch written on Close = %v, want trade 3", batch)
+	}
+	checkSettled(t, failed, "nak")
+	checkSettled(t, stored, "ack")
+	if w.failures.Load() != 1 || w.batches.Load() != 1 {
+		t.Errorf("%d failed and %d written batches, want 1 each", w.failures.Load(), w.batches.Load())
+	}
+
+	// after Close nothing is queued; the trade goes back to JetStream
+	late := addTrades(w, 4)
+	checkSettled(t, late, "nak")
+}
+
+// encodedMsg encodes v as the ingestor publishes it with c; a nil codec gives a header-less JSON
+// payload, the json.Unmarshal path the archiver took before the codec option
+func encodedMsg(tb testing.TB, c codec.Codec, v any) *fakeMsg {
+	tb.Helper()
+	if c == nil {
+		data, err := json.Marshal(v)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return &fakeMsg{data: data}
+	}
+	msg, err := codec.NewMsg("md.v1.test", c, v)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return &fakeMsg{headers: msg.Header, data: msg.Data}
+}
+
+// discardStore accepts every batch without writing it
+type discardStore struct{}
+
+func (discardStore) WriteTrades(ctx context.Context, rows []archive.TradeRow) error { return nil }
+
+// The handler benchmarks decode, round and queue a trade as the trades consumer does
+func benchmarkTradeHandler(b *testing.B, c codec.Codec) {
+	trade := marketdata.Trade{
+		Version: 1, Exchange: "binance", Symbol: "BTCUSDT", TradeID: 3262186843,
+		Price: "37012.34000000", Quantity: "0.00150000", Side: marketdata.Buy, Time: 1700000000120,
+	}
+	msg := encodedMsg(b, c, trade)
+	w := newTradeWriter(discardStore{}, config.TradeBatchConfig{BatchSize: 500, FlushInterval: time.Second, QueueSize: 1000})
+	handle := tradeHandler(symbols.NewRegistry(nil), w)
+	b.SetBytes(int64(len(msg.data)))
+	b.ReportAllocs()
+	for b.Loop() {
+		handle(msg)
+	}
+	w.Close()
+	if msg.state() != "ack" {
+		b.Fatalf("trade settled as %q, want ack", msg.state())
+	}
+}
+
+func BenchmarkTradeHandlerLegacyJSON(b *testing.B) { benchmarkTradeHandler(b, nil) }
+func BenchmarkTradeHandlerJSON(b *testing.B)       { benchmarkTradeHandler(b, codec.JSON) }
+func BenchmarkTradeHandlerMsgPack(b *testing.B)    { benchmarkTradeHandler(b, codec.MsgPack) }
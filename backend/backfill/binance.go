@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"rdr/common/marketdata"
+)
+
+// --- Binance REST client ---
+// Both endpoints return at most pageLimit rows per request. Requests are spaced by delay, and
+// 429/418 responses are retried after the Retry-After the exchange asks for, so a long
+// backfill stays within the IP request weight limit instead of getting banned.
+
+const (
+	pageLimit     = 1000
+	maxRetries    = 5
+	defaultRetry  = 10 * time.Second
+	aggTradeRange = time.Hour // aggTrades rejects startTime/endTime windows of an hour or more
+)
+
+type binanceClient struct {
+	baseURL string
+	http    *http.Client
+	delay   time.Duration
+	last    time.Time
+}
+
+func newBinanceClient(baseURL string, delay time.Duration) *binanceClient {
+	return &binanceClient{baseURL: strings.TrimRight(baseURL, "/"), http: &http.Client{Timeout: 30 * time.Second}, delay: delay}
+}
+
+// get requests path and decodes the JSON body into v, waiting out the request spacing and retrying
+// rate limit and server errors
+func (c *binanceClient) get(ctx context.Context, path string, query url.Values, v any) error {
+	endpoint := c.baseURL + path + "?" + query.Encode()
+	for attempt := 1; ; attempt++ {
+		if wait := time.Until(c.last.Add(c.delay)); wait > 0 {
+			if err := sleep(ctx, wait); err != nil { return err }
+		}
+		c.last = time.Now()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil { return err }
+		resp, err := c.http.Do(req)
+		if err != nil {
+			if ctx.Err() != nil || attempt == maxRetries { return err }
+			log.Printf("Request %s failed (%v); retrying in %s", path, err, defaultRetry)
+			if err := sleep(ctx, defaultRetry); err != nil { return err }
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil { return err }
+
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			return json.Unmarshal(body, v)
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusTeapot || resp.StatusCode >= 500:
+			if attempt == maxRetries { return fmt.Errorf("%s: %s", path, resp.Status) }
+			wait := retryAfter(resp.Header.Get("Retry-After"))
+			log.Printf("%s returned %s; retrying in %s", path, resp.Status, wait)
+			if err := sleep(ctx, wait); err != nil { return err }
+		default:
+			return fmt.Errorf("%s: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+		}
+	}
+}
+
+// retryAfter parses a Retry-After header in seconds
+func retryAfter(header string) time.Duration {
+	if secs, err := strconv.Atoi(header); err == nil && secs > 0 { return time.Duration(secs) * time.Second }
+	return defaultRetry
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// restKline is one row of /api/v3/klines: [openTime, open, high, low, close, volume, closeTime,
+// quoteVolume, trades, takerBaseVolume, takerQuoteVolume, ignore]
+type restKline struct {
+	OpenTime  int64
+	Open      string
+	High      string
+	Low       string
+	Close     string
+	Volume    string
+	CloseTime int64
+	Trades    int64
+}
+
+func (k *restKline) UnmarshalJSON(data []byte) error {
+	var quoteVolume, takerBase, takerQuote, ignore string
+	row := []any{&k.OpenTime, &k.Open, &k.High, &k.Low, &k.Close, &k.Volume, &k.CloseTime, &quoteVolume, &k.Trades, &takerBase, &takerQuote, &ignore}
+	return json.Unmarshal(data, &row)
+}
+
+// klines returns up to pageLimit klines opening between start and end (Unix milliseconds)
+func (c *binanceClient) klines(ctx context.Context, symbol, interval string, start, end int64) ([]marketdata.Kline, error) {
+	query := url.Values{}
+	query.Set("symbol", symbol)
+	query.Set("interval", interval)
+	query.Set("startTime", strconv.FormatInt(start, 10))
+	query.Set("endTime", strconv.FormatInt(end, 10))
+	query.Set("limit", strconv.Itoa(pageLimit))
+	var rows []restKline
+	if err := c.get(ctx, "/api/v3/klines", query, &rows); err != nil { return nil, err }
+
+	now := time.Now().UnixMilli()
+	klines := make([]marketdata.Kline, len(rows))
+	for i, r := range rows {
+		klines[i] = marketdata.Kline{
+			Version:   marketdata.SchemaVersion,
+			Exchange:  "binance",
+			Symbol:    symbol,
+			Interval:  interval,
+			OpenTime:  r.OpenTime,
+			CloseTime: r.CloseTime,
+			Open:      r.Open,
+			High:      r.High,
+			Low:       r.Low,
+			Close:     r.Close,
+			Volume:    r.Volume,
+			Trades:    r.Trades,
+			// The current kline is still open; the live archiver closes it later
+			Closed: r.CloseTime < now,
+			// REST klines carry no event time; they are the venue's state as of now
+			EventTime: now,
+		}
+	}
+	return klines, nil
+}
+
+// restAggTrade is one row of /api/v3/aggTrades. encoding/json also matches keys case-insensitively,
+// so M has to be declared or it would be decoded into m
+type restAggTrade struct {
+	ID           int64  `json:"a"`
+	Price        string `json:"p"`
+	Quantity     string `json:"q"`
+	FirstTradeID int64  `json:"f"`
+	LastTradeID  int64  `json:"l"`
+	Time         int64  `json:"T"`
+	IsBuyerMaker bool   `json:"m"`
+	IsBestMatch  bool   `json:"M"`
+}
+
+// aggTrades returns up to pageLimit aggregate trades, starting at fromID when it is non-negative
+// and otherwise within [start, start+aggTradeRange)
+func (c *binanceClient) aggTrades(ctx context.Context, symbol string, fromID, start int64) ([]restAggTrade, error) {
+	query := url.Values{}
+	query.Set("symbol", symbol)
+	query.Set("limit", strconv.Itoa(pageLimit))
+	if fromID >= 0 {
+		query.Set("fromId", strconv.FormatInt(fromID, 10))
+	} else {
+		query.Set("startTime", strconv.FormatInt(start, 10))
+		query.Set("endTime", strconv.FormatInt(start+aggTradeRange.Milliseconds()-1, 10))
+	}
+	var rows []restAggTrade
+	err := c.get(ctx, "/api/v3/aggTrades", query, &rows)
+	return rows, err
+}
+
+// trade converts an aggregate trade into the trade message the ingestor publishes. The live
+// stream carries individual trades; an aggregate of several fills at one price is stored as one
+// row under its first trade ID with the summed quantity, so it dedupes against a live row for that
+// trade ID without double counting volume
+func (t restAggTrade) trade(symbol string) marketdata.Trade {
+	side := marketdata.Buy
+	if t.IsBuyerMaker { side = marketdata.Sell }
+	return marketdata.Trade{
+		Version:  marketdata.SchemaVersion,
+		Exchange: "binance",
+		Symbol:   symbol,
+		TradeID:  t.FirstTradeID,
+		Price:    t.Price,
+		Quantity: t.Quantity,
+		Side:     side,
+		Time:     t.Time,
+	}
+}
@@ -0,0 +1,257 @@
+// Command backfill loads historical klines and trades from the Binance REST API into the
+// archiver's Timescale tables, so charts and trade history reach back before the archiver
+// started. It pages through /api/v3/klines and /api/v3/aggTrades for each symbol, writes
+// through the same archive package as the archiver (rows already stored are merged or skipped,
+// exactly as for live data) and saves its position after every page, so an interrupted run
+// continues where it stopped.
+//
+//	backfill -from 2024-01-01 [-to 2024-02-01] [-symbols BTCUSDT,ETHUSDT] [-intervals 1m] [-kinds klines,trades] [-restart]
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"rdr/common/archive"
+	"rdr/common/config"
+	"rdr/common/lifecycle"
+	"rdr/common/symbols"
+)
+
+const writeTimeout = 30 * time.Second
+
+type backfill struct {
+	store    store
+	client   *binanceClient
+	registry *symbols.Registry
+	from, to time.Time
+	restart  bool
+}
+
+// parseTime accepts RFC 3339 timestamps and plain dates (UTC midnight)
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil { return t, nil }
+	return time.Parse(time.DateOnly, s)
+}
+
+// splitList splits a comma separated flag, returning def when it is empty
+func splitList(s string, def []string) []string {
+	if s == "" { return def }
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" { out = append(out, v) }
+	}
+	return out
+}
+
+// start returns where job resumes: the saved cursor when it lies within the requested range
+// and was reached from b.from or earlier, otherwise ok is false and the job starts at b.from.
+// p.From is the range start to save with the job's progress either way.
+func (b *backfill) start(ctx context.Context, job string) (p progress, ok bool, err error) {
+	fresh := progress{From: b.from}
+	if b.restart {
+		return fresh, false, b.store.clearProgress(ctx, job)
+	}
+	p, found, err := b.store.loadProgress(ctx, job)
+	if err != nil || !found || p.Time.Before(b.from) || p.Time.After(b.to) { return fresh, false, err }
+	// A run that started later than b.from never fetched the part of the range before it
+	if p.From.IsZero() || p.From.After(b.from) {
+		log.Printf("[%s] Saved progress started at %s, after %s; starting over", job, p.From.UTC().Format(time.RFC3339), b.from.UTC().Format(time.RFC3339))
+		return fresh, false, nil
+	}
+	log.Printf("[%s] Resuming from %s", job, p.Time.UTC().Format(time.RFC3339))
+	return p, true, nil
+}
+
+func (b *backfill) klines(ctx context.Context, symbol, interval string) error {
+	job := fmt.Sprintf("klines:%s:%s", symbol, interval)
+	next, end := b.from.UnixMilli(), b.to.UnixMilli()
+	p, resumed, err := b.start(ctx, job)
+	if err != nil { return err }
+	if resumed { next = p.Cursor }
+
+	precision := b.registry.Precision(symbol)
+	total := 0
+	for next <= end {
+		page, err := b.client.klines(ctx, symbol, interval, next, end)
+		if err != nil { return err }
+		if len(page) == 0 { break }
+
+		rows := make([]archive.KlineRow, 0, len(page))
+		for _, k := range page {
+			row, err := archive.NewKlineRow(k, precision)
+			if err != nil { log.Printf("[%s] Skipping kline at %d: %v", job, k.OpenTime, err); continue }
+			rows = append(rows, row)
+		}
+		writeCtx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+		err = b.store.upsertKlines(writeCtx, rows)
+		cancel()
+		if err != nil { return fmt.Errorf("writing klines_%s: %w", interval, err) }
+
+		next = page[len(page)-1].CloseTime + 1
+		if err := b.store.saveProgress(ctx, job, progress{Cursor: next, Time: time.UnixMilli(next), From: p.From}); err != nil { return err }
+		total += len(rows)
+		log.Printf("[%s] %d klines written, up to %s", job, total, time.UnixMilli(next).UTC().Format(time.RFC3339))
+		if len(page) < pageLimit { break }
+	}
+	return nil
+}
+
+func (b *backfill) trades(ctx context.Context, symbol string) error {
+	job := fmt.Sprintf("trades:%s", symbol)
+	// Until the first trade is found the range is searched by time, an hour per request;
+	// from then on pages follow the aggregate trade IDs
+	fromID, window, end := int64(-1), b.from.UnixMilli(), b.to.UnixMilli()
+	p, resumed, err := b.start(ctx, job)
+	if err != nil { return err }
+	if resumed { fromID = p.Cursor }
+
+	precision := b.registry.Precision(symbol)
+	total := 0
+	for {
+		page, err := b.client.aggTrades(ctx, symbol, fromID, window)
+		if err != nil { return err }
+		if len(page) == 0 {
+			if fromID >= 0 { break }
+			if window += aggTradeRange.Milliseconds(); window > end { break }
+			continue
+		}
+
+		done := false
+		rows := make([]archive.TradeRow, 0, len(page))
+		for _, t := range page {
+			if t.Time > end { done = true; break }
+			row, err := archive.NewTradeRow(t.trade(symbol), precision)
+			if err != nil { log.Printf("[%s] Skipping aggregate trade %d: %v", job, t.ID, err); continue }
+			rows = append(rows, row)
+		}
+		if len(rows) > 0 {
+			writeCtx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+			err = b.store.writeTrades(writeCtx, rows)
+			cancel()
+			if err != nil { return fmt.Errorf("writing trades: %w", err) }
+		}
+
+		last := page[len(page)-1]
+		fromID = last.ID + 1
+		if err := b.store.saveProgress(ctx, job, progress{Cursor: fromID, Time: time.UnixMilli(last.Time), From: p.From}); err != nil { return err }
+		total += len(rows)
+		log.Printf("[%s] %d trades written, up to %s", job, total, time.UnixMilli(last.Time).UTC().Format(time.RFC3339))
+		if done || len(page) < pageLimit { break }
+	}
+	return nil
+}
+
+// checkSchema fails unless the archiver has created the tables being backfilled
+func checkSchema(ctx context.Context, dbpool *pgxpool.Pool, tables []string) error {
+	for _, table := range tables {
+		var exists bool
+		if err := dbpool.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL`, table).Scan(&exists); err != nil { return err }
+		if !exists { return fmt.Errorf("table %s does not exist; start the archiver once to create it", table) }
+	}
+	return nil
+}
+
+func main() {
+	fromFlag := flag.String("from", "", "start of the range, RFC 3339 or YYYY-MM-DD (required)")
+	toFlag := flag.String("to", "", "end of the range, RFC 3339 or YYYY-MM-DD (default now)")
+	symbolsFlag := flag.String("symbols", "", "comma separated symbols (default all configured)")
+	intervalsFlag := flag.String("intervals", "", "comma separated kline intervals (default all configured)")
+	kindsFlag := flag.String("kinds", "klines,trades", "what to backfill: klines, trades or both")
+	delay := flag.Duration("delay", 250*time.Millisecond, "minimum time between REST requests")
+	restart := flag.Bool("restart", false, "ignore saved progress and start again at -from")
+	flag.Parse()
+
+	cfg := config.MustLoad()
+	if err := cfg.RequireDatabase(); err != nil { log.Fatalf("Configuration error: %v\n", err) }
+
+	if *fromFlag == "" { flag.Usage(); os.Exit(2) }
+	from, err := parseTime(*fromFlag)
+	if err != nil { log.Fatalf("Invalid -from: %v\n", err) }
+	to := time.Now()
+	if *toFlag != "" {
+		if to, err = parseTime(*toFlag); err != nil { log.Fatalf("Invalid -to: %v\n", err) }
+	}
+	if !from.Before(to) { log.Fatalf("-from must be before -to\n") }
+
+	// Only configured symbols and intervals have metadata and tables
+	symbolList := splitList(*symbolsFlag, cfg.Market.Symbols)
+	for i, s := range symbolList {
+		symbolList[i] = strings.ToUpper(s)
+		if !slices.Contains(cfg.Market.Symbols, symbolList[i]) { log.Fatalf("Symbol %s is not configured\n", s) }
+	}
+	intervals := splitList(*intervalsFlag, cfg.Market.KlineIntervals)
+	for _, interval := range intervals {
+		if !slices.Contains(cfg.Market.KlineIntervals, interval) { log.Fatalf("Kline interval %s is not configured\n", interval) }
+	}
+	kinds := splitList(*kindsFlag, nil)
+	for _, kind := range kinds {
+		if kind != "klines" && kind != "trades" { log.Fatalf("Unknown kind %q; use klines and/or trades\n", kind) }
+	}
+
+	ctx, stop := lifecycle.Context()
+	defer stop()
+
+	dbpool, err := pgxpool.New(context.Background(), cfg.Database.URL)
+	if err != nil { log.Fatalf("DB connect error: %v\n", err) }
+	defer dbpool.Close()
+
+	tables := []string{}
+	if slices.Contains(kinds, "trades") { tables = append(tables, "trades") }
+	if slices.Contains(kinds, "klines") {
+		for _, interval := range intervals {
+			tables = append(tables, "klines_"+interval)
+		}
+	}
+	if err := checkSchema(ctx, dbpool, tables); err != nil { log.Fatalf("Database not ready: %v\n", err) }
+	if err := setupProgressTable(dbpool); err != nil { log.Fatalf("Unable to create backfill_progress table: %v\n", err) }
+
+	// Round exactly like the archiver: tick and step size stored by the archiver, or fresh
+	// exchangeInfo if it has not stored any yet
+	registry := symbols.NewRegistry(cfg.Market.Precision)
+	infos, err := symbols.LoadStored(ctx, dbpool)
+	if err == nil && len(infos) == 0 { infos, err = symbols.Load(ctx, cfg) }
+	if err != nil { log.Fatalf("Unable to load symbol metadata: %v\n", err) }
+	registry.Set(infos)
+
+	b := &backfill{
+		store:    dbStore{dbpool},
+		client:   newBinanceClient(cfg.Binance.RestURL, *delay),
+		registry: registry,
+		from:     from,
+		to:       to,
+		restart:  *restart,
+	}
+	log.Printf("Backfilling %v for %v from %s to %s", kinds, symbolList, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339))
+
+	failed := false
+	for _, symbol := range symbolList {
+		if slices.Contains(kinds, "klines") {
+			for _, interval := range intervals {
+				if err := b.klines(ctx, symbol, interval); err != nil {
+					if errors.Is(err, context.Canceled) { log.Println("Interrupted; rerun to resume."); return }
+					log.Printf("Backfilling %s %s klines failed: %v", symbol, interval, err)
+					failed = true
+				}
+			}
+		}
+		if slices.Contains(kinds, "trades") {
+			if err := b.trades(ctx, symbol); err != nil {
+				if errors.Is(err, context.Canceled) { log.Println("Interrupted; rerun to resume."); return }
+				log.Printf("Backfilling %s trades failed: %v", symbol, err)
+				failed = true
+			}
+		}
+	}
+	if failed { log.Fatalln("Backfill finished with errors; rerun to resume.") }
+	log.Println("✅ Backfill complete.")
+}
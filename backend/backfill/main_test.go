@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"rdr/common/archive"
+	"rdr/common/symbols"
+)
+
+var (
+	testFrom   = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	errCrashed = errors.New("simulated crash")
+)
+
+// memStore is an in-memory store whose nth write (counting from 1) fails once
+type memStore struct {
+	mu       sync.Mutex
+	progress map[string]progress
+	klines   map[int64]archive.KlineRow
+	trades   map[int64]archive.TradeRow
+	writes   int
+	failAt   int
+}
+
+func newMemStore(failAt int) *memStore {
+	return &memStore{progress: make(map[string]progress), klines: make(map[int64]archive.KlineRow), trades: make(map[int64]archive.TradeRow), failAt: failAt}
+}
+
+func (s *memStore) loadProgress(ctx context.Context, job string) (progress, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.progress[job]
+	return p, ok, nil
+}
+
+func (s *memStore) saveProgress(ctx context.Context, job string, p progress) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.progress[job] = p
+	return nil
+}
+
+func (s *memStore) clearProgress(ctx context.Context, job string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.progress, job)
+	return nil
+}
+
+func (s *memStore) write() error {
+	s.writes++
+	if s.writes == s.failAt { return errCrashed }
+	return nil
+}
+
+func (s *memStore) upsertKlines(ctx context.Context, rows []archive.KlineRow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.write(); err != nil { return err }
+	for _, r := range rows {
+		s.klines[r.Time.UnixMilli()] = r
+	}
+	return nil
+}
+
+func (s *memStore) writeTrades(ctx context.Context, rows []archive.TradeRow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.write(); err != nil { return err }
+	for _, r := range rows {
+		s.trades[r.TradeID] = r
+	}
+	return nil
+}
+
+// fakeBinance serves count 1m klines and count aggregate trades (one a second) from testFrom,
+// paging like the real endpoints, and records every request
+type fakeBinance struct {
+	count    int64
+	mu       sync.Mutex
+	requests []string
+}
+
+func (f *fakeBinance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r.URL.Path+"?"+r.URL.RawQuery)
+	f.mu.Unlock()
+
+	q := r.URL.Query()
+	param := func(name string) int64 {
+		v, err := strconv.ParseInt(q.Get(name), 10, 64)
+		if err != nil { return -1 }
+		return v
+	}
+	base, limit := testFrom.UnixMilli(), param("limit")
+	var rows []string
+	switch r.URL.Path {
+	case "/api/v3/klines":
+		const width = int64(time.Minute / time.Millisecond)
+		for i := max(0, (param("startTime")-base+width-1)/width); i < f.count && base+i*width <= param("endTime") && int64(len(rows)) < limit; i++ {
+			open := base + i*width
+			rows = append(rows, fmt.Sprintf(`[%d,"100.00","101.00","99.00","100.50","2.50000",%d,"250.00",7,"1.2","120.0","0"]`, open, open+width-1))
+		}
+	case "/api/v3/aggTrades":
+		first, last := param("fromId"), f.count-1
+		if first < 0 {
+			first = max(0, (param("startTime")-base+999)/1000)
+			last = min(last, (param("endTime")-base)/1000)
+		}
+		for id := first; id <= last && int64(len(rows)) < limit; id++ {
+			// M is always true on Binance; it must not be read as m
+			rows = append(rows, fmt.Sprintf(`{"a":%d,"p":"100.00","q":"0.010","f":%d,"l":%d,"T":%d,"m":false,"M":true}`, id, id*10, id*10+2, base+id*1000))
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	fmt.Fprintf(w, "[%s]", strings.Join(rows, ","))
+}
+
+// firstRequest returns the first request to path
+func (f *fakeBinance) firstRequest(path string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range f.requests {
+		if strings.HasPrefix(r, path+"?") { return r }
+	}
+	return ""
+}
+
+func newTestBackfill(t *testing.T, venue *fakeBinance, s store, to time.Time, restart bool) *backfill {
+	srv := httptest.NewServer(venue)
+	t.Cleanup(srv.Close)
+	return &backfill{store: s, client: newBinanceClient(srv.URL, 0), registry: symbols.NewRegistry(nil), from: testFrom, to: to, restart: restart}
+}
+
+func TestKlinesResume(t *testing.T) {
+	s := newMemStore(2)
+	to := testFrom.Add(2500 * time.Minute)
+	first := &fakeBinance{count: 2500}
+	if err := newTestBackfill(t, first, s, to, false).klines(context.Background(), "BTCUSDT", "1m"); !errors.Is(err, errCrashed) {
+		t.Fatalf("first run: %v, want the simulated crash", err)
+	}
+	// the first page was written and its progress saved; the second was not
+	resumeAt := testFrom.Add(pageLimit * time.Minute)
+	if p := s.progress["klines:BTCUSDT:1m"]; p.Cursor != resumeAt.UnixMilli() || len(s.klines) != pageLimit {
+		t.Fatalf("after the crash: cursor %d with %d klines, want %d with %d", p.Cursor, len(s.klines), resumeAt.UnixMilli(), pageLimit)
+	}
+
+	second := &fakeBinance{count: 2500}
+	if err := newTestBackfill(t, second, s, to, false).klines(context.Background(), "BTCUSDT", "1m"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := second.firstRequest("/api/v3/klines"), fmt.Sprintf("startTime=%d", resumeAt.UnixMilli()); !strings.Contains(got, want) {
+		t.Errorf("rerun started with %s, want %s", got, want)
+	}
+	if len(s.klines) != 2500 {
+		t.Errorf("%d klines stored, want 2500", len(s.klines))
+	}
+	k := s.klines[testFrom.UnixMilli()]
+	if k.Close.String() != "100.5" || k.Volume.String() != "2.5" || !k.Closed {
+		t.Errorf("first kline = %+v", k)
+	}
+
+	// -restart ignores the saved cursor
+	third := &fakeBinance{count: 2500}
+	if err := newTestBackfill(t, third, s, to, true).klines(context.Background(), "BTCUSDT", "1m"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := third.firstRequest("/api/v3/klines"), fmt.Sprintf("startTime=%d", testFrom.UnixMilli()); !strings.Contains(got, want) {
+		t.Errorf("restart began with %s, want %s", got, want)
+	}
+}
+
+func TestTradesResume(t *testing.T) {
+	s := newMemStore(2)
+	to := testFrom.Add(2500 * time.Second)
+	first := &fakeBinance{count: 2500}
+	if err := newTestBackfill(t, first, s, to, false).trades(context.Background(), "BTCUSDT"); !errors.Is(err, errCrashed) {
+		t.Fatalf("first run: %v, want the simulated crash", err)
+	}
+	if p := s.progress["trades:BTCUSDT"]; p.Cursor != pageLimit || len(s.trades) != pageLimit {
+		t.Fatalf("after the crash: cursor %d with %d trades, want %d with %d", p.Cursor, len(s.trades), pageLimit, pageLimit)
+	}
+
+	// the rerun continues by aggregate trade ID, without searching by time again
+	second := &fakeBinance{count: 2500}
+	if err := newTestBackfill(t, second, s, to, false).trades(context.Background(), "BTCUSDT"); err != nil {
+		t.Fatal(err)
+	}
+	if got := second.firstRequest("/api/v3/aggTrades"); !strings.Contains(got, fmt.Sprintf("fromId=%d", pageLimit)) {
+		t.Errorf("rerun started with %s, want fromId=%d", got, pageLimit)
+	}
+	if len(s.trades) != 2500 {
+		t.Errorf("%d trades stored, want 2500", len(s.trades))
+	}
+	// stored under the first trade ID; m=false means the buyer was the taker
+	trade, ok := s.trades[1230]
+	if !ok || trade.Exchange != "binance" || trade.IsBuyerMaker || trade.Quantity.String() != "0.01" || !trade.Time.Equal(testFrom.Add(123*time.Second)) {
+		t.Errorf("aggregate trade 123 = %+v, %v", trade, ok)
+	}
+}
+
+// a saved cursor outside the requested range is ignored
+func TestResumeOutsideRange(t *testing.T) {
+	s := newMemStore(0)
+	s.progress["klines:BTCUSDT:1m"] = progress{Cursor: testFrom.Add(-time.Hour).UnixMilli(), Time: testFrom.Add(-time.Hour), From: testFrom.Add(-2 * time.Hour)}
+	venue := &fakeBinance{count: 10}
+	if err := newTestBackfill(t, venue, s, testFrom.Add(10*time.Minute), false).klines(context.Background(), "BTCUSDT", "1m"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := venue.firstRequest("/api/v3/klines"), fmt.Sprintf("startTime=%d", testFrom.UnixMilli()); !strings.Contains(got, want) {
+		t.Errorf("started with %s, want %s", got, want)
+	}
+	if len(s.klines) != 10 {
+		t.Errorf("%d klines stored, want 10", len(s.klines))
+	}
+}
+
+
+// a range starting before the saved run is fetched from its start, not from the saved cursor
+func TestResumeEarlierRange(t *testing.T) {
+	s := newMemStore(0)
+	savedFrom := testFrom.Add(5 * time.Minute)
+	s.progress["klines:BTCUSDT:1m"] = progress{Cursor: testFrom.Add(8 * time.Minute).UnixMilli(), Time: testFrom.Add(8 * time.Minute), From: savedFrom}
+	venue := &fakeBinance{count: 10}
+	if err := newTestBackfill(t, venue, s, testFrom.Add(10*time.Minute), false).klines(context.Background(), "BTCUSDT", "1m"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := venue.firstRequest("/api/v3/klines"), fmt.Sprintf("startTime=%d", testFrom.UnixMilli()); !strings.Contains(got, want) {
+		t.Errorf("started with %s, want %s", got, want)
+	}
+	if len(s.klines) != 10 {
+		t.Errorf("%d klines stored, want 10", len(s.klines))
+	}
+	// the new progress covers the whole range, so a later run from testFrom resumes it
+	if p := s.progress["klines:BTCUSDT:1m"]; !p.From.Equal(testFrom) {
+		t.Errorf("saved range starts at %s, want %s", p.From, testFrom)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"rdr/common/archive"
+)
+
+// --- Resumable progress ---
+// Every job (one kind, symbol and interval) saves its cursor after each page it has written:
+// the next kline open time, or the next aggregate trade ID together with the time reached,
+// and the start of the range the cursor was reached from. A rerun continues from the cursor
+// when its range contains the saved time and starts no earlier than the saved run, since
+// nothing before that start was fetched; writes are idempotent, so a page written again after
+// a crash does no harm.
+
+func setupProgressTable(dbpool *pgxpool.Pool) error {
+	_, err := dbpool.Exec(context.Background(), `
+	CREATE TABLE IF NOT EXISTS backfill_progress (
+		job TEXT PRIMARY KEY,
+		cursor BIGINT NOT NULL,
+		cursor_time TIMESTAMPTZ NOT NULL,
+		range_from TIMESTAMPTZ,
+		updated_at TIMESTAMPTZ NOT NULL
+	);`)
+	if err != nil { return err }
+	// Progress saved before range_from existed has none, so it is never resumed
+	_, err = dbpool.Exec(context.Background(), `ALTER TABLE backfill_progress ADD COLUMN IF NOT EXISTS range_from TIMESTAMPTZ;`)
+	return err
+}
+
+type progress struct {
+	Cursor int64
+	Time   time.Time
+	// From is where the run that reached the cursor started
+	From time.Time
+}
+
+// store is where a backfill writes rows and keeps its progress; dbStore in production, an
+// in-memory one in tests
+type store interface {
+	loadProgress(ctx context.Context, job string) (progress, bool, error)
+	saveProgress(ctx context.Context, job string, p progress) error
+	clearProgress(ctx context.Context, job string) error
+	upsertKlines(ctx context.Context, rows []archive.KlineRow) error
+	writeTrades(ctx context.Context, rows []archive.TradeRow) error
+}
+
+// dbStore writes to the archiver's tables and backfill_progress
+type dbStore struct {
+	dbpool *pgxpool.Pool
+}
+
+func (s dbStore) upsertKlines(ctx context.Context, rows []archive.KlineRow) error {
+	return archive.UpsertKlines(ctx, s.dbpool, rows)
+}
+
+func (s dbStore) writeTrades(ctx context.Context, rows []archive.TradeRow) error {
+	return archive.WriteTrades(ctx, s.dbpool, rows)
+}
+
+// loadProgress returns the saved cursor of job, if any
+func (s dbStore) loadProgress(ctx context.Context, job string) (progress, bool, error) {
+	var p progress
+	var from *time.Time
+	err := s.dbpool.QueryRow(ctx, `SELECT cursor, cursor_time, range_from FROM backfill_progress WHERE job = $1`, job).Scan(&p.Cursor, &p.Time, &from)
+	if errors.Is(err, pgx.ErrNoRows) { return progress{}, false, nil }
+	if err != nil { return progress{}, false, err }
+	if from != nil { p.From = *from }
+	return p, true, nil
+}
+
+func (s dbStore) saveProgress(ctx context.Context, job string, p progress) error {
+	_, err := s.dbpool.Exec(ctx, `
+		INSERT INTO backfill_progress (job, cursor, cursor_time, range_from, updated_at) VALUES ($1, $2, $3, $4, now())
+		ON CONFLICT (job) DO UPDATE SET cursor = EXCLUDED.cursor, cursor_time = EXCLUDED.cursor_time, range_from = EXCLUDED.range_from, updated_at = EXCLUDED.updated_at`,
+		job, p.Cursor, p.Time, p.From)
+	return err
+}
+
+func (s dbStore) clearProgress(ctx context.Context, job string) error {
+	_, err := s.dbpool.Exec(ctx, `DELETE FROM backfill_progress WHERE job = $1`, job)
+	return err
+}
@@ -0,0 +1,191 @@
+// Package archive writes trades and klines into the Timescale tables the
+// archiver creates (trades, klines_<interval>).
+//
+// The archiver and the backfill command both write through it, so live and
+// backfilled rows are parsed, rounded and deduplicated the same way: trades
+// are unique on (time, exchange, symbol, trade_id), since venues number
+// trades per market, and never overwritten, klines are unique on
+// (time, exchange, symbol) and merged so a partial update never shrinks
+// the range.
+// A kline update older than the stored one (a JetStream redelivery, say),
+// or an open update for a kline already stored closed, is ignored.
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"rdr/common/decimal"
+	"rdr/common/marketdata"
+)
+
+// ErrMalformed marks market data that can never be stored, e.g. an
+// unparsable price. Retrying it is pointless.
+var ErrMalformed = errors.New("malformed message")
+
+// TradeColumns is the column order of TradeRow.Values.
+var TradeColumns = []string{"time", "exchange", "trade_id", "symbol", "price", "quantity", "is_buyer_maker"}
+
+// TradeRow is a trade ready to be written, rounded to the symbol precision.
+type TradeRow struct {
+	Time         time.Time
+	Exchange     string
+	TradeID      int64
+	Symbol       string
+	Price        decimal.Decimal
+	Quantity     decimal.Decimal
+	IsBuyerMaker bool
+}
+
+func (r TradeRow) Values() []any {
+	return []any{r.Time, r.Exchange, r.TradeID, r.Symbol, r.Price, r.Quantity, r.IsBuyerMaker}
+}
+
+// KlineRow is a kline ready to be written, rounded to the symbol precision.
+type KlineRow struct {
+	Time     time.Time
+	Exchange string
+	Symbol   string
+	Interval string
+	Open     decimal.Decimal
+	High     decimal.Decimal
+	Low      decimal.Decimal
+	Close    decimal.Decimal
+	Volume   decimal.Decimal
+	Closed   bool
+	// EventTime is when the venue emitted this state of the kline; updates
+	// older than the stored row are dropped.
+	EventTime time.Time
+}
+
+// parseDecimals parses venue decimal strings and rounds them with round.
+func parseDecimals(round func(decimal.Decimal) decimal.Decimal, values ...string) ([]decimal.Decimal, error) {
+	out := make([]decimal.Decimal, len(values))
+	for i, v := range values {
+		d, err := decimal.Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+		out[i] = round(d)
+	}
+	return out, nil
+}
+
+// NewTradeRow parses trade, rounding price and quantity to precision.
+func NewTradeRow(trade marketdata.Trade, precision decimal.Precision) (TradeRow, error) {
+	price, err := parseDecimals(precision.Price, trade.Price)
+	if err != nil {
+		return TradeRow{}, err
+	}
+	quantity, err := parseDecimals(precision.Quantity, trade.Quantity)
+	if err != nil {
+		return TradeRow{}, err
+	}
+	return TradeRow{
+		Time:     time.UnixMilli(trade.Time),
+		Exchange: trade.Exchange,
+		TradeID:  trade.TradeID,
+		Symbol:   trade.Symbol,
+		Price:    price[0],
+		Quantity: quantity[0],
+		// The aggressor sold into a resting bid, i.e. the buyer was the maker
+		IsBuyerMaker: trade.Side == marketdata.Sell,
+	}, nil
+}
+
+// NewKlineRow parses kline, rounding prices and volume to precision.
+func NewKlineRow(kline marketdata.Kline, precision decimal.Precision) (KlineRow, error) {
+	ohlc, err := parseDecimals(precision.Price, kline.Open, kline.High, kline.Low, kline.Close)
+	if err != nil {
+		return KlineRow{}, err
+	}
+	volume, err := parseDecimals(precision.Quantity, kline.Volume)
+	if err != nil {
+		return KlineRow{}, err
+	}
+	return KlineRow{
+		Time:      time.UnixMilli(kline.OpenTime),
+		Exchange:  kline.Exchange,
+		Symbol:    kline.Symbol,
+		Interval:  kline.Interval,
+		Open:      ohlc[0],
+		High:      ohlc[1],
+		Low:       ohlc[2],
+		Close:     ohlc[3],
+		Volume:    volume[0],
+		Closed:    kline.Closed,
+		EventTime: time.UnixMilli(kline.EventTime),
+	}, nil
+}
+
+// WriteTrades copies rows into a staging table and inserts them in one
+// transaction, skipping trades that are already stored.
+func WriteTrades(ctx context.Context, dbpool *pgxpool.Pool, rows []TradeRow) error {
+	tx, err := dbpool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `CREATE TEMP TABLE trades_staging (LIKE trades INCLUDING DEFAULTS) ON COMMIT DROP;`); err != nil {
+		return err
+	}
+	values := make([][]any, len(rows))
+	for i, r := range rows {
+		values[i] = r.Values()
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"trades_staging"}, TradeColumns, pgx.CopyFromRows(values)); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO trades (time, exchange, trade_id, symbol, price, quantity, is_buyer_maker)
+		SELECT time, exchange, trade_id, symbol, price, quantity, is_buyer_maker FROM trades_staging
+		ON CONFLICT (time, exchange, symbol, trade_id) DO NOTHING;`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func upsertKlineSQL(interval string) string {
+	table := fmt.Sprintf("klines_%s", interval)
+	return fmt.Sprintf(`
+		INSERT INTO %[1]s (time, exchange, symbol, open, high, low, close, volume, is_closed, event_time)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (time, exchange, symbol) DO UPDATE SET
+			high = GREATEST(%[1]s.high, EXCLUDED.high),
+			low = LEAST(%[1]s.low, EXCLUDED.low),
+			close = EXCLUDED.close,
+			volume = EXCLUDED.volume,
+			is_closed = EXCLUDED.is_closed,
+			event_time = EXCLUDED.event_time
+		WHERE (%[1]s.event_time IS NULL OR EXCLUDED.event_time >= %[1]s.event_time)
+			AND NOT (%[1]s.is_closed IS TRUE AND NOT EXCLUDED.is_closed);
+	`, table)
+}
+
+func (k KlineRow) args() []any {
+	return []any{k.Time, k.Exchange, k.Symbol, k.Open, k.High, k.Low, k.Close, k.Volume, k.Closed, k.EventTime}
+}
+
+// UpsertKline writes k into klines_<k.Interval>. The interval names the
+// table, so callers must only pass configured intervals.
+func UpsertKline(ctx context.Context, dbpool *pgxpool.Pool, k KlineRow) error {
+	_, err := dbpool.Exec(ctx, upsertKlineSQL(k.Interval), k.args()...)
+	return err
+}
+
+// UpsertKlines writes rows in one transaction.
+func UpsertKlines(ctx context.Context, dbpool *pgxpool.Pool, rows []KlineRow) error {
+	batch := &pgx.Batch{}
+	for _, k := range rows {
+		batch.Queue(upsertKlineSQL(k.Interval), k.args()...)
+	}
+	return pgx.BeginFunc(ctx, dbpool, func(tx pgx.Tx) error {
+		return tx.SendBatch(ctx, batch).Close()
+	})
+}
@@ -0,0 +1,145 @@
+// Package codec encodes market data payloads on the NATS bus.
+//
+// Publishers pick a codec (nats.codec in config.yaml) and stamp its name into
+// the Rdr-Codec message header. Consumers decode whatever the header says, so
+// JSON and MessagePack publishers can coexist during a rollout. Messages
+// without the header are JSON, which keeps older publishers working.
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Header is the NATS header carrying the codec name.
+const Header = "Rdr-Codec"
+
+type Codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	JSON    Codec = jsonCodec{}
+	MsgPack Codec = msgpackCodec{}
+)
+
+// Names lists the codecs that can be selected in configuration.
+var Names = []string{JSON.Name(), MsgPack.Name()}
+
+// ByName returns the codec registered under name.
+func ByName(name string) (Codec, error) {
+	switch name {
+	case "", JSON.Name():
+		return JSON, nil
+	case MsgPack.Name():
+		return MsgPack, nil
+	}
+	return nil, fmt.Errorf("unknown codec %q", name)
+}
+
+// NewMsg encodes v with c into a NATS message for subject.
+func NewMsg(subject string, c Codec, v any) (*nats.Msg, error) {
+	data, err := c.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	msg.Header.Set(Header, c.Name())
+	return msg, nil
+}
+
+// For returns the codec a message was encoded with.
+func For(msg *nats.Msg) (Codec, error) {
+	return ForHeader(msg.Header)
+}
+
+// ForHeader returns the codec named in h; a missing header means JSON.
+func ForHeader(h nats.Header) (Codec, error) {
+	if h == nil {
+		return JSON, nil
+	}
+	return ByName(h.Get(Header))
+}
+
+// Decode unmarshals msg into v using the codec named in its header.
+func Decode(msg *nats.Msg, v any) error {
+	return DecodeHeader(msg.Header, msg.Data, v)
+}
+
+// DecodeHeader is Decode for messages that expose headers and data
+// separately, such as JetStream consumer messages.
+func DecodeHeader(h nats.Header, data []byte, v any) error {
+	c, err := ForHeader(h)
+	if err != nil {
+		return err
+	}
+	return c.Unmarshal(data, v)
+}
+
+// ToJSON returns the payload of msg as JSON, e.g. for forwarding to a
+// browser. JSON payloads are returned as-is; others are decoded into v first.
+func ToJSON(msg *nats.Msg, v any) (json.RawMessage, error) {
+	c, err := For(msg)
+	if err != nil {
+		return nil, err
+	}
+	if c == JSON {
+		return msg.Data, nil
+	}
+	if err := c.Unmarshal(msg.Data, v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                       { return "json" }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// msgpackCodec reuses the json struct tags, so marketdata types need no
+// extra annotations and field names match the JSON encoding.
+type msgpackCodec struct{}
+
+var encoderPool = sync.Pool{New: func() any {
+	buf := new(bytes.Buffer)
+	enc := msgpack.NewEncoder(buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	return enc
+}}
+
+var decoderPool = sync.Pool{New: func() any {
+	return msgpack.NewDecoder(nil)
+}}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	enc := encoderPool.Get().(*msgpack.Encoder)
+	defer encoderPool.Put(enc)
+	buf := enc.Writer().(*bytes.Buffer)
+	buf.Reset()
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.Clone(buf.Bytes()), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	dec := decoderPool.Get().(*msgpack.Decoder)
+	defer decoderPool.Put(dec)
+	// Reset also clears the struct tag, so set it again on every use.
+	dec.Reset(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
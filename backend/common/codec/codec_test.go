@@ -0,0 +1,149 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+
+	"rdr/common/marketdata"
+)
+
+var (
+	testTrade = marketdata.Trade{
+		Version: 1, Exchange: "binance", Symbol: "BTCUSDT", TradeID: 3262186843,
+		Price: "37012.34000000", Quantity: "0.00150000", Side: marketdata.Buy, Time: 1700000000120,
+	}
+	testKline = marketdata.Kline{
+		Version: 1, Exchange: "binance", Symbol: "BTCUSDT", Interval: "1m",
+		OpenTime: 1699999980000, CloseTime: 1700000039999,
+		Open: "37001.10000000", High: "37015.00000000", Low: "36998.20000000", Close: "37012.34000000",
+		Volume: "12.84100000", Trades: 843, Closed: true, EventTime: 1700000040001,
+	}
+	testDelta = depthDelta(20)
+)
+
+// depthDelta is a depth update with levels changed levels per side, about
+// the size of a busy 100ms Binance update.
+func depthDelta(levels int) marketdata.DepthDelta {
+	d := marketdata.DepthDelta{
+		Version: 1, Exchange: "binance", Symbol: "BTCUSDT",
+		FirstUpdateID: 41220119401, FinalUpdateID: 41220119433, Time: 1700000000789,
+	}
+	for i := range levels {
+		d.Bids = append(d.Bids, marketdata.Level{fmt.Sprintf("370%02d.10000000", 12-i%10), "1.20400000"})
+		d.Asks = append(d.Asks, marketdata.Level{fmt.Sprintf("370%02d.20000000", 13+i%10), "0.00000000"})
+	}
+	return d
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range []Codec{JSON, MsgPack} {
+		for _, v := range []any{testTrade, testKline, testDelta} {
+			msg, err := NewMsg("md.v1.test", c, v)
+			if err != nil {
+				t.Fatalf("%s: %v", c.Name(), err)
+			}
+			if got := msg.Header.Get(Header); got != c.Name() {
+				t.Errorf("%s: header = %q", c.Name(), got)
+			}
+			out := reflect.New(reflect.TypeOf(v))
+			if err := Decode(msg, out.Interface()); err != nil {
+				t.Fatalf("%s: decoding %T: %v", c.Name(), v, err)
+			}
+			if !reflect.DeepEqual(out.Elem().Interface(), v) {
+				t.Errorf("%s: got %+v, want %+v", c.Name(), out.Elem().Interface(), v)
+			}
+		}
+	}
+}
+
+func TestMissingHeaderIsJSON(t *testing.T) {
+	data, _ := json.Marshal(testTrade)
+	var got marketdata.Trade
+	if err := Decode(&nats.Msg{Subject: "md.v1.test", Data: data}, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != testTrade {
+		t.Errorf("got %+v, want %+v", got, testTrade)
+	}
+}
+
+func TestUnknownCodec(t *testing.T) {
+	msg := nats.NewMsg("md.v1.test")
+	msg.Header.Set(Header, "protobuf")
+	var got marketdata.Trade
+	if err := Decode(msg, &got); err == nil {
+		t.Error("decoding an unknown codec succeeded")
+	}
+	if _, err := ByName("protobuf"); err == nil {
+		t.Error("ByName accepted an unknown codec")
+	}
+}
+
+// MessagePack payloads use the JSON field names, so ToJSON gives browsers
+// the same document whichever codec the publisher chose.
+func TestToJSON(t *testing.T) {
+	want, _ := json.Marshal(testKline)
+	msg, err := NewMsg("md.v1.test", MsgPack, testKline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kline marketdata.Kline
+	got, err := ToJSON(msg, &kline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	var fields map[string]any
+	if err := MsgPack.Unmarshal(msg.Data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fields["openTime"]; !ok {
+		t.Errorf("msgpack fields %v lack the json name openTime", fields)
+	}
+}
+
+// The benchmarks decode the payloads of the hottest consumers: depth deltas
+// in the order book manager's applyUpdate, trades and klines in the archiver.
+// BenchmarkHandleDepth* in orderbook_manager and BenchmarkTradeHandler* and
+// BenchmarkKlineHandler* in the archiver run the same payloads through those
+// handlers, against header-less JSON as the services decoded it before codecs.
+
+func benchmarkDecode[T any](b *testing.B, c Codec, v T) {
+	msg, err := NewMsg("md.v1.test", c, v)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(msg.Data)))
+	b.ReportAllocs()
+	for b.Loop() {
+		var out T
+		if err := Decode(msg, &out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkEncode(b *testing.B, c Codec, v any) {
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := NewMsg("md.v1.test", c, v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeDepthJSON(b *testing.B)    { benchmarkDecode(b, JSON, testDelta) }
+func BenchmarkDecodeDepthMsgPack(b *testing.B) { benchmarkDecode(b, MsgPack, testDelta) }
+func BenchmarkDecodeTradeJSON(b *testing.B)    { benchmarkDecode(b, JSON, testTrade) }
+func BenchmarkDecodeTradeMsgPack(b *testing.B) { benchmarkDecode(b, MsgPack, testTrade) }
+func BenchmarkDecodeKlineJSON(b *testing.B)    { benchmarkDecode(b, JSON, testKline) }
+func BenchmarkDecodeKlineMsgPack(b *testing.B) { benchmarkDecode(b, MsgPack, testKline) }
+func BenchmarkEncodeDepthJSON(b *testing.B)    { benchmarkEncode(b, JSON, testDelta) }
+func BenchmarkEncodeDepthMsgPack(b *testing.B) { benchmarkEncode(b, MsgPack, testDelta) }
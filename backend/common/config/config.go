@@ -0,0 +1,436 @@
+// Package config loads the configuration shared by all backend services.
+//
+// Every service reads the same YAML file (RDR_CONFIG, default "config.yaml")
+// and then applies RDR_* environment overrides, so ingestor, archiver, api and
+// orderbook_manager always agree on the symbol and interval universe.
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"rdr/common/codec"
+	"rdr/common/decimal"
+)
+
+const defaultPath = "config.yaml"
+
+// ValidKlineIntervals lists the Binance kline intervals that can be ingested.
+var ValidKlineIntervals = []string{"1s", "1m", "3m", "5m", "15m", "30m", "1h", "2h", "4h", "6h", "8h", "12h", "1d", "3d", "1w", "1M"}
+
+// SupportedExchanges lists the venues the ingestor has adapters for.
+var SupportedExchanges = []string{"binance", "kraken"}
+
+type Config struct {
+	NATS      NATSConfig      `yaml:"nats"`
+	Database  DatabaseConfig  `yaml:"database"`
+	Market    MarketConfig    `yaml:"market"`
+	Ingestor  IngestorConfig  `yaml:"ingestor"`
+	Binance   BinanceConfig   `yaml:"binance"`
+	Kraken    KrakenConfig    `yaml:"kraken"`
+	API       APIConfig       `yaml:"api"`
+	OrderBook OrderBookConfig `yaml:"orderbook"`
+	Metadata  MetadataConfig  `yaml:"metadata"`
+	Archiver  ArchiverConfig  `yaml:"archiver"`
+}
+
+type NATSConfig struct {
+	URL string `yaml:"url"`
+	// Codec selects the market data payload encoding publishers use ("json" or "msgpack").
+	// Consumers decode by the message header, so this only affects publishers.
+	Codec string `yaml:"codec"`
+	// JetStream sets the retention limits of the persisted market data streams.
+	JetStream JetStreamConfig `yaml:"jetstream"`
+}
+
+type JetStreamConfig struct {
+	// MaxAge is how long a message is kept, i.e. how long a consumer may be down
+	// before it starts losing data.
+	MaxAge time.Duration `yaml:"maxAge"`
+	// MaxBytes caps each stream; the oldest messages are discarded first.
+	MaxBytes int64 `yaml:"maxBytes"`
+	Replicas int   `yaml:"replicas"`
+}
+
+type DatabaseConfig struct {
+	// URL is normally supplied through RDR_DATABASE_URL so credentials stay out of the file.
+	URL string `yaml:"url"`
+}
+
+type MarketConfig struct {
+	Symbols        []string `yaml:"symbols"`
+	KlineIntervals []string `yaml:"klineIntervals"`
+	// Precision overrides the tick and step size a symbol gets from the
+	// exchangeInfo metadata (see package symbols).
+	Precision map[string]decimal.Precision `yaml:"precision"`
+}
+
+type IngestorConfig struct {
+	// Exchange selects the venue adapter the ingestor streams from.
+	Exchange string `yaml:"exchange"`
+}
+
+type BinanceConfig struct {
+	StreamURL string `yaml:"streamURL"`
+	RestURL   string `yaml:"restURL"`
+}
+
+type KrakenConfig struct {
+	StreamURL string `yaml:"streamURL"`
+}
+
+type APIConfig struct {
+	Addr string `yaml:"addr"`
+}
+
+type ArchiverConfig struct {
+	// CompressAfter is the age at which Timescale compresses order book
+	// snapshot and depth delta chunks.
+	CompressAfter time.Duration `yaml:"compressAfter"`
+	// Trades controls the batched trade writer.
+	Trades TradeBatchConfig `yaml:"trades"`
+}
+
+// TradeBatchConfig bounds a trade batch by size and age: a batch is written
+// when it holds BatchSize trades or its oldest trade waited FlushInterval.
+// QueueSize is how many trades may wait for the writer before consumers
+// block.
+type TradeBatchConfig struct {
+	BatchSize     int           `yaml:"batchSize"`
+	FlushInterval time.Duration `yaml:"flushInterval"`
+	QueueSize     int           `yaml:"queueSize"`
+}
+
+// MetadataConfig controls the exchangeInfo symbol metadata (tick size, lot
+// size, status) loaded by the symbols package.
+type MetadataConfig struct {
+	// CacheFile keeps the last exchangeInfo response; it is used when the
+	// exchange cannot be reached.
+	CacheFile string `yaml:"cacheFile"`
+	// RefreshInterval is how often the metadata is reloaded.
+	RefreshInterval time.Duration `yaml:"refreshInterval"`
+}
+
+type OrderBookConfig struct {
+	Addr string `yaml:"addr"`
+	// Publish controls the book snapshots and diffs published on NATS.
+	Publish BookPublishConfig `yaml:"publish"`
+}
+
+type BookPublishConfig struct {
+	// Depth is the number of levels per side in a published snapshot.
+	Depth       int `yaml:"depth"`
+	BookCadence `yaml:",inline"`
+	// Symbols overrides the cadence for individual symbols; zero fields
+	// inherit the defaults above.
+	Symbols map[string]BookCadence `yaml:"symbols"`
+	// Checkpoint controls the deeper snapshots the archiver stores.
+	Checkpoint CheckpointConfig `yaml:"checkpoint"`
+}
+
+// CheckpointConfig sets how often and how deep orderbook_manager publishes
+// checkpoints on orderbook.checkpoint.<SYMBOL>. Historical books are rebuilt
+// from the nearest checkpoint, so Interval bounds the deltas replayed and
+// Depth the levels a rebuilt book can show.
+type CheckpointConfig struct {
+	Interval time.Duration `yaml:"interval"`
+	Depth    int           `yaml:"depth"`
+}
+
+// BookCadence is how often a changed book is published. Books that did not
+// change since the last publication are skipped.
+type BookCadence struct {
+	SnapshotInterval time.Duration `yaml:"snapshotInterval"`
+	DiffInterval     time.Duration `yaml:"diffInterval"`
+	// StatsInterval paces the derived metrics on orderbook.stats.<SYMBOL>.
+	StatsInterval time.Duration `yaml:"statsInterval"`
+}
+
+// Default returns the configuration used when no file is present.
+func Default() Config {
+	return Config{
+		NATS: NATSConfig{
+			URL:       "nats://nats:4222",
+			Codec:     "json",
+			JetStream: JetStreamConfig{MaxAge: 72 * time.Hour, MaxBytes: 1 << 30, Replicas: 1},
+		},
+		Market:   MarketConfig{Symbols: []string{"BTCUSDT", "ETHUSDT", "SOLUSDT"}, KlineIntervals: []string{"1m", "5m"}},
+		Ingestor: IngestorConfig{Exchange: "binance"},
+		Binance: BinanceConfig{
+			StreamURL: "wss://stream.binance.com:9443/stream",
+			RestURL:   "https://api.binance.com",
+		},
+		Kraken: KrakenConfig{StreamURL: "wss://ws.kraken.com/v2"},
+		API:    APIConfig{Addr: ":8080"},
+		OrderBook: OrderBookConfig{
+			Addr: ":8081",
+			Publish: BookPublishConfig{
+				Depth:       20,
+				BookCadence: BookCadence{SnapshotInterval: time.Second, DiffInterval: 100 * time.Millisecond, StatsInterval: time.Second},
+				Checkpoint:  CheckpointConfig{Interval: time.Minute, Depth: 1000},
+			},
+		},
+		Metadata: MetadataConfig{CacheFile: "exchangeinfo.json", RefreshInterval: time.Hour},
+		Archiver: ArchiverConfig{
+			CompressAfter: 24 * time.Hour,
+			Trades:        TradeBatchConfig{BatchSize: 500, FlushInterval: 250 * time.Millisecond, QueueSize: 5000},
+		},
+	}
+}
+
+// Load reads the YAML file named by RDR_CONFIG (or config.yaml in the working
+// directory), applies environment overrides and validates the result.
+// A missing default file is not an error; a missing explicit file is.
+func Load() (Config, error) {
+	cfg := Default()
+
+	path, explicit := os.LookupEnv("RDR_CONFIG")
+	if !explicit {
+		path = defaultPath
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return Config{}, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	case errors.Is(err, fs.ErrNotExist) && !explicit:
+	default:
+		return Config{}, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return Config{}, err
+	}
+	cfg.normalize()
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// MustLoad is Load for service main functions: it exits on any error.
+func MustLoad() Config {
+	cfg, err := Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+	return cfg
+}
+
+func (c *Config) applyEnv() error {
+	var errs []error
+	setString := func(dst *string, keys ...string) {
+		for _, key := range keys {
+			if v, ok := os.LookupEnv(key); ok && v != "" {
+				*dst = v
+				return
+			}
+		}
+	}
+	setList := func(dst *[]string, key string) {
+		if v, ok := os.LookupEnv(key); ok && v != "" {
+			*dst = strings.Split(v, ",")
+		}
+	}
+
+	setParsed := func(key string, parse func(string) error) {
+		if v, ok := os.LookupEnv(key); ok && v != "" {
+			if err := parse(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			}
+		}
+	}
+
+	setString(&c.NATS.URL, "RDR_NATS_URL")
+	setString(&c.NATS.Codec, "RDR_NATS_CODEC")
+	setParsed("RDR_JETSTREAM_MAX_AGE", func(v string) (err error) {
+		c.NATS.JetStream.MaxAge, err = time.ParseDuration(v)
+		return err
+	})
+	setParsed("RDR_JETSTREAM_MAX_BYTES", func(v string) (err error) {
+		c.NATS.JetStream.MaxBytes, err = strconv.ParseInt(v, 10, 64)
+		return err
+	})
+	setString(&c.Database.URL, "RDR_DATABASE_URL", "DATABASE_URL")
+	setList(&c.Market.Symbols, "RDR_SYMBOLS")
+	setList(&c.Market.KlineIntervals, "RDR_KLINE_INTERVALS")
+	setString(&c.Ingestor.Exchange, "RDR_INGESTOR_EXCHANGE")
+	setString(&c.Binance.StreamURL, "RDR_BINANCE_STREAM_URL")
+	setString(&c.Binance.RestURL, "RDR_BINANCE_REST_URL")
+	setString(&c.Kraken.StreamURL, "RDR_KRAKEN_STREAM_URL")
+	setString(&c.API.Addr, "RDR_API_ADDR")
+	setString(&c.OrderBook.Addr, "RDR_ORDERBOOK_ADDR")
+	setString(&c.Metadata.CacheFile, "RDR_METADATA_CACHE_FILE")
+	return errors.Join(errs...)
+}
+
+// normalize canonicalizes symbols to upper case and trims whitespace.
+func (c *Config) normalize() {
+	for i, s := range c.Market.Symbols {
+		c.Market.Symbols[i] = strings.ToUpper(strings.TrimSpace(s))
+	}
+	for i, interval := range c.Market.KlineIntervals {
+		c.Market.KlineIntervals[i] = strings.TrimSpace(interval)
+	}
+	c.NATS.Codec = strings.ToLower(strings.TrimSpace(c.NATS.Codec))
+	c.Ingestor.Exchange = strings.ToLower(strings.TrimSpace(c.Ingestor.Exchange))
+	c.Binance.RestURL = strings.TrimRight(c.Binance.RestURL, "/")
+	if len(c.Market.Precision) > 0 {
+		precision := make(map[string]decimal.Precision, len(c.Market.Precision))
+		for s, p := range c.Market.Precision {
+			precision[strings.ToUpper(strings.TrimSpace(s))] = p
+		}
+		c.Market.Precision = precision
+	}
+	if len(c.OrderBook.Publish.Symbols) > 0 {
+		symbols := make(map[string]BookCadence, len(c.OrderBook.Publish.Symbols))
+		for s, cadence := range c.OrderBook.Publish.Symbols {
+			symbols[strings.ToUpper(strings.TrimSpace(s))] = cadence
+		}
+		c.OrderBook.Publish.Symbols = symbols
+	}
+}
+
+// Validate checks the settings every service depends on.
+func (c Config) Validate() error {
+	var errs []error
+	if c.NATS.URL == "" {
+		errs = append(errs, errors.New("nats.url is required"))
+	}
+	if !slices.Contains(codec.Names, c.NATS.Codec) {
+		errs = append(errs, fmt.Errorf("nats.codec: unsupported codec %q", c.NATS.Codec))
+	}
+	if c.NATS.JetStream.MaxAge <= 0 {
+		errs = append(errs, errors.New("nats.jetstream.maxAge must be positive"))
+	}
+	if c.NATS.JetStream.MaxBytes <= 0 {
+		errs = append(errs, errors.New("nats.jetstream.maxBytes must be positive"))
+	}
+	if c.NATS.JetStream.Replicas < 1 {
+		errs = append(errs, errors.New("nats.jetstream.replicas must be at least 1"))
+	}
+	if len(c.Market.Symbols) == 0 {
+		errs = append(errs, errors.New("market.symbols must not be empty"))
+	}
+	for i, s := range c.Market.Symbols {
+		if s == "" || strings.IndexFunc(s, func(r rune) bool { return (r < 'A' || r > 'Z') && (r < '0' || r > '9') }) >= 0 {
+			errs = append(errs, fmt.Errorf("market.symbols: invalid symbol %q", s))
+		} else if slices.Index(c.Market.Symbols, s) != i {
+			errs = append(errs, fmt.Errorf("market.symbols: duplicate symbol %q", s))
+		}
+	}
+	for s, p := range c.Market.Precision {
+		if p.Tick.Sign() < 0 || p.Step.Sign() < 0 {
+			errs = append(errs, fmt.Errorf("market.precision.%s: tick and step must not be negative", s))
+		}
+	}
+	if len(c.Market.KlineIntervals) == 0 {
+		errs = append(errs, errors.New("market.klineIntervals must not be empty"))
+	}
+	for i, interval := range c.Market.KlineIntervals {
+		if !slices.Contains(ValidKlineIntervals, interval) {
+			errs = append(errs, fmt.Errorf("market.klineIntervals: unsupported interval %q", interval))
+		} else if slices.Index(c.Market.KlineIntervals, interval) != i {
+			errs = append(errs, fmt.Errorf("market.klineIntervals: duplicate interval %q", interval))
+		}
+	}
+	if !slices.Contains(SupportedExchanges, c.Ingestor.Exchange) {
+		errs = append(errs, fmt.Errorf("ingestor.exchange: unsupported exchange %q", c.Ingestor.Exchange))
+	}
+	if c.Binance.StreamURL == "" {
+		errs = append(errs, errors.New("binance.streamURL is required"))
+	}
+	if c.Binance.RestURL == "" {
+		errs = append(errs, errors.New("binance.restURL is required"))
+	}
+	if c.Ingestor.Exchange == "kraken" && c.Kraken.StreamURL == "" {
+		errs = append(errs, errors.New("kraken.streamURL is required"))
+	}
+	if c.API.Addr == "" {
+		errs = append(errs, errors.New("api.addr is required"))
+	}
+	if c.OrderBook.Addr == "" {
+		errs = append(errs, errors.New("orderbook.addr is required"))
+	}
+	if c.OrderBook.Publish.Depth <= 0 {
+		errs = append(errs, errors.New("orderbook.publish.depth must be positive"))
+	}
+	if c.OrderBook.Publish.SnapshotInterval <= 0 || c.OrderBook.Publish.DiffInterval <= 0 || c.OrderBook.Publish.StatsInterval <= 0 {
+		errs = append(errs, errors.New("orderbook.publish intervals must be positive"))
+	}
+	for symbol, cadence := range c.OrderBook.Publish.Symbols {
+		if cadence.SnapshotInterval < 0 || cadence.DiffInterval < 0 || cadence.StatsInterval < 0 {
+			errs = append(errs, fmt.Errorf("orderbook.publish.symbols.%s: intervals must not be negative", symbol))
+		}
+	}
+	if c.OrderBook.Publish.Checkpoint.Interval <= 0 || c.OrderBook.Publish.Checkpoint.Depth <= 0 {
+		errs = append(errs, errors.New("orderbook.publish.checkpoint interval and depth must be positive"))
+	}
+	if c.Archiver.CompressAfter <= 0 {
+		errs = append(errs, errors.New("archiver.compressAfter must be positive"))
+	}
+	if t := c.Archiver.Trades; t.BatchSize <= 0 || t.FlushInterval <= 0 || t.QueueSize <= 0 {
+		errs = append(errs, errors.New("archiver.trades batchSize, flushInterval and queueSize must be positive"))
+	}
+	if c.Metadata.CacheFile == "" {
+		errs = append(errs, errors.New("metadata.cacheFile is required"))
+	}
+	if c.Metadata.RefreshInterval <= 0 {
+		errs = append(errs, errors.New("metadata.refreshInterval must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// RequireDatabase reports an error when no database URL was configured.
+// Only services that talk to TimescaleDB call it.
+func (c Config) RequireDatabase() error {
+	if c.Database.URL == "" {
+		return errors.New("database.url is required (set RDR_DATABASE_URL)")
+	}
+	return nil
+}
+
+// Cadence returns the publishing cadence for symbol.
+func (p BookPublishConfig) Cadence(symbol string) BookCadence {
+	cadence := p.BookCadence
+	if override, ok := p.Symbols[symbol]; ok {
+		if override.SnapshotInterval > 0 {
+			cadence.SnapshotInterval = override.SnapshotInterval
+		}
+		if override.DiffInterval > 0 {
+			cadence.DiffInterval = override.DiffInterval
+		}
+		if override.StatsInterval > 0 {
+			cadence.StatsInterval = override.StatsInterval
+		}
+	}
+	return cadence
+}
+
+// LowerSymbols returns the symbols in the lower-case form Binance streams use.
+func (m MarketConfig) LowerSymbols() []string {
+	out := make([]string, len(m.Symbols))
+	for i, s := range m.Symbols {
+		out[i] = strings.ToLower(s)
+	}
+	return out
+}
+
+// Fingerprint identifies the symbol/interval universe. Services log it at
+// startup so a mismatch between deployments is easy to spot.
+func (m MarketConfig) Fingerprint() string {
+	h := sha256.Sum256([]byte(strings.Join(m.Symbols, ",") + "|" + strings.Join(m.KlineIntervals, ",")))
+	return hex.EncodeToString(h[:4])
+}
@@ -0,0 +1,245 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"rdr/common/decimal"
+)
+
+// envKeys are the variables Load reads besides RDR_CONFIG.
+var envKeys = []string{
+	"RDR_NATS_URL", "RDR_NATS_CODEC", "RDR_JETSTREAM_MAX_AGE", "RDR_JETSTREAM_MAX_BYTES",
+	"RDR_DATABASE_URL", "DATABASE_URL", "RDR_SYMBOLS", "RDR_KLINE_INTERVALS",
+	"RDR_INGESTOR_EXCHANGE", "RDR_BINANCE_STREAM_URL", "RDR_BINANCE_REST_URL", "RDR_KRAKEN_STREAM_URL",
+	"RDR_API_ADDR", "RDR_ORDERBOOK_ADDR", "RDR_METADATA_CACHE_FILE",
+}
+
+// isolate clears the environment Load reads and runs the test in an empty
+// directory, so no config.yaml is found unless the test writes one.
+func isolate(t *testing.T) {
+	t.Helper()
+	for _, key := range envKeys {
+		t.Setenv(key, "") // empty values are ignored like unset ones
+	}
+	t.Setenv("RDR_CONFIG", "")
+	os.Unsetenv("RDR_CONFIG")
+	t.Chdir(t.TempDir())
+}
+
+func writeConfig(t *testing.T, yaml string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rdr.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	isolate(t)
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("Load without a file = %+v, want the defaults %+v", cfg, Default())
+	}
+	if err := cfg.RequireDatabase(); err == nil {
+		t.Error("RequireDatabase accepted the default configuration without a database URL")
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	isolate(t)
+	t.Setenv("RDR_CONFIG", writeConfig(t, `
+nats:
+  codec: " MsgPack "
+market:
+  symbols: [" btcusdt", ethusdt]
+  klineIntervals: [1h]
+  precision:
+    btcusdt: {tick: "0.1", step: "0.001"}
+binance:
+  restURL: https://api.binance.us/
+orderbook:
+  publish:
+    symbols:
+      ethusdt: {diffInterval: 50ms}
+`))
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Market.Symbols; !slices.Equal(got, []string{"BTCUSDT", "ETHUSDT"}) {
+		t.Errorf("symbols = %v, want upper-cased and trimmed", got)
+	}
+	if got := cfg.Market.KlineIntervals; !slices.Equal(got, []string{"1h"}) {
+		t.Errorf("intervals = %v, want [1h]", got)
+	}
+	if cfg.NATS.Codec != "msgpack" {
+		t.Errorf("codec = %q, want msgpack", cfg.NATS.Codec)
+	}
+	want := decimal.Precision{Tick: decimal.MustParse("0.1"), Step: decimal.MustParse("0.001")}
+	if got := cfg.Market.Precision["BTCUSDT"]; got != want {
+		t.Errorf("BTCUSDT precision = %+v, want %+v", got, want)
+	}
+	if cfg.Binance.RestURL != "https://api.binance.us" {
+		t.Errorf("rest URL = %q, want the trailing slash removed", cfg.Binance.RestURL)
+	}
+	// a per-symbol override only replaces the intervals it sets
+	cadence := cfg.OrderBook.Publish.Cadence("ETHUSDT")
+	if cadence.DiffInterval != 50*time.Millisecond || cadence.SnapshotInterval != time.Second {
+		t.Errorf("ETHUSDT cadence = %+v, want a 50ms diff and the default 1s snapshot", cadence)
+	}
+	// fields the file leaves out keep their defaults
+	if cfg.NATS.URL != Default().NATS.URL || cfg.API.Addr != Default().API.Addr {
+		t.Errorf("nats.url = %q, api.addr = %q, want the defaults", cfg.NATS.URL, cfg.API.Addr)
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		check func(Config) bool
+		err   string
+	}{
+		{
+			name:  "database URL",
+			env:   map[string]string{"RDR_DATABASE_URL": "postgres://rdr@db/rdr"},
+			check: func(c Config) bool { return c.Database.URL == "postgres://rdr@db/rdr" && c.RequireDatabase() == nil },
+		},
+		{
+			name:  "RDR_DATABASE_URL wins over DATABASE_URL",
+			env:   map[string]string{"RDR_DATABASE_URL": "postgres://rdr", "DATABASE_URL": "postgres://other"},
+			check: func(c Config) bool { return c.Database.URL == "postgres://rdr" },
+		},
+		{
+			name:  "DATABASE_URL fallback",
+			env:   map[string]string{"DATABASE_URL": "postgres://other"},
+			check: func(c Config) bool { return c.Database.URL == "postgres://other" },
+		},
+		{
+			name: "lists are comma separated and normalized",
+			env:  map[string]string{"RDR_SYMBOLS": "btcusdt, solusdt", "RDR_KLINE_INTERVALS": "1m,1h"},
+			check: func(c Config) bool {
+				return slices.Equal(c.Market.Symbols, []string{"BTCUSDT", "SOLUSDT"}) && slices.Equal(c.Market.KlineIntervals, []string{"1m", "1h"})
+			},
+		},
+		{
+			name: "strings",
+			env: map[string]string{
+				"RDR_NATS_URL": "nats://localhost:4222", "RDR_INGESTOR_EXCHANGE": "Kraken",
+				"RDR_API_ADDR": ":9090", "RDR_ORDERBOOK_ADDR": ":9091", "RDR_METADATA_CACHE_FILE": "/tmp/info.json",
+			},
+			check: func(c Config) bool {
+				return c.NATS.URL == "nats://localhost:4222" && c.Ingestor.Exchange == "kraken" &&
+					c.API.Addr == ":9090" && c.OrderBook.Addr == ":9091" && c.Metadata.CacheFile == "/tmp/info.json"
+			},
+		},
+		{
+			name: "JetStream limits",
+			env:  map[string]string{"RDR_JETSTREAM_MAX_AGE": "6h", "RDR_JETSTREAM_MAX_BYTES": "1048576"},
+			check: func(c Config) bool {
+				return c.NATS.JetStream.MaxAge == 6*time.Hour && c.NATS.JetStream.MaxBytes == 1<<20
+			},
+		},
+		{
+			name: "unparsable values",
+			env:  map[string]string{"RDR_JETSTREAM_MAX_AGE": "3 days", "RDR_JETSTREAM_MAX_BYTES": "1GiB"},
+			err:  "RDR_JETSTREAM_MAX_AGE",
+		},
+		{
+			name: "overrides are validated",
+			env:  map[string]string{"RDR_NATS_CODEC": "protobuf"},
+			err:  `unsupported codec "protobuf"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolate(t)
+			// the environment overrides the file
+			t.Setenv("RDR_CONFIG", writeConfig(t, "database:\n  url: postgres://file\nnats:\n  url: nats://file:4222\n"))
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			cfg, err := Load()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Load() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(cfg) {
+				t.Errorf("Load() = %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	isolate(t)
+	t.Setenv("RDR_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+	if _, err := Load(); err == nil {
+		t.Error("Load accepted a missing explicit config file")
+	}
+
+	t.Setenv("RDR_CONFIG", writeConfig(t, "market: [BTCUSDT\n"))
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "parsing") {
+		t.Errorf("Load of malformed YAML: error = %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		err    string
+	}{
+		{"no NATS URL", func(c *Config) { c.NATS.URL = "" }, "nats.url is required"},
+		{"replicas", func(c *Config) { c.NATS.JetStream.Replicas = 0 }, "replicas must be at least 1"},
+		{"no symbols", func(c *Config) { c.Market.Symbols = nil }, "market.symbols must not be empty"},
+		{"invalid symbol", func(c *Config) { c.Market.Symbols = []string{"BTC-USDT"} }, `invalid symbol "BTC-USDT"`},
+		{"duplicate symbol", func(c *Config) { c.Market.Symbols = []string{"BTCUSDT", "BTCUSDT"} }, `duplicate symbol "BTCUSDT"`},
+		{"negative tick", func(c *Config) {
+			c.Market.Precision = map[string]decimal.Precision{"BTCUSDT": {Tick: decimal.MustParse("-0.01")}}
+		}, "market.precision.BTCUSDT"},
+		{"unsupported interval", func(c *Config) { c.Market.KlineIntervals = []string{"2m"} }, `unsupported interval "2m"`},
+		{"duplicate interval", func(c *Config) { c.Market.KlineIntervals = []string{"1m", "1m"} }, `duplicate interval "1m"`},
+		{"unsupported exchange", func(c *Config) { c.Ingestor.Exchange = "coinbase" }, `unsupported exchange "coinbase"`},
+		{"kraken without a URL", func(c *Config) { c.Ingestor.Exchange, c.Kraken.StreamURL = "kraken", "" }, "kraken.streamURL is required"},
+		{"publish depth", func(c *Config) { c.OrderBook.Publish.Depth = 0 }, "orderbook.publish.depth must be positive"},
+		{"negative symbol cadence", func(c *Config) {
+			c.OrderBook.Publish.Symbols = map[string]BookCadence{"BTCUSDT": {DiffInterval: -time.Second}}
+		}, "orderbook.publish.symbols.BTCUSDT"},
+		{"checkpoint", func(c *Config) { c.OrderBook.Publish.Checkpoint.Depth = 0 }, "checkpoint interval and depth must be positive"},
+		{"trade batches", func(c *Config) { c.Archiver.Trades.QueueSize = 0 }, "archiver.trades"},
+		{"metadata refresh", func(c *Config) { c.Metadata.RefreshInterval = 0 }, "metadata.refreshInterval must be positive"},
+	}
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("default configuration is invalid: %v", err)
+	}
+	for _, tt := range tests {
+		cfg := Default()
+		tt.modify(&cfg)
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: Validate() = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+
+	// every problem is reported, not just the first
+	cfg := Default()
+	cfg.NATS.URL, cfg.API.Addr = "", ""
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "nats.url") || !strings.Contains(err.Error(), "api.addr") {
+		t.Errorf("Validate() = %v, want both missing fields", err)
+	}
+}
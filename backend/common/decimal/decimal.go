@@ -0,0 +1,375 @@
+// Package decimal is the fixed-point number used for prices and quantities.
+//
+// A Decimal is an int64 count of 1e-8 units, which is exact for every tick
+// and lot size the supported venues use and compares as a plain integer.
+// The range is roughly ±92 billion, enough for prices, quantities and the
+// kline volumes of the configured markets; Parse rejects anything larger
+// rather than silently losing precision.
+//
+// Decimals are written to JSON as number literals (64123.5, not "64123.5")
+// so existing clients keep working, and to the database as NUMERIC text.
+package decimal
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Places is the number of fractional digits a Decimal carries.
+const Places = 8
+
+// Scale is the number of units per 1.0.
+const Scale = 100_000_000
+
+type Decimal int64
+
+var Zero Decimal
+
+var ErrSyntax = errors.New("invalid decimal")
+var ErrRange = errors.New("decimal out of range")
+var ErrDivisionByZero = errors.New("decimal division by zero")
+
+// maxExponent bounds the e-notation exponent Parse accepts. Anything larger
+// cannot fit a Decimal anyway, and rejecting it up front keeps the digit
+// arithmetic below from overflowing.
+const maxExponent = 40
+
+var pow10 = [...]int64{1, 10, 100, 1_000, 10_000, 100_000, 1_000_000, 10_000_000, 100_000_000}
+
+// Parse converts a decimal string such as "64123.50", "-0.1" or "1e-5"
+// exactly. Digits beyond Places must be zero.
+func Parse(s string) (Decimal, error) {
+	mantissa, exp := s, 0
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.Atoi(s[i+1:])
+		if err != nil {
+			return 0, fmt.Errorf("%w %q", ErrSyntax, s)
+		}
+		if e > maxExponent || e < -maxExponent {
+			return 0, fmt.Errorf("%w %q", ErrRange, s)
+		}
+		mantissa, exp = s[:i], e
+	}
+
+	neg := false
+	switch {
+	case strings.HasPrefix(mantissa, "-"):
+		neg, mantissa = true, mantissa[1:]
+	case strings.HasPrefix(mantissa, "+"):
+		mantissa = mantissa[1:]
+	}
+	intPart, fracPart, _ := strings.Cut(mantissa, ".")
+	notDigit := func(r rune) bool { return r < '0' || r > '9' }
+	if intPart == "" && fracPart == "" || strings.IndexFunc(intPart, notDigit) >= 0 || strings.IndexFunc(fracPart, notDigit) >= 0 {
+		return 0, fmt.Errorf("%w %q", ErrSyntax, s)
+	}
+
+	// all digits, and how many of them are fractional once the exponent is applied
+	digits := intPart + fracPart
+	fracDigits := len(fracPart) - exp
+	if strings.Trim(digits, "0") == "" {
+		return 0, nil
+	}
+	switch {
+	case fracDigits > Places:
+		extra := fracDigits - Places
+		if extra > len(digits) {
+			extra = len(digits)
+		}
+		if strings.Trim(digits[len(digits)-extra:], "0") != "" {
+			return 0, fmt.Errorf("%w %q: more than %d decimal places", ErrSyntax, s, Places)
+		}
+		digits = digits[:len(digits)-extra]
+	case fracDigits < Places:
+		digits = strings.TrimLeft(digits, "0")
+		if len(digits)+Places-fracDigits > 19 {
+			return 0, fmt.Errorf("%w %q", ErrRange, s)
+		}
+		digits += strings.Repeat("0", Places-fracDigits)
+	}
+	if strings.Trim(digits, "0") == "" {
+		return 0, nil
+	}
+	units, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w %q", ErrRange, s)
+	}
+	if neg {
+		units = -units
+	}
+	return Decimal(units), nil
+}
+
+// MustParse is Parse for constants; it panics on invalid input.
+func MustParse(s string) Decimal {
+	d, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// FromFloat converts f, rounding to Places. It is meant for values that
+// were computed (averages, indicators), never for venue prices.
+func FromFloat(f float64) (Decimal, error) {
+	scaled := math.Round(f * Scale)
+	if math.IsNaN(scaled) || scaled >= math.MaxInt64 || scaled <= math.MinInt64 {
+		return 0, fmt.Errorf("%w: %v", ErrRange, f)
+	}
+	return Decimal(scaled), nil
+}
+
+// FromInt converts a whole number. It fails with ErrRange beyond the
+// roughly ±92 billion a Decimal holds.
+func FromInt(i int64) (Decimal, error) {
+	if i > math.MaxInt64/Scale || i < math.MinInt64/Scale {
+		return 0, fmt.Errorf("%w: %d", ErrRange, i)
+	}
+	return Decimal(i * Scale), nil
+}
+
+func (d Decimal) Float64() float64 { return float64(d) / Scale }
+func (d Decimal) IsZero() bool     { return d == 0 }
+func (d Decimal) Sign() int {
+	switch {
+	case d > 0:
+		return 1
+	case d < 0:
+		return -1
+	}
+	return 0
+}
+
+func (d Decimal) Add(o Decimal) Decimal { return d + o }
+func (d Decimal) Sub(o Decimal) Decimal { return d - o }
+
+// Mul returns d*o rounded half away from zero to Places, or ErrRange if the
+// product does not fit a Decimal.
+func (d Decimal) Mul(o Decimal) (Decimal, error) {
+	p := new(big.Int).Mul(big.NewInt(int64(d)), big.NewInt(int64(o)))
+	q, ok := roundDiv(p, big.NewInt(Scale))
+	if !ok {
+		return 0, fmt.Errorf("%w: %s * %s", ErrRange, d, o)
+	}
+	return q, nil
+}
+
+// Div returns d/o rounded half away from zero to Places, or ErrRange if the
+// quotient does not fit a Decimal.
+func (d Decimal) Div(o Decimal) (Decimal, error) {
+	if o == 0 {
+		return 0, ErrDivisionByZero
+	}
+	p := new(big.Int).Mul(big.NewInt(int64(d)), big.NewInt(Scale))
+	q, ok := roundDiv(p, big.NewInt(int64(o)))
+	if !ok {
+		return 0, fmt.Errorf("%w: %s / %s", ErrRange, d, o)
+	}
+	return q, nil
+}
+
+// roundDiv returns n/m rounded half away from zero, and false if it does not
+// fit an int64.
+func roundDiv(n, m *big.Int) (Decimal, bool) {
+	q, r := new(big.Int).QuoRem(n, m, new(big.Int))
+	if new(big.Int).Abs(new(big.Int).Mul(r, big.NewInt(2))).Cmp(new(big.Int).Abs(m)) >= 0 {
+		if n.Sign()*m.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	if !q.IsInt64() {
+		return 0, false
+	}
+	return Decimal(q.Int64()), true
+}
+
+// Round rounds d half away from zero to places fractional digits.
+func (d Decimal) Round(places int) Decimal {
+	if places >= Places {
+		return d
+	}
+	if places < 0 {
+		places = 0
+	}
+	return d.Quantize(Decimal(pow10[Places-places]))
+}
+
+// Quantize rounds d half away from zero to a multiple of step (a tick or
+// lot size). A zero step leaves d unchanged.
+func (d Decimal) Quantize(step Decimal) Decimal {
+	if step <= 0 {
+		return d
+	}
+	q := int64(d) / int64(step)
+	r := int64(d) % int64(step)
+	if 2*abs(r) >= int64(step) {
+		if d < 0 {
+			q--
+		} else {
+			q++
+		}
+	}
+	return Decimal(q * int64(step))
+}
+
+// Floor rounds d down to a multiple of step, e.g. to bucket order book levels.
+func (d Decimal) Floor(step Decimal) Decimal {
+	if step <= 0 {
+		return d
+	}
+	q := int64(d) / int64(step)
+	if int64(d)%int64(step) < 0 {
+		q--
+	}
+	return Decimal(q * int64(step))
+}
+
+// Ceil rounds d up to a multiple of step.
+func (d Decimal) Ceil(step Decimal) Decimal {
+	f := d.Floor(step)
+	if f != d {
+		return f + step
+	}
+	return f
+}
+
+func abs(i int64) int64 {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+// String formats d without trailing zeros, e.g. "64123.5".
+func (d Decimal) String() string {
+	return strings.TrimSuffix(strings.TrimRight(d.StringFixed(Places), "0"), ".")
+}
+
+// StringFixed formats d with exactly places fractional digits, rounding
+// half away from zero, e.g. StringFixed(2) of 0.105 is "0.11".
+func (d Decimal) StringFixed(places int) string {
+	places = min(max(places, 0), Places)
+	r := int64(d.Round(places))
+	sign := ""
+	var u uint64
+	if r < 0 {
+		sign, u = "-", uint64(-r)
+	} else {
+		u = uint64(r)
+	}
+	s := sign + strconv.FormatUint(u/Scale, 10)
+	if places == 0 {
+		return s
+	}
+	frac := fmt.Sprintf("%08d", u%Scale)
+	return s + "." + frac[:places]
+}
+
+// Places returns the number of fractional digits d needs, e.g. 2 for a
+// tick size of 0.01.
+func (d Decimal) Places() int {
+	for p := 0; p < Places; p++ {
+		if int64(d)%pow10[Places-p] == 0 {
+			return p
+		}
+	}
+	return Places
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON accepts both number literals and quoted strings, since venues
+// (and older messages) send prices as strings.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+func (d Decimal) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Decimal) UnmarshalText(text []byte) error {
+	v, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// Value writes d as NUMERIC text, so no precision is lost in the database.
+func (d Decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+// Scan reads NUMERIC (delivered as text) and, for older DOUBLE PRECISION
+// columns, float values.
+func (d *Decimal) Scan(src any) error {
+	var err error
+	switch v := src.(type) {
+	case nil:
+		*d = 0
+	case string:
+		*d, err = Parse(v)
+	case []byte:
+		*d, err = Parse(string(v))
+	case int64:
+		*d, err = FromInt(v)
+	case float64:
+		// shortest representation that round-trips, e.g. 0.1 rather than 0.1000000000000000055
+		*d, err = Parse(strconv.FormatFloat(v, 'f', -1, 64))
+		if errors.Is(err, ErrSyntax) {
+			*d, err = FromFloat(v)
+		}
+	default:
+		err = fmt.Errorf("decimal: cannot scan %T", src)
+	}
+	return err
+}
+
+// Precision is a symbol's tick size (price increment) and step size
+// (quantity increment). A zero field means no rounding.
+type Precision struct {
+	Tick Decimal `yaml:"tick" json:"tick"`
+	Step Decimal `yaml:"step" json:"step"`
+}
+
+func (p Precision) Price(d Decimal) Decimal    { return d.Quantize(p.Tick) }
+func (p Precision) Quantity(d Decimal) Decimal { return d.Quantize(p.Step) }
+
+// PriceString formats a price with the symbol's tick decimals.
+func (p Precision) PriceString(d Decimal) string {
+	if p.Tick == 0 {
+		return d.String()
+	}
+	return d.StringFixed(p.Tick.Places())
+}
+
+// QuantityString formats a quantity with the symbol's step decimals.
+func (p Precision) QuantityString(d Decimal) string {
+	if p.Step == 0 {
+		return d.String()
+	}
+	return d.StringFixed(p.Step.Places())
+}
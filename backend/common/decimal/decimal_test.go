@@ -0,0 +1,219 @@
+package decimal
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Decimal
+		err  error
+	}{
+		{"64123.50", 6_412_350_000_000, nil},
+		{"-0.1", -10_000_000, nil},
+		{"1e-5", 1_000, nil},
+		{"2.5E2", 25_000_000_000, nil},
+		{"0.000000001", 0, ErrSyntax},
+		{"abc", 0, ErrSyntax},
+		{"1e41", 0, ErrRange},
+		{"1e-41", 0, ErrRange},
+		{"1e9223372036854775807", 0, ErrRange},
+		{"0.1e-9223372036854775808", 0, ErrRange},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Parse(%q) error = %v, want %v", tt.in, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMulDiv(t *testing.T) {
+	tests := []struct {
+		op      string
+		a, b    string
+		want    string
+		product bool
+	}{
+		{"mul", "1.5", "2.25", "3.375", true},
+		{"mul", "-1.5", "2", "-3", true},
+		{"mul", "-1.5", "-2", "3", true},
+		// half a unit rounds away from zero, less than half rounds to zero
+		{"mul", "0.00000001", "0.5", "0.00000001", true},
+		{"mul", "-0.00000001", "0.5", "-0.00000001", true},
+		{"mul", "0.00000001", "0.4", "0", true},
+		{"div", "1", "3", "0.33333333", false},
+		{"div", "2", "3", "0.66666667", false},
+		{"div", "-2", "3", "-0.66666667", false},
+		{"div", "1", "-8", "-0.125", false},
+		{"div", "0.00000001", "2", "0.00000001", false},
+		{"div", "-0.00000001", "2", "-0.00000001", false},
+		{"div", "0.00000001", "-3", "0", false},
+		{"mul", "90000000000", "1.03", "", true},
+		{"mul", "-90000000000", "1.03", "", true},
+		{"mul", "400000", "400000", "", true},
+		{"div", "1", "0", "", false},
+		{"div", "90000000000", "0.5", "", false},
+		{"div", "-90000000000", "0.00000001", "", false},
+	}
+	for _, tt := range tests {
+		a, b := MustParse(tt.a), MustParse(tt.b)
+		got, err := a.Div(b)
+		if tt.product {
+			got, err = a.Mul(b)
+		}
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s %s %s = %s, want an error", tt.a, tt.op, tt.b, got)
+			}
+			continue
+		}
+		if err != nil || got != MustParse(tt.want) {
+			t.Errorf("%s %s %s = %s, %v, want %s", tt.a, tt.op, tt.b, got, err, tt.want)
+		}
+	}
+
+	if _, err := MustParse("1").Div(0); !errors.Is(err, ErrDivisionByZero) {
+		t.Errorf("1 div 0 error = %v, want %v", err, ErrDivisionByZero)
+	}
+	if _, err := MustParse("400000").Mul(MustParse("400000")); !errors.Is(err, ErrRange) {
+		t.Errorf("400000 mul 400000 error = %v, want %v", err, ErrRange)
+	}
+}
+
+func TestFromInt(t *testing.T) {
+	tests := []struct {
+		in   int64
+		want Decimal
+		err  error
+	}{
+		{0, 0, nil},
+		{3, 300_000_000, nil},
+		{-3, -300_000_000, nil},
+		{92_233_720_368, 9_223_372_036_800_000_000, nil},
+		{-92_233_720_368, -9_223_372_036_800_000_000, nil},
+		{92_233_720_369, 0, ErrRange},
+		{-92_233_720_369, 0, ErrRange},
+	}
+	for _, tt := range tests {
+		got, err := FromInt(tt.in)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("FromInt(%d) = %d, %v, want %d, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestRounding(t *testing.T) {
+	tests := []struct {
+		name string
+		got  Decimal
+		want string
+	}{
+		{"Round(1.005, 2)", MustParse("1.005").Round(2), "1.01"},
+		{"Round(-1.005, 2)", MustParse("-1.005").Round(2), "-1.01"},
+		{"Round(1.004, 2)", MustParse("1.004").Round(2), "1"},
+		{"Round(2.5, 0)", MustParse("2.5").Round(0), "3"},
+		{"Round(-2.5, 0)", MustParse("-2.5").Round(0), "-3"},
+		{"Round(2.5, -1)", MustParse("2.5").Round(-1), "3"},
+		{"Round(0.12345678, 8)", MustParse("0.12345678").Round(8), "0.12345678"},
+		{"Quantize(64123.456, 0.01)", MustParse("64123.456").Quantize(MustParse("0.01")), "64123.46"},
+		{"Quantize(0.125, 0.25)", MustParse("0.125").Quantize(MustParse("0.25")), "0.25"},
+		{"Quantize(-0.125, 0.25)", MustParse("-0.125").Quantize(MustParse("0.25")), "-0.25"},
+		{"Quantize(1.2, 0.5)", MustParse("1.2").Quantize(MustParse("0.5")), "1"},
+		{"Quantize(1.2, 0)", MustParse("1.2").Quantize(0), "1.2"},
+		{"Floor(1.37, 0.5)", MustParse("1.37").Floor(MustParse("0.5")), "1"},
+		{"Floor(-1.37, 0.5)", MustParse("-1.37").Floor(MustParse("0.5")), "-1.5"},
+		{"Floor(1.5, 0.5)", MustParse("1.5").Floor(MustParse("0.5")), "1.5"},
+		{"Floor(-1.5, 0.5)", MustParse("-1.5").Floor(MustParse("0.5")), "-1.5"},
+		{"Floor(1.37, 0)", MustParse("1.37").Floor(0), "1.37"},
+		{"Ceil(1.37, 0.5)", MustParse("1.37").Ceil(MustParse("0.5")), "1.5"},
+		{"Ceil(-1.37, 0.5)", MustParse("-1.37").Ceil(MustParse("0.5")), "-1"},
+		{"Ceil(1.5, 0.5)", MustParse("1.5").Ceil(MustParse("0.5")), "1.5"},
+		{"Ceil(-1.5, 0.5)", MustParse("-1.5").Ceil(MustParse("0.5")), "-1.5"},
+		{"Ceil(1.37, 0)", MustParse("1.37").Ceil(0), "1.37"},
+		{"Quantize(-1.2, 0.5)", MustParse("-1.2").Quantize(MustParse("0.5")), "-1"},
+		{"Quantize(-1.25, 0.5)", MustParse("-1.25").Quantize(MustParse("0.5")), "-1.5"},
+	}
+	for _, tt := range tests {
+		if tt.got != MustParse(tt.want) {
+			t.Errorf("%s = %s, want %s", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestStringFixed(t *testing.T) {
+	tests := []struct {
+		in     string
+		places int
+		want   string
+	}{
+		{"0.105", 2, "0.11"},
+		{"-0.105", 2, "-0.11"},
+		{"64123.5", 2, "64123.50"},
+		{"1.99999999", 2, "2.00"},
+		{"-0.004", 2, "0.00"},
+		{"12", 0, "12"},
+		{"-0.5", 0, "-1"},
+		{"1.5", 10, "1.50000000"},
+		{"1.5", -1, "2"},
+	}
+	for _, tt := range tests {
+		if got := MustParse(tt.in).StringFixed(tt.places); got != tt.want {
+			t.Errorf("StringFixed(%s, %d) = %q, want %q", tt.in, tt.places, got, tt.want)
+		}
+	}
+	if got := MustParse("-64123.50000").String(); got != "-64123.5" {
+		t.Errorf("String() = %q, want -64123.5", got)
+	}
+	if got := MustParse("0.01").Places(); got != 2 {
+		t.Errorf("Places of 0.01 = %d, want 2", got)
+	}
+}
+
+func TestScanValue(t *testing.T) {
+	tests := []struct {
+		src  any
+		want string
+	}{
+		{"64123.50", "64123.5"},
+		{[]byte("-0.1"), "-0.1"},
+		{int64(3), "3"},
+		{0.1, "0.1"},
+		{-2.75, "-2.75"},
+		// floats with more places than a Decimal are rounded
+		{1e-10, "0"},
+		{1.000000005, "1.00000001"},
+		{nil, "0"},
+	}
+	for _, tt := range tests {
+		d := MustParse("7")
+		if err := d.Scan(tt.src); err != nil {
+			t.Errorf("Scan(%v): %v", tt.src, err)
+			continue
+		}
+		if d != MustParse(tt.want) {
+			t.Errorf("Scan(%v) = %s, want %s", tt.src, d, tt.want)
+		}
+		v, err := d.Value()
+		if err != nil || v != tt.want {
+			t.Errorf("Value() of %s = %v, %v, want %q", d, v, err, tt.want)
+		}
+	}
+
+	var d Decimal
+	if err := d.Scan(true); err == nil {
+		t.Error("Scan(bool) succeeded, want an error")
+	}
+	if err := d.Scan(int64(1e11)); !errors.Is(err, ErrRange) {
+		t.Errorf("Scan(1e11) error = %v, want %v", err, ErrRange)
+	}
+	if err := d.Scan("1.5x"); !errors.Is(err, ErrSyntax) {
+		t.Errorf("Scan(1.5x) error = %v, want %v", err, ErrSyntax)
+	}
+}
@@ -0,0 +1,63 @@
+// Package lifecycle holds the shutdown steps every service shares.
+//
+// A service main runs until the context from Context is cancelled by
+// SIGINT or SIGTERM, then stops taking new work, lets in-flight work finish
+// within ShutdownTimeout and exits. DrainNATS and ShutdownServer are the
+// last steps for the NATS connection and the HTTP listener.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// ShutdownTimeout bounds each shutdown step. docker compose waits
+// stop_grace_period before it kills a container, so keep that larger.
+const ShutdownTimeout = 10 * time.Second
+
+// Context returns a context cancelled on SIGINT or SIGTERM. After the first
+// signal the default handling is restored, so a second one kills the
+// process if shutdown hangs.
+func Context() (context.Context, context.CancelFunc) {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+// DrainNATS unsubscribes every subscription, waits for their callbacks to
+// process what was already received, flushes pending publishes and closes
+// the connection. The connection is closed anyway after ShutdownTimeout.
+func DrainNATS(nc *nats.Conn) error {
+	closed := make(chan struct{})
+	nc.SetClosedHandler(func(*nats.Conn) { close(closed) })
+	if err := nc.Drain(); err != nil {
+		nc.Close()
+		return err
+	}
+	select {
+	case <-closed:
+		return nil
+	case <-time.After(ShutdownTimeout):
+		nc.Close()
+		return errors.New("timed out draining NATS connection")
+	}
+}
+
+// ShutdownServer stops srv from accepting connections and waits for active
+// requests. Hijacked connections (websockets) are not tracked by the server
+// and must be closed by the caller.
+func ShutdownServer(srv *http.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
@@ -0,0 +1,119 @@
+package lifecycle
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"rdr/common/lifecycle/lifecycletest"
+)
+
+func TestContextCancelledBySignal(t *testing.T) {
+	for _, sig := range []syscall.Signal{syscall.SIGINT, syscall.SIGTERM} {
+		ctx, stop := Context()
+		if err := syscall.Kill(syscall.Getpid(), sig); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(2 * time.Second):
+			t.Fatalf("context not cancelled by %v", sig)
+		}
+		stop()
+	}
+}
+
+// A SIGTERM starts shutdown; draining lets the subscription finish the
+// message it is processing and flushes what was published meanwhile.
+func TestDrainNATSOnSIGTERM(t *testing.T) {
+	server := lifecycletest.NewFakeNATS(t)
+	nc, err := nats.Connect(server.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := <-server.Conn
+
+	started := make(chan struct{})
+	var handled atomic.Bool
+	_, err = nc.Subscribe("md.v1.trade.*.*", func(msg *nats.Msg) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		nc.Publish("processed", msg.Data)
+		handled.Store(true)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sid := lifecycletest.Receive(t, server.Subs, "SUB")
+	fmt.Fprintf(conn, "MSG md.v1.trade.binance.BTCUSDT %s 2\r\n{}\r\n", sid)
+	<-started
+
+	ctx, stop := Context()
+	defer stop()
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	<-ctx.Done()
+
+	if err := DrainNATS(nc); err != nil {
+		t.Fatal(err)
+	}
+	if !handled.Load() {
+		t.Error("DrainNATS returned before the in-flight message was handled")
+	}
+	if !nc.IsClosed() {
+		t.Error("connection still open after DrainNATS")
+	}
+	if got := lifecycletest.Receive(t, server.Unsubs, "UNSUB"); got != sid {
+		t.Errorf("unsubscribed %s, want %s", got, sid)
+	}
+	if got := lifecycletest.Receive(t, server.Pubs, "PUB"); got != "processed" {
+		t.Errorf("published %s, want processed", got)
+	}
+}
+
+func TestShutdownServerFinishesRequests(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(w, "done")
+	})}
+	go srv.Serve(l)
+
+	type result struct {
+		body string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String())
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		done <- result{string(body), err}
+	}()
+	<-started
+
+	if err := ShutdownServer(srv); err != nil {
+		t.Fatal(err)
+	}
+	if res := <-done; res.err != nil || res.body != "done" {
+		t.Errorf("in-flight request got %q, %v; want it to complete", res.body, res.err)
+	}
+	if _, err := http.Get("http://" + l.Addr().String()); err == nil {
+		t.Error("server still accepts requests after ShutdownServer")
+	}
+}
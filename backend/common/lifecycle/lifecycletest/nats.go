@@ -0,0 +1,89 @@
+// Package lifecycletest provides a fake NATS server for testing how
+// services shut down.
+package lifecycletest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// FakeNATS speaks just enough of the NATS protocol for one client: it
+// answers pings, records subscriptions and publishes, and delivers messages
+// written to Conn.
+type FakeNATS struct {
+	listener net.Listener
+	// Conn receives the client connection once it is accepted
+	Conn chan net.Conn
+	// Subs, Unsubs and Pubs receive the sid of every SUB, the sid of every
+	// UNSUB and the subject of every PUB
+	Subs   chan string
+	Unsubs chan string
+	Pubs   chan string
+}
+
+// NewFakeNATS starts a fake server that is stopped when the test ends
+func NewFakeNATS(t testing.TB) *FakeNATS {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &FakeNATS{listener: l, Conn: make(chan net.Conn, 1), Subs: make(chan string, 10), Unsubs: make(chan string, 10), Pubs: make(chan string, 10)}
+	go s.serve()
+	return s
+}
+
+func (s *FakeNATS) URL() string { return "nats://" + s.listener.Addr().String() }
+
+func (s *FakeNATS) serve() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	s.Conn <- conn
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"fake\",\"version\":\"2.10.0\",\"proto\":1,\"max_payload\":1048576}\r\n")
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case "SUB":
+			s.Subs <- fields[len(fields)-1]
+		case "UNSUB":
+			s.Unsubs <- fields[1]
+		case "PUB":
+			n, _ := strconv.Atoi(fields[len(fields)-1])
+			io.CopyN(io.Discard, r, int64(n)+2)
+			s.Pubs <- fields[1]
+		}
+	}
+}
+
+// Receive returns the next value on ch, failing the test if none arrives
+// within two seconds
+func Receive(t testing.TB, ch chan string, what string) string {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+		return ""
+	}
+}
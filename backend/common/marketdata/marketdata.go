@@ -0,0 +1,136 @@
+// Package marketdata defines the venue-neutral messages the ingestor publishes
+// on NATS and every other service consumes.
+//
+// Subjects are versioned and carry the exchange and the canonical (upper-case)
+// symbol, e.g. md.v1.trade.binance.BTCUSDT or md.v1.kline.kraken.ETHUSDT.1m.
+// Prices and quantities are decimal strings exactly as the venue sent them, so
+// no precision is lost between the exchange and the database.
+package marketdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SchemaVersion is stamped into every message as "v" and into every subject.
+const SchemaVersion = 1
+
+const subjectPrefix = "md.v1"
+
+// Wildcard subjects for consumers that want every exchange and symbol.
+const (
+	TradeSubjects        = subjectPrefix + ".trade.*.*"
+	KlineSubjects        = subjectPrefix + ".kline.*.*.*"
+	DepthSubjects        = subjectPrefix + ".depth.*.*"
+	BookSnapshotSubjects = subjectPrefix + ".book.*.*"
+)
+
+func TradeSubject(exchange, symbol string) string {
+	return fmt.Sprintf("%s.trade.%s.%s", subjectPrefix, exchange, symbol)
+}
+
+func KlineSubject(exchange, symbol, interval string) string {
+	return fmt.Sprintf("%s.kline.%s.%s.%s", subjectPrefix, exchange, symbol, interval)
+}
+
+func DepthSubject(exchange, symbol string) string {
+	return fmt.Sprintf("%s.depth.%s.%s", subjectPrefix, exchange, symbol)
+}
+
+func BookSnapshotSubject(exchange, symbol string) string {
+	return fmt.Sprintf("%s.book.%s.%s", subjectPrefix, exchange, symbol)
+}
+
+// Subject is a parsed market data subject.
+type Subject struct {
+	Kind     string
+	Exchange string
+	Symbol   string
+	Interval string
+}
+
+// ParseSubject splits a md.v1.* subject into its parts.
+func ParseSubject(subject string) (Subject, bool) {
+	rest, ok := strings.CutPrefix(subject, subjectPrefix+".")
+	if !ok {
+		return Subject{}, false
+	}
+	parts := strings.Split(rest, ".")
+	switch {
+	case len(parts) == 4 && parts[0] == "kline":
+		return Subject{Kind: parts[0], Exchange: parts[1], Symbol: parts[2], Interval: parts[3]}, true
+	case len(parts) == 3 && parts[0] != "kline":
+		return Subject{Kind: parts[0], Exchange: parts[1], Symbol: parts[2]}, true
+	}
+	return Subject{}, false
+}
+
+// Side is the aggressor (taker) side of a trade.
+type Side string
+
+const (
+	Buy  Side = "buy"
+	Sell Side = "sell"
+)
+
+// Level is one price level: [price, quantity]. A zero quantity in a
+// DepthDelta removes the level.
+type Level [2]string
+
+func (l Level) Price() string    { return l[0] }
+func (l Level) Quantity() string { return l[1] }
+
+type Trade struct {
+	Version  int    `json:"v"`
+	Exchange string `json:"exchange"`
+	Symbol   string `json:"symbol"`
+	TradeID  int64  `json:"tradeId"`
+	Price    string `json:"price"`
+	Quantity string `json:"quantity"`
+	Side     Side   `json:"side"`
+	// Time is the exchange trade time in Unix milliseconds.
+	Time int64 `json:"time"`
+}
+
+type Kline struct {
+	Version   int    `json:"v"`
+	Exchange  string `json:"exchange"`
+	Symbol    string `json:"symbol"`
+	Interval  string `json:"interval"`
+	OpenTime  int64  `json:"openTime"`
+	CloseTime int64  `json:"closeTime"`
+	Open      string `json:"open"`
+	High      string `json:"high"`
+	Low       string `json:"low"`
+	Close     string `json:"close"`
+	Volume    string `json:"volume"`
+	Trades    int64  `json:"trades"`
+	Closed    bool   `json:"closed"`
+	// EventTime is when the exchange emitted this update, in Unix milliseconds.
+	EventTime int64 `json:"eventTime"`
+}
+
+// DepthDelta is an incremental order book update. Update IDs are the venue's
+// sequence numbers (or a locally assigned sequence for venues without one);
+// a delta applies to a book whose last update ID is FirstUpdateID-1.
+type DepthDelta struct {
+	Version       int     `json:"v"`
+	Exchange      string  `json:"exchange"`
+	Symbol        string  `json:"symbol"`
+	FirstUpdateID int64   `json:"firstUpdateId"`
+	FinalUpdateID int64   `json:"finalUpdateId"`
+	Bids          []Level `json:"bids"`
+	Asks          []Level `json:"asks"`
+	Time          int64   `json:"time"`
+}
+
+// BookSnapshot is a full (or top-N) order book at LastUpdateID.
+type BookSnapshot struct {
+	Version      int     `json:"v"`
+	Exchange     string  `json:"exchange"`
+	Symbol       string  `json:"symbol"`
+	LastUpdateID int64   `json:"lastUpdateId"`
+	Bids         []Level `json:"bids"`
+	Asks         []Level `json:"asks"`
+	Time         int64   `json:"time"`
+}
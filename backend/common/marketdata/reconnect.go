@@ -0,0 +1,27 @@
+package marketdata
+
+// Reconnect events are not market data and are not versioned with it; the
+// ingestor publishes one on ingestor.reconnect.<symbol> (the venue's stream
+// symbol, e.g. btcusdt) whenever a dropped stream is restored.
+const reconnectSubjectPrefix = "ingestor.reconnect"
+
+// ReconnectSubjects matches every reconnect event.
+const ReconnectSubjects = reconnectSubjectPrefix + ".*"
+
+func ReconnectSubject(symbol string) string {
+	return reconnectSubjectPrefix + "." + symbol
+}
+
+// ReconnectEvent reports a restored ingestor stream. Consumers that keep
+// state built from the stream (e.g. the order book manager for depth) must
+// resync, since updates sent during the downtime were lost.
+type ReconnectEvent struct {
+	Exchange   string `json:"exchange"`
+	Kind       string `json:"kind"`
+	Symbol     string `json:"symbol"`
+	Interval   string `json:"interval,omitempty"`
+	Attempts   int    `json:"attempts"`
+	Reason     string `json:"reason"`
+	DowntimeMs int64  `json:"downtimeMs"`
+	Time       int64  `json:"time"`
+}
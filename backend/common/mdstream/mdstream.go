@@ -0,0 +1,66 @@
+// Package mdstream defines the JetStream streams that persist market data.
+//
+// Trades, klines and depth deltas are written to the database by the
+// archiver, so they go through JetStream: a consumer that restarts resumes
+// from its durable position instead of losing whatever was published while
+// it was down. Archived deltas must be gap-free to rebuild historical books.
+// Venue book snapshots stay on core NATS.
+//
+// Core NATS subscribers (e.g. the api broadcaster) keep receiving persisted
+// subjects as before; JetStream only adds a stored copy.
+package mdstream
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"rdr/common/config"
+	"rdr/common/marketdata"
+)
+
+const (
+	TradesStream = "MD_TRADES"
+	KlinesStream = "MD_KLINES"
+	DepthStream  = "MD_DEPTH"
+)
+
+// StreamConfigs returns the stream definitions with the configured limits.
+func StreamConfigs(limits config.JetStreamConfig) []jetstream.StreamConfig {
+	stream := func(name, subject, description string) jetstream.StreamConfig {
+		return jetstream.StreamConfig{
+			Name:        name,
+			Description: description,
+			Subjects:    []string{subject},
+			Retention:   jetstream.LimitsPolicy,
+			Discard:     jetstream.DiscardOld,
+			Storage:     jetstream.FileStorage,
+			MaxAge:      limits.MaxAge,
+			MaxBytes:    limits.MaxBytes,
+			Replicas:    limits.Replicas,
+		}
+	}
+	return []jetstream.StreamConfig{
+		stream(TradesStream, marketdata.TradeSubjects, "Normalized trades from all venues"),
+		stream(KlinesStream, marketdata.KlineSubjects, "Normalized kline updates from all venues"),
+		stream(DepthStream, marketdata.DepthSubjects, "Normalized order book depth deltas from all venues"),
+	}
+}
+
+// Ensure creates the streams or updates their limits. Publishers and
+// consumers both call it, so start-up order does not matter.
+func Ensure(ctx context.Context, js jetstream.JetStream, limits config.JetStreamConfig) error {
+	for _, cfg := range StreamConfigs(limits) {
+		if _, err := js.CreateOrUpdateStream(ctx, cfg); err != nil {
+			return fmt.Errorf("stream %s: %w", cfg.Name, err)
+		}
+	}
+	return nil
+}
+
+// Persisted reports whether subject is captured by one of the streams.
+func Persisted(subject string) bool {
+	s, ok := marketdata.ParseSubject(subject)
+	return ok && (s.Kind == "trade" || s.Kind == "kline" || s.Kind == "depth")
+}
@@ -0,0 +1,59 @@
+package orderbook
+
+import "rdr/common/decimal"
+
+// AggregatedLevel is a price bucket of a Side. Total is the cumulative
+// quantity from the best bucket up to and including this one.
+type AggregatedLevel struct {
+	Price    decimal.Decimal `json:"price"`
+	Quantity decimal.Decimal `json:"amount"`
+	Total    decimal.Decimal `json:"total"`
+}
+
+// Aggregate returns up to depth buckets, best first. Levels are grouped into
+// buckets of width group: bids round down and asks round up, so a bucket
+// never shows a better price than the levels it holds. A zero group keeps
+// every level as its own bucket.
+func (s *Side) Aggregate(depth int, group decimal.Decimal) []AggregatedLevel {
+	out := make([]AggregatedLevel, 0, min(depth, s.length))
+	var total decimal.Decimal
+	s.Each(func(l Level) bool {
+		price := l.Price
+		if group > 0 {
+			if s.bids {
+				price = price.Floor(group)
+			} else {
+				price = price.Ceil(group)
+			}
+		}
+		total = total.Add(l.Quantity)
+		if n := len(out); n > 0 && out[n-1].Price == price {
+			out[n-1].Quantity = out[n-1].Quantity.Add(l.Quantity)
+			out[n-1].Total = total
+			return true
+		}
+		if len(out) == depth {
+			return false
+		}
+		out = append(out, AggregatedLevel{Price: price, Quantity: l.Quantity, Total: total})
+		return true
+	})
+	return out
+}
+
+// Imbalance returns (bid - ask) / (bid + ask) over the cumulative quantity
+// of the given buckets: +1 when only bids rest, -1 when only asks do. It is
+// zero for an empty book.
+func Imbalance(bids, asks []AggregatedLevel) float64 {
+	var bid, ask float64
+	if n := len(bids); n > 0 {
+		bid = bids[n-1].Total.Float64()
+	}
+	if n := len(asks); n > 0 {
+		ask = asks[n-1].Total.Float64()
+	}
+	if bid+ask == 0 {
+		return 0
+	}
+	return (bid - ask) / (bid + ask)
+}
@@ -0,0 +1,80 @@
+package orderbook
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"rdr/common/decimal"
+)
+
+func sideOf(bids bool, levels ...string) *Side {
+	side := NewAsks()
+	if bids {
+		side = NewBids()
+	}
+	for _, l := range levels {
+		price, qty, _ := strings.Cut(l, "x")
+		side.Set(decimal.MustParse(price), decimal.MustParse(qty))
+	}
+	return side
+}
+
+func bucketList(levels []AggregatedLevel) string {
+	out := make([]string, len(levels))
+	for i, l := range levels {
+		out[i] = fmt.Sprintf("%sx%s=%s", l.Price, l.Quantity, l.Total)
+	}
+	return strings.Join(out, " ")
+}
+
+func TestAggregate(t *testing.T) {
+	bids := sideOf(true, "100.4x1", "100.2x2", "99.9x3", "99.5x1", "98x5")
+	asks := sideOf(false, "100.6x1", "100.7x2", "101x1", "101.2x4")
+	tests := []struct {
+		name  string
+		side  *Side
+		depth int
+		group string
+		want  string
+	}{
+		{"bids ungrouped", bids, 10, "0", "100.4x1=1 100.2x2=3 99.9x3=6 99.5x1=7 98x5=12"},
+		{"asks ungrouped", asks, 10, "0", "100.6x1=1 100.7x2=3 101x1=4 101.2x4=8"},
+		{"ungrouped depth", bids, 2, "0", "100.4x1=1 100.2x2=3"},
+		// bids round down to the bucket, a price on the boundary stays in its own bucket
+		{"bids grouped", bids, 10, "0.5", "100x3=3 99.5x4=7 98x5=12"},
+		// asks round up, so no bucket shows a better price than its levels
+		{"asks grouped", asks, 10, "0.5", "101x4=4 101.5x4=8"},
+		// the last bucket still collects every level that rounds into it
+		{"grouped depth", bids, 2, "0.5", "100x3=3 99.5x4=7"},
+		{"wide group", asks, 10, "5", "105x8=8"},
+		{"empty side", NewBids(), 10, "1", ""},
+	}
+	for _, tt := range tests {
+		if got := bucketList(tt.side.Aggregate(tt.depth, decimal.MustParse(tt.group))); got != tt.want {
+			t.Errorf("%s: Aggregate(%d, %s) = %s, want %s", tt.name, tt.depth, tt.group, got, tt.want)
+		}
+	}
+}
+
+func TestImbalance(t *testing.T) {
+	bids := sideOf(true, "100x3", "99x9").Aggregate(10, 0)
+	asks := sideOf(false, "101x1", "102x7").Aggregate(10, 0)
+	tests := []struct {
+		name       string
+		bids, asks []AggregatedLevel
+		want       float64
+	}{
+		// cumulative totals of 12 and 8, not the best levels alone
+		{"both sides", bids, asks, 0.2},
+		{"top of book", bids[:1], asks[:1], 0.5},
+		{"only bids", bids, nil, 1},
+		{"only asks", nil, asks, -1},
+		{"empty", nil, nil, 0},
+	}
+	for _, tt := range tests {
+		if got := Imbalance(tt.bids, tt.asks); got != tt.want {
+			t.Errorf("%s: Imbalance = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
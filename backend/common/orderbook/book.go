@@ -0,0 +1,104 @@
+// Package orderbook keeps a local limit order book built from a snapshot and
+// the venue's depth deltas.
+//
+// Prices and quantities are fixed-point decimals kept in a sorted Side per
+// direction, so best bid/ask, top-N and price-range queries never re-sort.
+// The apply rules live here so every service that rebuilds a book from
+// md.v1 depth deltas agrees on the result.
+package orderbook
+
+import (
+	"errors"
+	"fmt"
+
+	"rdr/common/decimal"
+	"rdr/common/marketdata"
+)
+
+// ErrGap is returned by Apply when a delta does not continue the book's
+// sequence; the book must be rebuilt from a new snapshot.
+var ErrGap = errors.New("depth delta does not continue the book")
+
+type Book struct {
+	Bids         *Side
+	Asks         *Side
+	LastUpdateID int64
+	// Precision rounds incoming prices to the tick size and quantities to
+	// the step size, so equal prices always map to the same level.
+	Precision decimal.Precision
+}
+
+func NewBook(precision decimal.Precision) *Book {
+	return &Book{Bids: NewBids(), Asks: NewAsks(), Precision: precision}
+}
+
+// Reset replaces the book with a snapshot taken at lastUpdateID.
+func (b *Book) Reset(lastUpdateID int64, bids, asks []marketdata.Level) error {
+	parsedBids, err := b.parseLevels(bids)
+	if err != nil {
+		return err
+	}
+	parsedAsks, err := b.parseLevels(asks)
+	if err != nil {
+		return err
+	}
+	b.Bids.Clear()
+	b.Asks.Clear()
+	setLevels(b.Bids, parsedBids)
+	setLevels(b.Asks, parsedAsks)
+	b.LastUpdateID = lastUpdateID
+	return nil
+}
+
+// Apply applies d if it continues the book. A delta already covered by the
+// book (FinalUpdateID <= LastUpdateID) is ignored; one that starts after
+// LastUpdateID+1 returns ErrGap. This is Binance's rule
+// U <= lastUpdateId+1 <= u, which also holds for venues with a local
+// sequence (U == u).
+func (b *Book) Apply(d marketdata.DepthDelta) error {
+	if d.FinalUpdateID <= b.LastUpdateID {
+		return nil
+	}
+	if d.FirstUpdateID > b.LastUpdateID+1 {
+		return fmt.Errorf("%w: expected update %d, got %d-%d", ErrGap, b.LastUpdateID+1, d.FirstUpdateID, d.FinalUpdateID)
+	}
+	bids, err := b.parseLevels(d.Bids)
+	if err != nil {
+		return err
+	}
+	asks, err := b.parseLevels(d.Asks)
+	if err != nil {
+		return err
+	}
+	setLevels(b.Bids, bids)
+	setLevels(b.Asks, asks)
+	b.LastUpdateID = d.FinalUpdateID
+	return nil
+}
+
+// parseLevels validates every level before the book is touched, so a bad
+// message never leaves the book half-applied.
+func (b *Book) parseLevels(levels []marketdata.Level) ([]Level, error) {
+	out := make([]Level, len(levels))
+	for i, l := range levels {
+		price, err := decimal.Parse(l.Price())
+		if err != nil {
+			return nil, fmt.Errorf("price: %w", err)
+		}
+		qty, err := decimal.Parse(l.Quantity())
+		if err != nil {
+			return nil, fmt.Errorf("quantity: %w", err)
+		}
+		if price.Sign() <= 0 || qty.Sign() < 0 {
+			return nil, fmt.Errorf("invalid level [%s, %s]", l.Price(), l.Quantity())
+		}
+		out[i] = Level{Price: b.Precision.Price(price), Quantity: b.Precision.Quantity(qty)}
+	}
+	return out, nil
+}
+
+func setLevels(side *Side, levels []Level) {
+	for _, l := range levels {
+		side.Set(l.Price, l.Quantity)
+	}
+}
@@ -0,0 +1,81 @@
+package orderbook
+
+import (
+	"errors"
+	"testing"
+
+	"rdr/common/decimal"
+	"rdr/common/marketdata"
+)
+
+func newTestBook(t *testing.T) *Book {
+	t.Helper()
+	book := NewBook(decimal.Precision{Tick: decimal.MustParse("0.01"), Step: decimal.MustParse("0.001")})
+	err := book.Reset(100,
+		[]marketdata.Level{{"100.00", "1.000"}, {"99.50", "2.000"}},
+		[]marketdata.Level{{"100.50", "1.500"}, {"101.00", "3.000"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return book
+}
+
+func TestBookApply(t *testing.T) {
+	book := newTestBook(t)
+	err := book.Apply(marketdata.DepthDelta{
+		FirstUpdateID: 95, FinalUpdateID: 103,
+		// 100.004 rounds to the 100.00 tick; a zero quantity removes 99.50
+		Bids: []marketdata.Level{{"100.004", "4.0004"}, {"99.50", "0"}},
+		Asks: []marketdata.Level{{"100.25", "0.5"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.LastUpdateID != 103 {
+		t.Errorf("LastUpdateID = %d, want 103", book.LastUpdateID)
+	}
+	if got := book.Bids.Top(10); len(got) != 1 || got[0] != (Level{decimal.MustParse("100"), decimal.MustParse("4")}) {
+		t.Errorf("bids = %v, want one level 100 x 4", got)
+	}
+	if best, _ := book.Asks.Best(); best.Price != decimal.MustParse("100.25") {
+		t.Errorf("best ask = %v, want 100.25", best)
+	}
+}
+
+func TestBookApplySequence(t *testing.T) {
+	book := newTestBook(t)
+
+	// already covered by the snapshot: ignored
+	if err := book.Apply(marketdata.DepthDelta{FirstUpdateID: 90, FinalUpdateID: 100, Bids: []marketdata.Level{{"100.00", "0"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := book.Bids.Get(decimal.MustParse("100")); !ok || book.LastUpdateID != 100 {
+		t.Error("a stale delta changed the book")
+	}
+
+	// skips 101: gap
+	err := book.Apply(marketdata.DepthDelta{FirstUpdateID: 102, FinalUpdateID: 104})
+	if !errors.Is(err, ErrGap) {
+		t.Errorf("Apply after a gap = %v, want ErrGap", err)
+	}
+	if book.LastUpdateID != 100 {
+		t.Errorf("LastUpdateID = %d after a gap, want 100", book.LastUpdateID)
+	}
+}
+
+func TestBookRejectsMalformedLevels(t *testing.T) {
+	for _, levels := range [][]marketdata.Level{
+		{{"100.00", "1"}, {"abc", "1"}},
+		{{"100.00", "1"}, {"-1", "1"}},
+		{{"100.00", "1"}, {"99.00", "-2"}},
+	} {
+		book := newTestBook(t)
+		if err := book.Apply(marketdata.DepthDelta{FirstUpdateID: 101, FinalUpdateID: 101, Bids: levels}); err == nil {
+			t.Errorf("Apply(%v) succeeded", levels)
+		}
+		// nothing is applied, not even the valid first level
+		if qty, _ := book.Bids.Get(decimal.MustParse("100")); qty != decimal.MustParse("1") || book.LastUpdateID != 100 {
+			t.Errorf("Apply(%v) left the book half-applied", levels)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package orderbook
+
+import "fmt"
+
+// Subjects orderbook_manager publishes the maintained books on.
+const (
+	SnapshotSubjects   = "orderbook.snapshot.*"
+	DiffSubjects       = "orderbook.diff.*"
+	StatsSubjects      = "orderbook.stats.*"
+	CheckpointSubjects = "orderbook.checkpoint.*"
+)
+
+func SnapshotSubject(symbol string) string {
+	return fmt.Sprintf("orderbook.snapshot.%s", symbol)
+}
+
+func DiffSubject(symbol string) string {
+	return fmt.Sprintf("orderbook.diff.%s", symbol)
+}
+
+func StatsSubject(symbol string) string {
+	return fmt.Sprintf("orderbook.stats.%s", symbol)
+}
+
+func CheckpointSubject(symbol string) string {
+	return fmt.Sprintf("orderbook.checkpoint.%s", symbol)
+}
+
+// Snapshot is the top of a book, published on orderbook.snapshot.<SYMBOL>.
+// Checkpoints on orderbook.checkpoint.<SYMBOL> use the same shape with a
+// deeper top and a slower cadence; the archiver stores them so books can be
+// rebuilt from a checkpoint plus the archived depth deltas.
+type Snapshot struct {
+	Exchange     string  `json:"exchange"`
+	Symbol       string  `json:"symbol"`
+	LastUpdateID int64   `json:"lastUpdateId"`
+	Bids         []Level `json:"bids"`
+	Asks         []Level `json:"asks"`
+	Time         int64   `json:"time"`
+}
+
+// Diff lists every level that changed between PrevUpdateID and LastUpdateID,
+// at full depth, published on orderbook.diff.<SYMBOL>. An amount of zero
+// removes the level. A consumer whose book is not at PrevUpdateID has missed
+// a diff and should start over from the next snapshot.
+type Diff struct {
+	Exchange     string  `json:"exchange"`
+	Symbol       string  `json:"symbol"`
+	PrevUpdateID int64   `json:"prevUpdateId"`
+	LastUpdateID int64   `json:"lastUpdateId"`
+	Bids         []Level `json:"bids"`
+	Asks         []Level `json:"asks"`
+	Time         int64   `json:"time"`
+}
@@ -0,0 +1,174 @@
+package orderbook
+
+import (
+	"math/rand/v2"
+
+	"rdr/common/decimal"
+)
+
+// Skiplist parameters: each node is promoted to the next level with
+// probability 1/4, so 16 levels comfortably index millions of price levels.
+const (
+	maxHeight   = 16
+	promoteProb = 4
+)
+
+// Level is one price level of a Side. It is also the JSON shape clients
+// receive: {"price": 64123.5, "amount": 0.25}.
+type Level struct {
+	Price    decimal.Decimal `json:"price"`
+	Quantity decimal.Decimal `json:"amount"`
+}
+
+type node struct {
+	level Level
+	next  [maxHeight]*node
+}
+
+// Side holds one side of a book as a skiplist ordered best price first:
+// descending for bids, ascending for asks. The best level is the first
+// node, so Best is O(1) and Top(n) is O(n); Set and Delete are O(log n).
+//
+// Side is not safe for concurrent use; the owning book guards it.
+type Side struct {
+	bids   bool
+	head   node
+	height int
+	length int
+}
+
+func NewBids() *Side { return &Side{bids: true, height: 1} }
+func NewAsks() *Side { return &Side{height: 1} }
+
+// before reports whether price a sorts ahead of b on this side.
+func (s *Side) before(a, b decimal.Decimal) bool {
+	if s.bids {
+		return a > b
+	}
+	return a < b
+}
+
+// seek fills update with the last node before price on every level and
+// returns the first node at or after it.
+func (s *Side) seek(price decimal.Decimal, update *[maxHeight]*node) *node {
+	x := &s.head
+	for i := s.height - 1; i >= 0; i-- {
+		for x.next[i] != nil && s.before(x.next[i].level.Price, price) {
+			x = x.next[i]
+		}
+		if update != nil {
+			update[i] = x
+		}
+	}
+	return x.next[0]
+}
+
+func randomHeight() int {
+	h := 1
+	for h < maxHeight && rand.IntN(promoteProb) == 0 {
+		h++
+	}
+	return h
+}
+
+// Set stores qty at price; a zero quantity removes the level.
+func (s *Side) Set(price decimal.Decimal, qty decimal.Decimal) {
+	if qty.IsZero() {
+		s.Delete(price)
+		return
+	}
+	var update [maxHeight]*node
+	x := s.seek(price, &update)
+	if x != nil && x.level.Price == price {
+		x.level.Quantity = qty
+		return
+	}
+
+	h := randomHeight()
+	if h > s.height {
+		for i := s.height; i < h; i++ {
+			update[i] = &s.head
+		}
+		s.height = h
+	}
+	n := &node{level: Level{Price: price, Quantity: qty}}
+	for i := 0; i < h; i++ {
+		n.next[i] = update[i].next[i]
+		update[i].next[i] = n
+	}
+	s.length++
+}
+
+// Delete removes the level at price, if present.
+func (s *Side) Delete(price decimal.Decimal) {
+	var update [maxHeight]*node
+	x := s.seek(price, &update)
+	if x == nil || x.level.Price != price {
+		return
+	}
+	for i := 0; i < s.height && update[i].next[i] == x; i++ {
+		update[i].next[i] = x.next[i]
+	}
+	for s.height > 1 && s.head.next[s.height-1] == nil {
+		s.height--
+	}
+	s.length--
+}
+
+// Get returns the quantity at price.
+func (s *Side) Get(price decimal.Decimal) (decimal.Decimal, bool) {
+	x := s.seek(price, nil)
+	if x == nil || x.level.Price != price {
+		return 0, false
+	}
+	return x.level.Quantity, true
+}
+
+// Best returns the best level: highest bid or lowest ask.
+func (s *Side) Best() (Level, bool) {
+	if s.head.next[0] == nil {
+		return Level{}, false
+	}
+	return s.head.next[0].level, true
+}
+
+func (s *Side) Len() int { return s.length }
+
+// Top returns up to n levels, best first.
+func (s *Side) Top(n int) []Level {
+	out := make([]Level, 0, min(n, s.length))
+	for x := s.head.next[0]; x != nil && len(out) < n; x = x.next[0] {
+		out = append(out, x.level)
+	}
+	return out
+}
+
+// Each calls fn for every level, best first, until fn returns false.
+func (s *Side) Each(fn func(Level) bool) {
+	for x := s.head.next[0]; x != nil; x = x.next[0] {
+		if !fn(x.level) {
+			return
+		}
+	}
+}
+
+// Range calls fn, best first, for every level priced between lo and hi
+// inclusive, until fn returns false.
+func (s *Side) Range(lo, hi decimal.Decimal, fn func(Level) bool) {
+	from, to := lo, hi
+	if s.bids {
+		from, to = hi, lo
+	}
+	for x := s.seek(from, nil); x != nil && !s.before(to, x.level.Price); x = x.next[0] {
+		if !fn(x.level) {
+			return
+		}
+	}
+}
+
+// Clear removes every level.
+func (s *Side) Clear() {
+	s.head = node{}
+	s.height = 1
+	s.length = 0
+}
@@ -0,0 +1,182 @@
+package orderbook
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"rdr/common/decimal"
+)
+
+// reference is the obviously correct side: a map sorted on demand.
+type reference struct {
+	bids   bool
+	levels map[decimal.Decimal]decimal.Decimal
+}
+
+func (r reference) sorted() []Level {
+	out := make([]Level, 0, len(r.levels))
+	for price, qty := range r.levels {
+		out = append(out, Level{Price: price, Quantity: qty})
+	}
+	slices.SortFunc(out, func(a, b Level) int {
+		if r.bids {
+			return cmp.Compare(b.Price, a.Price)
+		}
+		return cmp.Compare(a.Price, b.Price)
+	})
+	return out
+}
+
+// whole is the Decimal of a whole number.
+func whole(i int64) decimal.Decimal { return decimal.Decimal(i) * decimal.Scale }
+
+func TestSideMatchesReference(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for _, bids := range []bool{true, false} {
+		side := NewAsks()
+		if bids {
+			side = NewBids()
+		}
+		ref := reference{bids: bids, levels: make(map[decimal.Decimal]decimal.Decimal)}
+
+		for i := range 20_000 {
+			price := whole(int64(rng.IntN(500) + 1))
+			qty := decimal.Decimal(rng.IntN(4)) * decimal.Scale / 2
+			switch rng.IntN(3) {
+			case 0:
+				side.Delete(price)
+				delete(ref.levels, price)
+			default:
+				// a zero quantity removes the level, as in a depth delta
+				side.Set(price, qty)
+				if qty.IsZero() {
+					delete(ref.levels, price)
+				} else {
+					ref.levels[price] = qty
+				}
+			}
+
+			if i%1000 != 999 {
+				continue
+			}
+			want := ref.sorted()
+			if side.Len() != len(want) {
+				t.Fatalf("bids=%v: Len = %d, want %d", bids, side.Len(), len(want))
+			}
+			if got := side.Top(side.Len() + 1); !slices.Equal(got, want) {
+				t.Fatalf("bids=%v: levels out of order or wrong\n got %v\nwant %v", bids, got, want)
+			}
+			if best, ok := side.Best(); len(want) > 0 && (!ok || best != want[0]) {
+				t.Fatalf("bids=%v: Best = %v, want %v", bids, best, want[0])
+			}
+			for price, qty := range ref.levels {
+				if got, ok := side.Get(price); !ok || got != qty {
+					t.Fatalf("bids=%v: Get(%s) = %s, %v, want %s", bids, price, got, ok, qty)
+				}
+			}
+		}
+	}
+}
+
+func TestSideRange(t *testing.T) {
+	bids, asks := NewBids(), NewAsks()
+	for _, p := range []int64{10, 11, 12, 13, 14} {
+		bids.Set(whole(p), whole(1))
+		asks.Set(whole(p), whole(1))
+	}
+	collect := func(s *Side, lo, hi int64) []int64 {
+		var out []int64
+		s.Range(whole(lo), whole(hi), func(l Level) bool {
+			out = append(out, int64(l.Price/decimal.Scale))
+			return true
+		})
+		return out
+	}
+	if got := collect(bids, 11, 13); !slices.Equal(got, []int64{13, 12, 11}) {
+		t.Errorf("bid range = %v, want best first 13 12 11", got)
+	}
+	if got := collect(asks, 11, 13); !slices.Equal(got, []int64{11, 12, 13}) {
+		t.Errorf("ask range = %v, want best first 11 12 13", got)
+	}
+	if got := collect(asks, 20, 30); len(got) != 0 {
+		t.Errorf("empty range = %v", got)
+	}
+
+	var first []int64
+	asks.Range(whole(0), whole(100), func(l Level) bool {
+		first = append(first, int64(l.Price/decimal.Scale))
+		return len(first) < 2
+	})
+	if !slices.Equal(first, []int64{10, 11}) {
+		t.Errorf("stopped range = %v, want 10 11", first)
+	}
+}
+
+func TestSideClear(t *testing.T) {
+	side := NewBids()
+	for p := range int64(100) {
+		side.Set(whole(p+1), whole(1))
+	}
+	side.Clear()
+	if _, ok := side.Best(); ok || side.Len() != 0 || len(side.Top(10)) != 0 {
+		t.Errorf("cleared side still has levels: %v", side.Top(10))
+	}
+	side.Set(whole(5), whole(2))
+	if best, _ := side.Best(); best.Price != whole(5) {
+		t.Errorf("Best after Clear and Set = %v", best)
+	}
+}
+
+// fullSide is a side with n levels one tick apart, the depth of a full
+// Binance snapshot at n = 5000.
+func fullSide(n int) *Side {
+	side := NewBids()
+	for i := range n {
+		side.Set(decimal.MustParse("60000")-decimal.Decimal(i)*decimal.Scale/100, decimal.MustParse("0.5"))
+	}
+	return side
+}
+
+func BenchmarkSideSet(b *testing.B) {
+	side := fullSide(5000)
+	rng := rand.New(rand.NewPCG(1, 2))
+	b.ReportAllocs()
+	for b.Loop() {
+		// update or re-add one of the existing 5000 levels
+		price := decimal.MustParse("60000") - decimal.Decimal(rng.IntN(5000))*decimal.Scale/100
+		side.Set(price, decimal.Decimal(rng.IntN(2))*decimal.Scale)
+	}
+}
+
+func BenchmarkSideBest(b *testing.B) {
+	side := fullSide(5000)
+	for b.Loop() {
+		side.Best()
+	}
+}
+
+func BenchmarkSideTop20(b *testing.B) {
+	side := fullSide(5000)
+	b.ReportAllocs()
+	for b.Loop() {
+		side.Top(20)
+	}
+}
+
+func BenchmarkSideTop1000(b *testing.B) {
+	side := fullSide(5000)
+	b.ReportAllocs()
+	for b.Loop() {
+		side.Top(1000)
+	}
+}
+
+func BenchmarkSideRange(b *testing.B) {
+	side := fullSide(5000)
+	lo, hi := decimal.MustParse("59950"), decimal.MustParse("59960")
+	for b.Loop() {
+		side.Range(lo, hi, func(Level) bool { return true })
+	}
+}
@@ -0,0 +1,105 @@
+package orderbook
+
+import "rdr/common/decimal"
+
+// DepthBands are the distances from mid, in basis points, that Stats
+// reports cumulative liquidity for.
+var DepthBands = []int{10, 25, 50}
+
+const (
+	two        = 2 * decimal.Scale
+	basisPoint = decimal.Scale / 10_000
+)
+
+// BandDepth is the resting quantity within Bps basis points of mid.
+type BandDepth struct {
+	Bps int             `json:"bps"`
+	Bid decimal.Decimal `json:"bid"`
+	Ask decimal.Decimal `json:"ask"`
+}
+
+// Stats are metrics derived from the top of a book, published on
+// orderbook.stats.<SYMBOL>.
+type Stats struct {
+	Exchange     string          `json:"exchange"`
+	Symbol       string          `json:"symbol"`
+	LastUpdateID int64           `json:"lastUpdateId"`
+	BestBid      Level           `json:"bestBid"`
+	BestAsk      Level           `json:"bestAsk"`
+	Mid          decimal.Decimal `json:"mid"`
+	Spread       decimal.Decimal `json:"spread"`
+	SpreadBps    float64         `json:"spreadBps"`
+	// Microprice weighs the best prices by the opposite side's quantity, so
+	// it leans towards the side more likely to be taken out next.
+	Microprice decimal.Decimal `json:"microprice"`
+	// Imbalance is (bid - ask) / (bid + ask) of the best level quantities.
+	Imbalance float64     `json:"imbalance"`
+	Depth     []BandDepth `json:"depth"`
+	Time      int64       `json:"time"`
+}
+
+// Stats computes the book's metrics. It reports false while either side is
+// empty, since mid and spread are undefined then. Exchange, Symbol and Time
+// are left for the caller.
+func (b *Book) Stats() (Stats, bool) {
+	bid, okBid := b.Bids.Best()
+	ask, okAsk := b.Asks.Best()
+	if !okBid || !okAsk {
+		return Stats{}, false
+	}
+
+	// halving cannot overflow, and two is not zero
+	mid, _ := bid.Price.Add(ask.Price).Div(two)
+	spread := ask.Price.Sub(bid.Price)
+	bidQty, askQty := bid.Quantity.Float64(), ask.Quantity.Float64()
+	s := Stats{
+		LastUpdateID: b.LastUpdateID,
+		BestBid:      bid,
+		BestAsk:      ask,
+		Mid:          mid,
+		Spread:       spread,
+		SpreadBps:    spread.Float64() / mid.Float64() * 1e4,
+		Microprice:   mid,
+		Depth:        make([]BandDepth, len(DepthBands)),
+	}
+	if total := bidQty + askQty; total > 0 {
+		// bid + spread*bidQty/(bidQty+askQty); on overflow the microprice stays at mid
+		shift, err := spread.Mul(bid.Quantity)
+		if err == nil {
+			shift, err = shift.Div(bid.Quantity.Add(ask.Quantity))
+		}
+		if err == nil {
+			s.Microprice = bid.Price.Add(shift)
+		}
+		s.Imbalance = (bidQty - askQty) / total
+	}
+
+	// one pass per side over the widest band fills every narrower one too
+	bounds := make([]decimal.Decimal, len(DepthBands))
+	widest := 0
+	for i, bps := range DepthBands {
+		s.Depth[i].Bps = bps
+		// a fraction of mid always fits
+		bounds[i], _ = mid.Mul(decimal.Decimal(bps) * basisPoint)
+		if bounds[i] > bounds[widest] {
+			widest = i
+		}
+	}
+	b.Bids.Range(mid.Sub(bounds[widest]), mid, func(l Level) bool {
+		for i, bound := range bounds {
+			if l.Price >= mid.Sub(bound) {
+				s.Depth[i].Bid = s.Depth[i].Bid.Add(l.Quantity)
+			}
+		}
+		return true
+	})
+	b.Asks.Range(mid, mid.Add(bounds[widest]), func(l Level) bool {
+		for i, bound := range bounds {
+			if l.Price <= mid.Add(bound) {
+				s.Depth[i].Ask = s.Depth[i].Ask.Add(l.Quantity)
+			}
+		}
+		return true
+	})
+	return s, true
+}
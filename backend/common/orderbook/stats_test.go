@@ -0,0 +1,89 @@
+package orderbook
+
+import (
+	"math"
+	"testing"
+
+	"rdr/common/decimal"
+	"rdr/common/marketdata"
+)
+
+func TestStats(t *testing.T) {
+	book := NewBook(decimal.Precision{})
+	err := book.Reset(7,
+		[]marketdata.Level{{"99.95", "2"}, {"99.92", "1"}, {"99.80", "4"}, {"99.60", "10"}, {"99.40", "50"}},
+		[]marketdata.Level{{"100.05", "1"}, {"100.10", "3"}, {"100.30", "2"}, {"100.60", "5"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ok := book.Stats()
+	if !ok {
+		t.Fatal("Stats reported an empty side")
+	}
+
+	d := decimal.MustParse
+	if s.LastUpdateID != 7 || s.BestBid != (Level{d("99.95"), d("2")}) || s.BestAsk != (Level{d("100.05"), d("1")}) {
+		t.Errorf("top of book = %d %v %v", s.LastUpdateID, s.BestBid, s.BestAsk)
+	}
+	if s.Mid != d("100") || s.Spread != d("0.1") {
+		t.Errorf("mid %s, spread %s, want 100 and 0.1", s.Mid, s.Spread)
+	}
+	if math.Abs(s.SpreadBps-10) > 1e-9 {
+		t.Errorf("spread = %v bps, want 10", s.SpreadBps)
+	}
+	// (99.95*1 + 100.05*2) / 3: twice the quantity on the bid pulls it towards the ask
+	if s.Microprice != d("100.01666667") {
+		t.Errorf("microprice = %s, want 100.01666667", s.Microprice)
+	}
+	if math.Abs(s.Imbalance-1.0/3) > 1e-9 {
+		t.Errorf("imbalance = %v, want 1/3", s.Imbalance)
+	}
+
+	// the bands are 0.1, 0.25 and 0.5 around mid, inclusive; 99.40 is outside all of them
+	want := []BandDepth{
+		{Bps: 10, Bid: d("3"), Ask: d("4")},
+		{Bps: 25, Bid: d("7"), Ask: d("4")},
+		{Bps: 50, Bid: d("17"), Ask: d("6")},
+	}
+	if len(s.Depth) != len(want) {
+		t.Fatalf("depth = %+v, want %+v", s.Depth, want)
+	}
+	for i := range want {
+		if s.Depth[i] != want[i] {
+			t.Errorf("depth[%d] = %+v, want %+v", i, s.Depth[i], want[i])
+		}
+	}
+}
+
+func TestStatsMidRounding(t *testing.T) {
+	book := NewBook(decimal.Precision{})
+	// half a unit between the best prices rounds away from zero
+	err := book.Reset(1, []marketdata.Level{{"1", "3"}}, []marketdata.Level{{"1.00000001", "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := book.Stats()
+	if s.Mid != decimal.MustParse("1.00000001") || s.Microprice != decimal.MustParse("1.00000001") {
+		t.Errorf("mid %s, microprice %s, want 1.00000001", s.Mid, s.Microprice)
+	}
+}
+
+func TestStatsOneSided(t *testing.T) {
+	tests := []struct {
+		name       string
+		bids, asks []marketdata.Level
+	}{
+		{"empty", nil, nil},
+		{"only bids", []marketdata.Level{{"100", "1"}}, nil},
+		{"only asks", nil, []marketdata.Level{{"101", "1"}}},
+	}
+	for _, tt := range tests {
+		book := NewBook(decimal.Precision{})
+		if err := book.Reset(1, tt.bids, tt.asks); err != nil {
+			t.Fatal(err)
+		}
+		if s, ok := book.Stats(); ok {
+			t.Errorf("%s: Stats() = %+v, want false", tt.name, s)
+		}
+	}
+}
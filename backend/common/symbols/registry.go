@@ -0,0 +1,70 @@
+package symbols
+
+import (
+	"slices"
+	"strings"
+	"sync"
+
+	"rdr/common/decimal"
+)
+
+// Registry holds the current metadata of every known instrument. It is safe
+// for concurrent use; Set replaces the whole set, e.g. after a refresh.
+type Registry struct {
+	mu    sync.RWMutex
+	infos map[string]Info
+	// configured is market.precision from the config; an explicit entry
+	// wins over the venue's tick and step size.
+	configured map[string]decimal.Precision
+}
+
+func NewRegistry(configured map[string]decimal.Precision) *Registry {
+	return &Registry{infos: make(map[string]Info), configured: configured}
+}
+
+// Set replaces the registry contents with infos.
+func (r *Registry) Set(infos []Info) {
+	m := make(map[string]Info, len(infos))
+	for _, info := range infos {
+		m[info.Symbol] = info
+	}
+	r.mu.Lock()
+	r.infos = m
+	r.mu.Unlock()
+}
+
+func (r *Registry) Get(symbol string) (Info, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	info, ok := r.infos[symbol]
+	return info, ok
+}
+
+// All returns every instrument ordered by symbol.
+func (r *Registry) All() []Info {
+	r.mu.RLock()
+	out := make([]Info, 0, len(r.infos))
+	for _, info := range r.infos {
+		out = append(out, info)
+	}
+	r.mu.RUnlock()
+	slices.SortFunc(out, func(a, b Info) int { return strings.Compare(a.Symbol, b.Symbol) })
+	return out
+}
+
+func (r *Registry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.infos)
+}
+
+// Precision returns the tick and step size of symbol: the configured entry
+// if there is one, otherwise the venue's. Unknown symbols get the zero
+// Precision, which keeps every decimal.Places digit.
+func (r *Registry) Precision(symbol string) decimal.Precision {
+	if p, ok := r.configured[symbol]; ok {
+		return p
+	}
+	info, _ := r.Get(symbol)
+	return info.Precision()
+}
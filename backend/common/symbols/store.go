@@ -0,0 +1,72 @@
+package symbols
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// EnsureTable creates the symbols table.
+func EnsureTable(ctx context.Context, dbpool *pgxpool.Pool) error {
+	_, err := dbpool.Exec(ctx, `
+	CREATE TABLE IF NOT EXISTS symbols (
+		symbol TEXT PRIMARY KEY,
+		exchange TEXT NOT NULL,
+		base_asset TEXT NOT NULL,
+		quote_asset TEXT NOT NULL,
+		status TEXT NOT NULL,
+		tick_size NUMERIC NOT NULL,
+		step_size NUMERIC NOT NULL,
+		min_qty NUMERIC NOT NULL,
+		min_notional NUMERIC NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
+	);`)
+	return err
+}
+
+// Store upserts infos into the symbols table.
+func Store(ctx context.Context, dbpool *pgxpool.Pool, infos []Info) error {
+	for _, i := range infos {
+		_, err := dbpool.Exec(ctx, `
+			INSERT INTO symbols (symbol, exchange, base_asset, quote_asset, status, tick_size, step_size, min_qty, min_notional, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			ON CONFLICT (symbol) DO UPDATE SET
+				exchange = EXCLUDED.exchange,
+				base_asset = EXCLUDED.base_asset,
+				quote_asset = EXCLUDED.quote_asset,
+				status = EXCLUDED.status,
+				tick_size = EXCLUDED.tick_size,
+				step_size = EXCLUDED.step_size,
+				min_qty = EXCLUDED.min_qty,
+				min_notional = EXCLUDED.min_notional,
+				updated_at = EXCLUDED.updated_at`,
+			i.Symbol, i.Exchange, i.BaseAsset, i.QuoteAsset, i.Status, i.TickSize, i.StepSize, i.MinQty, i.MinNotional, i.UpdatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadStored reads every row of the symbols table.
+func LoadStored(ctx context.Context, dbpool *pgxpool.Pool) ([]Info, error) {
+	rows, err := dbpool.Query(ctx, `
+		SELECT symbol, exchange, base_asset, quote_asset, status, tick_size, step_size, min_qty, min_notional, updated_at
+		FROM symbols ORDER BY symbol`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var infos []Info
+	for rows.Next() {
+		var i Info
+		if err := rows.Scan(&i.Symbol, &i.Exchange, &i.BaseAsset, &i.QuoteAsset, &i.Status, &i.TickSize, &i.StepSize, &i.MinQty, &i.MinNotional, &i.UpdatedAt); err != nil {
+			return nil, err
+		}
+		i.PriceDecimals = i.TickSize.Places()
+		i.QuantityDecimals = i.StepSize.Places()
+		infos = append(infos, i)
+	}
+	return infos, rows.Err()
+}
@@ -0,0 +1,160 @@
+// Package symbols provides instrument metadata (tick size, lot size,
+// base/quote assets, trading status) from Binance's exchangeInfo.
+//
+// Load fetches exchangeInfo over REST and keeps the raw response in a local
+// cache file, so services still start with real instrument specs when the
+// exchange is unreachable. The archiver stores the result in the Timescale
+// "symbols" table (see store.go), from which the api serves /symbols.
+package symbols
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"rdr/common/config"
+	"rdr/common/decimal"
+)
+
+// Info is the metadata of one instrument.
+type Info struct {
+	Symbol      string          `json:"symbol"`
+	Exchange    string          `json:"exchange"`
+	BaseAsset   string          `json:"baseAsset"`
+	QuoteAsset  string          `json:"quoteAsset"`
+	Status      string          `json:"status"`
+	TickSize    decimal.Decimal `json:"tickSize"`
+	StepSize    decimal.Decimal `json:"stepSize"`
+	MinQty      decimal.Decimal `json:"minQty"`
+	MinNotional decimal.Decimal `json:"minNotional"`
+	// PriceDecimals and QuantityDecimals are the digits a client should
+	// display, derived from TickSize and StepSize.
+	PriceDecimals    int       `json:"priceDecimals"`
+	QuantityDecimals int       `json:"quantityDecimals"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+}
+
+// Trading reports whether the instrument is currently open for trading.
+func (i Info) Trading() bool { return i.Status == "TRADING" }
+
+func (i Info) Precision() decimal.Precision {
+	return decimal.Precision{Tick: i.TickSize, Step: i.StepSize}
+}
+
+// --- Binance exchangeInfo ---
+
+type exchangeInfo struct {
+	Symbols []struct {
+		Symbol     string `json:"symbol"`
+		Status     string `json:"status"`
+		BaseAsset  string `json:"baseAsset"`
+		QuoteAsset string `json:"quoteAsset"`
+		Filters    []struct {
+			FilterType  string          `json:"filterType"`
+			TickSize    decimal.Decimal `json:"tickSize"`
+			StepSize    decimal.Decimal `json:"stepSize"`
+			MinQty      decimal.Decimal `json:"minQty"`
+			MinNotional decimal.Decimal `json:"minNotional"`
+		} `json:"filters"`
+	} `json:"symbols"`
+}
+
+// Parse converts a raw exchangeInfo response into Info values.
+func Parse(data []byte, updatedAt time.Time) ([]Info, error) {
+	var raw exchangeInfo
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing exchangeInfo: %w", err)
+	}
+	infos := make([]Info, 0, len(raw.Symbols))
+	for _, s := range raw.Symbols {
+		info := Info{
+			Symbol:     s.Symbol,
+			Exchange:   "binance",
+			BaseAsset:  s.BaseAsset,
+			QuoteAsset: s.QuoteAsset,
+			Status:     s.Status,
+			UpdatedAt:  updatedAt,
+		}
+		for _, f := range s.Filters {
+			switch f.FilterType {
+			case "PRICE_FILTER":
+				info.TickSize = f.TickSize
+			case "LOT_SIZE":
+				info.StepSize, info.MinQty = f.StepSize, f.MinQty
+			case "NOTIONAL", "MIN_NOTIONAL":
+				info.MinNotional = f.MinNotional
+			}
+		}
+		info.PriceDecimals = info.TickSize.Places()
+		info.QuantityDecimals = info.StepSize.Places()
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Fetch requests exchangeInfo for the given symbols and returns the raw body.
+func Fetch(ctx context.Context, restURL string, symbols []string) ([]byte, error) {
+	list, _ := json.Marshal(symbols)
+	endpoint := fmt.Sprintf("%s/api/v3/exchangeInfo?symbols=%s", restURL, url.QueryEscape(string(list)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("exchangeInfo: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Load returns metadata for the configured symbols: fresh from Binance when
+// reachable (refreshing the cache file), otherwise from the cache file.
+func Load(ctx context.Context, cfg config.Config) ([]Info, error) {
+	data, fetchErr := Fetch(ctx, cfg.Binance.RestURL, cfg.Market.Symbols)
+	updatedAt := time.Now().UTC()
+	if fetchErr == nil {
+		if err := os.WriteFile(cfg.Metadata.CacheFile, data, 0o644); err != nil {
+			log.Printf("Could not update exchangeInfo cache %s: %v", cfg.Metadata.CacheFile, err)
+		}
+	} else {
+		var err error
+		data, err = os.ReadFile(cfg.Metadata.CacheFile)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("fetching exchangeInfo: %w", fetchErr), fmt.Errorf("reading cache: %w", err))
+		}
+		log.Printf("exchangeInfo unavailable (%v); using cached %s", fetchErr, cfg.Metadata.CacheFile)
+		if stat, err := os.Stat(cfg.Metadata.CacheFile); err == nil {
+			updatedAt = stat.ModTime().UTC()
+		}
+	}
+
+	infos, err := Parse(data, updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	// the cache may hold more symbols than are configured
+	wanted := make(map[string]bool, len(cfg.Market.Symbols))
+	for _, s := range cfg.Market.Symbols {
+		wanted[s] = true
+	}
+	out := infos[:0]
+	for _, info := range infos {
+		if wanted[info.Symbol] {
+			out = append(out, info)
+		}
+	}
+	return out, nil
+}
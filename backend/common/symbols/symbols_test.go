@@ -0,0 +1,170 @@
+package symbols
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"rdr/common/config"
+	"rdr/common/decimal"
+)
+
+func readFixture(t *testing.T) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/exchangeinfo.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParse(t *testing.T) {
+	updatedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	infos, err := Parse(readFixture(t), updatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Info{
+		{
+			Symbol: "BTCUSDT", Exchange: "binance", BaseAsset: "BTC", QuoteAsset: "USDT", Status: "TRADING",
+			TickSize: decimal.MustParse("0.01"), StepSize: decimal.MustParse("0.00001"),
+			MinQty: decimal.MustParse("0.00001"), MinNotional: decimal.MustParse("5"),
+			PriceDecimals: 2, QuantityDecimals: 5, UpdatedAt: updatedAt,
+		},
+		{
+			// older listings still carry MIN_NOTIONAL instead of NOTIONAL
+			Symbol: "ETHUSDT", Exchange: "binance", BaseAsset: "ETH", QuoteAsset: "USDT", Status: "TRADING",
+			TickSize: decimal.MustParse("0.01"), StepSize: decimal.MustParse("0.0001"),
+			MinQty: decimal.MustParse("0.0001"), MinNotional: decimal.MustParse("10"),
+			PriceDecimals: 2, QuantityDecimals: 4, UpdatedAt: updatedAt,
+		},
+		{
+			Symbol: "LUNAUSDT", Exchange: "binance", BaseAsset: "LUNA", QuoteAsset: "USDT", Status: "BREAK",
+			TickSize: decimal.MustParse("0.0001"), StepSize: decimal.MustParse("0.01"),
+			MinQty:        decimal.MustParse("0.01"),
+			PriceDecimals: 4, QuantityDecimals: 2, UpdatedAt: updatedAt,
+		},
+	}
+	if !reflect.DeepEqual(infos, want) {
+		t.Errorf("Parse() =\n%+v\nwant\n%+v", infos, want)
+	}
+	if !infos[0].Trading() || infos[2].Trading() {
+		t.Error("Trading() should hold for TRADING only")
+	}
+
+	if _, err := Parse([]byte(`{"symbols": [`), updatedAt); err == nil {
+		t.Error("Parse accepted truncated JSON")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	fixture := readFixture(t)
+	up := true
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up {
+			http.Error(w, `{"code":-1003,"msg":"Too many requests"}`, http.StatusTooManyRequests)
+			return
+		}
+		query = r.URL.Query().Get("symbols")
+		w.Write(fixture)
+	}))
+	defer server.Close()
+
+	cfg := config.Default()
+	cfg.Binance.RestURL = server.URL
+	cfg.Market.Symbols = []string{"BTCUSDT", "LUNAUSDT"}
+	cfg.Metadata.CacheFile = filepath.Join(t.TempDir(), "exchangeinfo.json")
+	symbolsOf := func(infos []Info) []string {
+		var out []string
+		for _, info := range infos {
+			out = append(out, info.Symbol)
+		}
+		return out
+	}
+
+	// a successful fetch keeps only the configured symbols and refreshes the cache
+	infos, err := Load(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := symbolsOf(infos); !reflect.DeepEqual(got, []string{"BTCUSDT", "LUNAUSDT"}) {
+		t.Errorf("Load() symbols = %v, want BTCUSDT and LUNAUSDT", got)
+	}
+	if query != `["BTCUSDT","LUNAUSDT"]` {
+		t.Errorf("requested symbols=%s", query)
+	}
+	if cached, err := os.ReadFile(cfg.Metadata.CacheFile); err != nil || string(cached) != string(fixture) {
+		t.Fatalf("cache file holds %d bytes, %v; want the response", len(cached), err)
+	}
+
+	// the cache stands in while the exchange is unreachable
+	up = false
+	cachedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(cfg.Metadata.CacheFile, cachedAt, cachedAt); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Market.Symbols = []string{"ETHUSDT"}
+	infos, err = Load(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Symbol != "ETHUSDT" || !infos[0].UpdatedAt.Equal(cachedAt) {
+		t.Errorf("Load() from cache = %+v, want ETHUSDT updated at the cache time", infos)
+	}
+
+	// without either there is nothing to start from
+	cfg.Metadata.CacheFile = filepath.Join(t.TempDir(), "missing.json")
+	if _, err := Load(context.Background(), cfg); err == nil {
+		t.Error("Load succeeded without the exchange or a cache")
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	infos, err := Parse(readFixture(t), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	configured := map[string]decimal.Precision{"ETHUSDT": {Tick: decimal.MustParse("0.1"), Step: decimal.MustParse("0.001")}}
+	r := NewRegistry(configured)
+	// Set replaces, so the reversed second call is all that remains
+	r.Set(infos[:1])
+	r.Set([]Info{infos[2], infos[1]})
+
+	if r.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", r.Len())
+	}
+	if _, ok := r.Get("BTCUSDT"); ok {
+		t.Error("Get found BTCUSDT after it was replaced")
+	}
+	if info, ok := r.Get("LUNAUSDT"); !ok || info.Status != "BREAK" {
+		t.Errorf("Get(LUNAUSDT) = %+v, %v", info, ok)
+	}
+	var got []string
+	for _, info := range r.All() {
+		got = append(got, info.Symbol)
+	}
+	if !reflect.DeepEqual(got, []string{"ETHUSDT", "LUNAUSDT"}) {
+		t.Errorf("All() = %v, want ordered by symbol", got)
+	}
+
+	tests := []struct {
+		symbol string
+		want   decimal.Precision
+	}{
+		// the configured precision wins over the venue's
+		{"ETHUSDT", configured["ETHUSDT"]},
+		{"LUNAUSDT", decimal.Precision{Tick: decimal.MustParse("0.0001"), Step: decimal.MustParse("0.01")}},
+		{"BTCUSDT", decimal.Precision{}},
+	}
+	for _, tt := range tests {
+		if got := r.Precision(tt.symbol); got != tt.want {
+			t.Errorf("Precision(%s) = %+v, want %+v", tt.symbol, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"rdr/common/marketdata"
+)
+
+// binanceExchange იყენებს Binance-ის combined stream endpoint-ს: ერთ კავშირში ბევრი ნაკადი,
+// შეტყობინებები {"stream":...,"data":...} კონვერტშია.
+type binanceExchange struct {
+	baseURL string
+}
+
+func newBinanceExchange(baseURL string) *binanceExchange {
+	return &binanceExchange{baseURL: baseURL}
+}
+
+func (b *binanceExchange) Name() string { return "binance" }
+
+// binanceStreamName აბრუნებს ნაკადის სახელს Binance-ის ფორმატში
+func binanceStreamName(spec streamSpec) string {
+	switch spec.Kind {
+	case "trade":
+		return spec.Symbol + "@trade"
+	case "kline":
+		return spec.Symbol + "@kline_" + spec.Interval
+	default:
+		return spec.Symbol + "@depth"
+	}
+}
+
+// binanceStreamKey აბრუნებს Binance-ის ნაკადის სახელის შესაბამის გასაღებს
+func binanceStreamKey(name string) (string, bool) {
+	symbol, stream, ok := strings.Cut(name, "@")
+	if !ok {
+		return "", false
+	}
+	switch {
+	case stream == "trade":
+		return streamKey("trade", symbol, ""), true
+	case strings.HasPrefix(stream, "kline_"):
+		return streamKey("kline", symbol, strings.TrimPrefix(stream, "kline_")), true
+	case stream == "depth":
+		return streamKey("depth", symbol, ""), true
+	}
+	return "", false
+}
+
+func (b *binanceExchange) StreamURL(specs []streamSpec) (string, []streamSpec) {
+	if len(specs) == 0 {
+		return b.baseURL, nil
+	}
+	names := make([]string, len(specs))
+	for i, spec := range specs {
+		names[i] = binanceStreamName(spec)
+	}
+	return b.baseURL + "?streams=" + strings.Join(names, "/"), specs
+}
+
+func (b *binanceExchange) ControlMessages(op controlOp, specs []streamSpec, nextID func() int64) ([]controlMessage, error) {
+	params := make([]string, len(specs))
+	for i, spec := range specs {
+		params[i] = binanceStreamName(spec)
+	}
+	id := nextID()
+	return []controlMessage{{ID: id, Payload: map[string]any{"method": op.String(), "params": params, "id": id}}}, nil
+}
+
+// binanceEnvelope არის combined stream-ის შეტყობინების გარსი
+type binanceEnvelope struct {
+	Stream string          `json:"stream"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// binanceMethodResponse არის Binance-ის პასუხი SUBSCRIBE/UNSUBSCRIBE მოთხოვნაზე
+type binanceMethodResponse struct {
+	ID    *int64 `json:"id"`
+	Error *struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	} `json:"error"`
+}
+
+func (b *binanceExchange) Decode(message []byte) (decodedMessage, error) {
+	var envelope binanceEnvelope
+	if err := json.Unmarshal(message, &envelope); err != nil {
+		return decodedMessage{}, err
+	}
+
+	if envelope.Stream == "" {
+		// ნაკადის გარეშე შეტყობინება SUBSCRIBE/UNSUBSCRIBE-ის პასუხია
+		var resp binanceMethodResponse
+		if err := json.Unmarshal(message, &resp); err != nil || resp.ID == nil {
+			return decodedMessage{}, fmt.Errorf("unexpected message: %s", message)
+		}
+		reply := &controlReply{ID: *resp.ID}
+		if resp.Error != nil {
+			reply.Err = fmt.Errorf("rejected by Binance: %s (code %d)", resp.Error.Msg, resp.Error.Code)
+		}
+		return decodedMessage{Reply: reply}, nil
+	}
+
+	symbol, _, _ := strings.Cut(envelope.Stream, "@")
+	key, ok := binanceStreamKey(envelope.Stream)
+	if !ok {
+		return decodedMessage{}, fmt.Errorf("unknown stream %q", envelope.Stream)
+	}
+	event, err := b.normalize(key, strings.ToUpper(symbol), envelope.Data)
+	if err != nil {
+		return decodedMessage{}, fmt.Errorf("stream %s: %w", envelope.Stream, err)
+	}
+	return decodedMessage{Events: []marketEvent{event}}, nil
+}
+
+// --- Binance-ის payload-ები ---
+// encoding/json გასაღებებს რეგისტრის გარეშეც ადარებს, ამიტომ Binance-ის ველები, რომლებიც ჩვენსას
+// მხოლოდ რეგისტრით განსხვავდება (e/E, m/M, l/L, v/V), აქ ცალკე უნდა იყოს აღწერილი, თორემ სხვის ველში ჩაიწერება
+type binanceTrade struct {
+	EventType    string `json:"e"`
+	EventTime    int64  `json:"E"`
+	Symbol       string `json:"s"`
+	TradeID      int64  `json:"t"`
+	Price        string `json:"p"`
+	Quantity     string `json:"q"`
+	TradeTime    int64  `json:"T"`
+	IsBuyerMaker bool   `json:"m"`
+	IsBestMatch  bool   `json:"M"`
+}
+
+type binanceKlineEvent struct {
+	EventType string `json:"e"`
+	EventTime int64  `json:"E"`
+	Kline     struct {
+		StartTime      int64  `json:"t"`
+		CloseTime      int64  `json:"T"`
+		Interval       string `json:"i"`
+		Open           string `json:"o"`
+		Close          string `json:"c"`
+		High           string `json:"h"`
+		Low            string `json:"l"`
+		LastTradeID    int64  `json:"L"`
+		Volume         string `json:"v"`
+		TakerBuyVolume string `json:"V"`
+		NumberOfTrades int64  `json:"n"`
+		IsClosed       bool   `json:"x"`
+	} `json:"k"`
+}
+
+type binanceDepthEvent struct {
+	EventType     string             `json:"e"`
+	EventTime     int64              `json:"E"`
+	FirstUpdateID int64              `json:"U"`
+	FinalUpdateID int64              `json:"u"`
+	Bids          []marketdata.Level `json:"b"`
+	Asks          []marketdata.Level `json:"a"`
+}
+
+// normalize გარდაქმნის Binance-ის payload-ს marketdata-ს შეტყობინებად
+func (b *binanceExchange) normalize(key string, symbol string, data []byte) (marketEvent, error) {
+	kind, _, _ := strings.Cut(key, ":")
+	switch kind {
+	case "trade":
+		var t binanceTrade
+		if err := json.Unmarshal(data, &t); err != nil {
+			return marketEvent{}, err
+		}
+		// m=true ნიშნავს, რომ მყიდველი maker იყო, ანუ აგრესორი გამყიდველია
+		side := marketdata.Buy
+		if t.IsBuyerMaker {
+			side = marketdata.Sell
+		}
+		return newMarketEvent(key, marketdata.TradeSubject(b.Name(), symbol), marketdata.Trade{
+			Version:  marketdata.SchemaVersion,
+			Exchange: b.Name(),
+			Symbol:   symbol,
+			TradeID:  t.TradeID,
+			Price:    t.Price,
+			Quantity: t.Quantity,
+			Side:     side,
+			Time:     t.TradeTime,
+		}), nil
+
+	case "kline":
+		var k binanceKlineEvent
+		if err := json.Unmarshal(data, &k); err != nil {
+			return marketEvent{}, err
+		}
+		return newMarketEvent(key, marketdata.KlineSubject(b.Name(), symbol, k.Kline.Interval), marketdata.Kline{
+			Version:   marketdata.SchemaVersion,
+			Exchange:  b.Name(),
+			Symbol:    symbol,
+			Interval:  k.Kline.Interval,
+			OpenTime:  k.Kline.StartTime,
+			CloseTime: k.Kline.CloseTime,
+			Open:      k.Kline.Open,
+			High:      k.Kline.High,
+			Low:       k.Kline.Low,
+			Close:     k.Kline.Close,
+			Volume:    k.Kline.Volume,
+			Trades:    k.Kline.NumberOfTrades,
+			Closed:    k.Kline.IsClosed,
+			EventTime: k.EventTime,
+		}), nil
+
+	default:
+		var d binanceDepthEvent
+		if err := json.Unmarshal(data, &d); err != nil {
+			return marketEvent{}, err
+		}
+		return newMarketEvent(key, marketdata.DepthSubject(b.Name(), symbol), marketdata.DepthDelta{
+			Version:       marketdata.SchemaVersion,
+			Exchange:      b.Name(),
+			Symbol:        symbol,
+			FirstUpdateID: d.FirstUpdateID,
+			FinalUpdateID: d.FinalUpdateID,
+			Bids:          d.Bids,
+			Asks:          d.Asks,
+			Time:          d.EventTime,
+		}), nil
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"rdr/common/marketdata"
+)
+
+func TestBinanceStream(t *testing.T) {
+	url := fakeVenue(t, func(r *http.Request, conn *websocket.Conn) {
+		if got := r.URL.Query().Get("streams"); got != "btcusdt@trade/btcusdt@kline_1m" {
+			t.Errorf("dialed streams = %q", got)
+		}
+		var req struct {
+			Method string   `json:"method"`
+			Params []string `json:"params"`
+			ID     int64    `json:"id"`
+		}
+		if err := conn.ReadJSON(&req); err != nil {
+			t.Errorf("reading subscribe: %v", err)
+			return
+		}
+		if req.Method != "SUBSCRIBE" || !reflect.DeepEqual(req.Params, []string{"btcusdt@depth"}) || req.ID != 1 {
+			t.Errorf("subscribe request = %+v", req)
+		}
+		for _, name := range []string{"subscribe_reply.json", "trade.json", "kline.json", "depth.json"} {
+			conn.WriteMessage(websocket.TextMessage, fixture(t, "binance/"+name))
+		}
+	})
+
+	// trade და kline URL-შია, depth კი subscribe მოთხოვნით ემატება
+	exchange := newBinanceExchange(url)
+	specs := buildStreamSpecs([]string{"btcusdt"}, []string{"1m"})
+	streamURL, dialed := exchange.StreamURL(specs[:2])
+	if len(dialed) != 2 {
+		t.Fatalf("StreamURL dialed %v, want the trade and kline streams", dialed)
+	}
+	conn := dialVenue(t, exchange, streamURL, specs[2:])
+	decoded := decodeNext(t, exchange, conn, 4)
+
+	if reply := decoded[0].Reply; reply == nil || reply.ID != 1 || reply.Err != nil {
+		t.Fatalf("subscribe reply = %+v", reply)
+	}
+
+	tests := []struct {
+		key     string
+		subject string
+		payload any
+	}{
+		{"trade:btcusdt", "md.v1.trade.binance.BTCUSDT", marketdata.Trade{
+			Version: 1, Exchange: "binance", Symbol: "BTCUSDT", TradeID: 3262186843,
+			Price: "37012.34000000", Quantity: "0.00150000", Side: marketdata.Buy, Time: 1700000000120,
+		}},
+		{"kline:btcusdt:1m", "md.v1.kline.binance.BTCUSDT.1m", marketdata.Kline{
+			Version: 1, Exchange: "binance", Symbol: "BTCUSDT", Interval: "1m",
+			OpenTime: 1699999980000, CloseTime: 1700000039999,
+			Open: "37001.10000000", High: "37015.00000000", Low: "36998.20000000", Close: "37012.34000000",
+			Volume: "12.84100000", Trades: 843, Closed: false, EventTime: 1700000000456,
+		}},
+		{"depth:btcusdt", "md.v1.depth.binance.BTCUSDT", marketdata.DepthDelta{
+			Version: 1, Exchange: "binance", Symbol: "BTCUSDT",
+			FirstUpdateID: 41220119401, FinalUpdateID: 41220119433,
+			Bids: []marketdata.Level{{"37012.33000000", "1.20400000"}, {"37011.90000000", "0.00000000"}},
+			Asks: []marketdata.Level{{"37012.35000000", "0.53100000"}},
+			Time: 1700000000789,
+		}},
+	}
+	for i, tt := range tests {
+		event := singleEvent(t, decoded[i+1])
+		if event.Key != tt.key || event.Subject != tt.subject {
+			t.Errorf("event %d routed to %s on %s, want %s on %s", i, event.Key, event.Subject, tt.key, tt.subject)
+		}
+		if !reflect.DeepEqual(event.Payload, tt.payload) {
+			t.Errorf("event %d payload = %+v, want %+v", i, event.Payload, tt.payload)
+		}
+	}
+}
+
+func TestBinanceRejectedSubscribe(t *testing.T) {
+	decoded, err := newBinanceExchange("").Decode([]byte(`{"error":{"code":2,"msg":"Invalid request: unknown variable"},"id":3}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Reply == nil || decoded.Reply.ID != 3 || decoded.Reply.Err == nil {
+		t.Errorf("reply = %+v, want an error for request 3", decoded.Reply)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+
+	"rdr/common/config"
+)
+
+// --- Control plane: ნაკადების მართვა გაშვების დროს NATS request/reply-ით ---
+const (
+	controlSubscribeSubject   = "control.ingestor.subscribe"
+	controlUnsubscribeSubject = "control.ingestor.unsubscribe"
+	controlStreamsSubject     = "control.ingestor.streams"
+	// controlResyncSubject ხელახლა გამოიწერს სიმბოლოების depth ნაკადებს, რომ ბირჟამ, რომელიც
+	// snapshot-ს ნაკადში აგზავნის (მაგ. Kraken), ახალი გამოგზავნოს; orderbook_manager მას
+	// სინქრონიზაციის დაკარგვისას იყენებს
+	controlResyncSubject = "control.ingestor.resync"
+)
+
+// ControlRequest არის subscribe/unsubscribe/resync მოთხოვნის სხეული; resync მხოლოდ სიმბოლოებს კითხულობს
+type ControlRequest struct {
+	Symbols   []string `json:"symbols"`
+	Intervals []string `json:"intervals"`
+}
+
+// ControlResponse აბრუნებს ოპერაციის შედეგს და აქტიური ნაკადების მიმდინარე ნაკრებს
+type ControlResponse struct {
+	OK        bool     `json:"ok"`
+	Error     string   `json:"error,omitempty"`
+	Symbols   []string `json:"symbols"`
+	Intervals []string `json:"intervals"`
+	Streams   []string `json:"streams"`
+}
+
+// normalize ამოწმებს და ასწორებს მოთხოვნის სიმბოლოებსა და ინტერვალებს
+func (r *ControlRequest) normalize() error {
+	for i, symbol := range r.Symbols {
+		symbol = strings.ToLower(strings.TrimSpace(symbol))
+		if symbol == "" || strings.IndexFunc(symbol, func(c rune) bool { return (c < 'a' || c > 'z') && (c < '0' || c > '9') }) >= 0 {
+			return fmt.Errorf("invalid symbol %q", r.Symbols[i])
+		}
+		r.Symbols[i] = symbol
+	}
+	for _, interval := range r.Intervals {
+		if !slices.Contains(config.ValidKlineIntervals, interval) {
+			return fmt.Errorf("invalid kline interval %q", interval)
+		}
+	}
+	if len(r.Symbols) == 0 && len(r.Intervals) == 0 {
+		return fmt.Errorf("request must contain at least one symbol or interval")
+	}
+	return nil
+}
+
+// registerControlHandlers პასუხობს control.ingestor.* მოთხოვნებს handleControl-ის შედეგით
+func registerControlHandlers(nc *nats.Conn, stream *marketStream) error {
+	for _, subject := range []string{controlSubscribeSubject, controlUnsubscribeSubject, controlStreamsSubject, controlResyncSubject} {
+		if _, err := nc.Subscribe(subject, func(msg *nats.Msg) {
+			data, err := json.Marshal(handleControl(stream, msg.Subject, msg.Data))
+			if err != nil {
+				log.Printf("Error marshaling control response: %v", err)
+				return
+			}
+			if err := msg.Respond(data); err != nil {
+				log.Printf("Error responding to control request on %s: %v", msg.Subject, err)
+			}
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleControl ასრულებს ერთ control მოთხოვნას და აბრუნებს პასუხს ნაკადების ახალი ნაკრებით.
+// control.ingestor.streams მოთხოვნის სხეულს არ კითხულობს და მხოლოდ მიმდინარე ნაკრებს აბრუნებს.
+func handleControl(stream *marketStream, subject string, data []byte) ControlResponse {
+	if subject == controlStreamsSubject {
+		return controlResponse(stream, nil)
+	}
+
+	var req ControlRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return controlResponse(stream, fmt.Errorf("invalid request: %w", err))
+	}
+	if err := req.normalize(); err != nil {
+		return controlResponse(stream, err)
+	}
+
+	var err error
+	switch subject {
+	case controlSubscribeSubject:
+		log.Printf("Control: subscribe symbols=%v intervals=%v", req.Symbols, req.Intervals)
+		err = stream.subscribe(req.Symbols, req.Intervals)
+	case controlUnsubscribeSubject:
+		log.Printf("Control: unsubscribe symbols=%v intervals=%v", req.Symbols, req.Intervals)
+		err = stream.unsubscribe(req.Symbols, req.Intervals)
+	case controlResyncSubject:
+		if len(req.Symbols) == 0 {
+			err = fmt.Errorf("resync request must contain at least one symbol")
+			break
+		}
+		log.Printf("Control: resync order books of %v", req.Symbols)
+		specs := make([]streamSpec, len(req.Symbols))
+		for i, symbol := range req.Symbols {
+			specs[i] = depthStream(symbol)
+		}
+		err = stream.resubscribe(specs)
+	default:
+		err = fmt.Errorf("unknown control subject %q", subject)
+	}
+	if err != nil {
+		log.Printf("Control request on %s failed: %v", subject, err)
+	}
+	return controlResponse(stream, err)
+}
+
+func controlResponse(stream *marketStream, opErr error) ControlResponse {
+	symbols, intervals, streams := stream.state()
+	resp := ControlResponse{OK: opErr == nil, Symbols: symbols, Intervals: intervals, Streams: streams}
+	if opErr != nil {
+		resp.Error = opErr.Error()
+	}
+	return resp
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"rdr/common/codec"
+)
+
+func TestHandleControl(t *testing.T) {
+	// კავშირის გარეშე ნაკადი მხოლოდ ნაკრებს ცვლის; ბირჟას ის შემდეგი დაკავშირებისას მიიღებს
+	stream := newMarketStream(&recordingPublisher{}, nil, codec.JSON, newBinanceExchange(""), []string{"btcusdt"}, []string{"1m"})
+	btc := []string{"trade:btcusdt", "kline:btcusdt:1m", "depth:btcusdt"}
+	eth := []string{"trade:ethusdt", "kline:ethusdt:1m", "depth:ethusdt"}
+
+	tests := []struct {
+		name    string
+		subject string
+		body    string
+		err     string
+		symbols []string
+		streams []string
+	}{
+		{"streams", controlStreamsSubject, "", "", []string{"btcusdt"}, btc},
+		{"subscribe", controlSubscribeSubject, `{"symbols":[" ETHUSDT "]}`, "", []string{"btcusdt", "ethusdt"}, slices.Concat(btc, eth)},
+		{"duplicate subscribe", controlSubscribeSubject, `{"symbols":["ethusdt"]}`, "", []string{"btcusdt", "ethusdt"}, slices.Concat(btc, eth)},
+		{"unsubscribe unknown symbol", controlUnsubscribeSubject, `{"symbols":["solusdt"]}`, "", []string{"btcusdt", "ethusdt"}, slices.Concat(btc, eth)},
+		{"unsubscribe", controlUnsubscribeSubject, `{"symbols":["btcusdt"]}`, "", []string{"ethusdt"}, eth},
+		{"resync", controlResyncSubject, `{"symbols":["ETHUSDT","btcusdt"]}`, "", []string{"ethusdt"}, eth},
+		{"resync without symbols", controlResyncSubject, `{"intervals":["1m"]}`, "at least one symbol", []string{"ethusdt"}, eth},
+		{"invalid symbol", controlSubscribeSubject, `{"symbols":["btc-usdt"]}`, `invalid symbol "btc-usdt"`, []string{"ethusdt"}, eth},
+		{"invalid interval", controlSubscribeSubject, `{"intervals":["2m"]}`, `invalid kline interval "2m"`, []string{"ethusdt"}, eth},
+		{"empty request", controlUnsubscribeSubject, `{}`, "at least one symbol or interval", []string{"ethusdt"}, eth},
+		{"malformed request", controlSubscribeSubject, `{"symbols":`, "invalid request", []string{"ethusdt"}, eth},
+	}
+	for _, tt := range tests {
+		resp := handleControl(stream, tt.subject, []byte(tt.body))
+		if resp.OK != (tt.err == "") || !strings.Contains(resp.Error, tt.err) {
+			t.Errorf("%s: ok=%v error=%q, want error %q", tt.name, resp.OK, resp.Error, tt.err)
+		}
+		if !slices.Equal(resp.Symbols, tt.symbols) || !slices.Equal(resp.Intervals, []string{"1m"}) || !slices.Equal(resp.Streams, tt.streams) {
+			t.Errorf("%s: replied symbols=%v intervals=%v streams=%v, want %v %v", tt.name, resp.Symbols, resp.Intervals, resp.Streams, tt.symbols, tt.streams)
+		}
+	}
+}
+
+// controlVenue პასუხობს SUBSCRIBE/UNSUBSCRIBE მოთხოვნებს; reject=true-ზე მათ უარყოფს
+type controlVenue struct {
+	mu       sync.Mutex
+	reject   bool
+	requests [][]string
+}
+
+func (v *controlVenue) serve(r *http.Request, conn *websocket.Conn) {
+	for {
+		var req struct {
+			Method string   `json:"method"`
+			Params []string `json:"params"`
+			ID     int64    `json:"id"`
+		}
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		v.mu.Lock()
+		v.requests = append(v.requests, append([]string{req.Method}, req.Params...))
+		reject := v.reject
+		v.mu.Unlock()
+		reply := map[string]any{"result": nil, "id": req.ID}
+		if reject {
+			reply = map[string]any{"error": map[string]any{"code": 2, "msg": "Invalid request"}, "id": req.ID}
+		}
+		conn.WriteJSON(reply)
+	}
+}
+
+// waitConnected ელოდება, სანამ ნაკადი ბირჟას დაუკავშირდება
+func waitConnected(t *testing.T, stream *marketStream) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stream.mu.Lock()
+		connected := stream.conn != nil
+		stream.mu.Unlock()
+		if connected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stream did not connect")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHandleControlOnLiveConnection(t *testing.T) {
+	venue := &controlVenue{}
+	stream := runStream(t, fakeVenue(t, venue.serve), &recordingPublisher{})
+	waitConnected(t, stream)
+
+	// ბირჟა subscribe-ს ადასტურებს: ახალი ნაკადები ერთი მოთხოვნით ემატება
+	if resp := handleControl(stream, controlSubscribeSubject, []byte(`{"symbols":["ethusdt"]}`)); !resp.OK {
+		t.Fatalf("subscribe failed: %s", resp.Error)
+	}
+	// resync ხსნის და თავიდან იწერს მხოლოდ depth ნაკადს
+	if resp := handleControl(stream, controlResyncSubject, []byte(`{"symbols":["ethusdt"]}`)); !resp.OK {
+		t.Fatalf("resync failed: %s", resp.Error)
+	}
+	// ბირჟა უარყოფს: პასუხი შეცდომას აბრუნებს და ნაკრები წინა მდგომარეობაში რჩება
+	venue.mu.Lock()
+	venue.reject = true
+	venue.mu.Unlock()
+	resp := handleControl(stream, controlSubscribeSubject, []byte(`{"symbols":["solusdt"]}`))
+	if resp.OK || !strings.Contains(resp.Error, "rejected by Binance: Invalid request") {
+		t.Errorf("rejected subscribe: ok=%v error=%q", resp.OK, resp.Error)
+	}
+	if !slices.Equal(resp.Symbols, []string{"btcusdt", "ethusdt"}) {
+		t.Errorf("symbols after a rejected subscribe = %v, want btcusdt and ethusdt", resp.Symbols)
+	}
+
+	venue.mu.Lock()
+	defer venue.mu.Unlock()
+	want := [][]string{
+		{"SUBSCRIBE", "ethusdt@trade", "ethusdt@kline_1m", "ethusdt@depth"},
+		{"UNSUBSCRIBE", "ethusdt@depth"},
+		{"SUBSCRIBE", "ethusdt@depth"},
+		{"SUBSCRIBE", "solusdt@trade", "solusdt@kline_1m", "solusdt@depth"},
+	}
+	if !slices.EqualFunc(venue.requests, want, slices.Equal) {
+		t.Errorf("venue received %v, want %v", venue.requests, want)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+
+	"rdr/common/config"
+)
+
+// Exchange არის ბირჟის ადაპტერი: იცის, როგორ დაუკავშირდეს, როგორ გამოიწეროს ნაკადები
+// და როგორ გარდაქმნას ბირჟის შეტყობინებები საერთო marketdata ფორმატში
+type Exchange interface {
+	Name() string
+
+	// StreamURL აბრუნებს დაკავშირების მისამართს და იმ ნაკადებს, რომლებიც უკვე URL-შია გამოწერილი.
+	// დანარჩენი ნაკადები დაკავშირების შემდეგ Subscribe მოთხოვნით ემატება.
+	StreamURL(specs []streamSpec) (string, []streamSpec)
+
+	// ControlMessages აგებს subscribe/unsubscribe მოთხოვნებს; თითოეულ მოთხოვნას საკუთარი id აქვს,
+	// რომლითაც Decode-ის მიერ დაბრუნებული პასუხი უკავშირდება მას
+	ControlMessages(op controlOp, specs []streamSpec, nextID func() int64) ([]controlMessage, error)
+
+	// Decode შლის ერთ websocket შეტყობინებას: ბაზრის მონაცემებად ან მოთხოვნის პასუხად
+	Decode(message []byte) (decodedMessage, error)
+}
+
+type controlOp int
+
+const (
+	opSubscribe controlOp = iota
+	opUnsubscribe
+)
+
+func (op controlOp) String() string {
+	if op == opUnsubscribe {
+		return "UNSUBSCRIBE"
+	}
+	return "SUBSCRIBE"
+}
+
+// controlMessage არის ბირჟისთვის გასაგზავნი მოთხოვნა (JSON-ად სერიალიზდება)
+type controlMessage struct {
+	ID      int64
+	Payload any
+}
+
+// controlReply არის ბირჟის პასუხი controlMessage-ზე
+type controlReply struct {
+	ID  int64
+	Err error
+}
+
+// marketEvent არის ერთი ნაკადის ერთი შეტყობინება, უკვე საერთო (marketdata) ფორმატში,
+// და NATS-ის თემა, რომელზეც ის უნდა გამოქვეყნდეს. სერიალიზაცია (JSON/MessagePack)
+// გამოქვეყნებისას ხდება კონფიგურაციაში არჩეული codec-ით.
+type marketEvent struct {
+	Key     string
+	Subject string
+	Payload any
+}
+
+func newMarketEvent(key string, subject string, msg any) marketEvent {
+	return marketEvent{Key: key, Subject: subject, Payload: msg}
+}
+
+// decodedMessage არის Decode-ის შედეგი. ცარიელი შედეგი (მაგ. heartbeat) უბრალოდ გამოიტოვება.
+type decodedMessage struct {
+	Events []marketEvent
+	Reply  *controlReply
+	// Resync არის ნაკადები, რომელთა მდგომარეობაც ბირჟასთან დაიკარგა (მაგ. Kraken-ის წიგნის
+	// checksum არ დაემთხვა); ისინი ხელახლა გამოიწერება, რომ ბირჟამ ახალი snapshot გამოგზავნოს
+	Resync []streamSpec
+}
+
+// newExchange ქმნის კონფიგურაციაში მითითებულ ბირჟის ადაპტერს
+func newExchange(cfg config.Config) (Exchange, error) {
+	switch cfg.Ingestor.Exchange {
+	case "binance":
+		return newBinanceExchange(cfg.Binance.StreamURL), nil
+	case "kraken":
+		return newKrakenExchange(cfg.Kraken.StreamURL), nil
+	default:
+		return nil, fmt.Errorf("unsupported exchange %q", cfg.Ingestor.Exchange)
+	}
+}
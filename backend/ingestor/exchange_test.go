@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fixture კითხულობს ბირჟიდან ჩაწერილ ერთ websocket შეტყობინებას testdata-დან
+func fixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// fakeVenue უშვებს websocket სერვერს, რომელიც ბირჟის როლს ასრულებს, და აბრუნებს მის ws:// მისამართს
+func fakeVenue(t *testing.T, serve func(r *http.Request, conn *websocket.Conn)) string {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		serve(r, conn)
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// dialVenue უკავშირდება url-ს და subscribe მოთხოვნით ამატებს specs ნაკადებს
+func dialVenue(t *testing.T, exchange Exchange, url string, specs []streamSpec) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if len(specs) == 0 {
+		return conn
+	}
+	var id int64
+	messages, err := exchange.ControlMessages(opSubscribe, specs, func() int64 { id++; return id })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range messages {
+		if err := conn.WriteJSON(msg.Payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return conn
+}
+
+// decodeNext კითხულობს n შეტყობინებას და თითოეულს ადაპტერის Decode-ით შლის
+func decodeNext(t *testing.T, exchange Exchange, conn *websocket.Conn, n int) []decodedMessage {
+	t.Helper()
+	out := make([]decodedMessage, n)
+	for i := range out {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("reading message %d: %v", i, err)
+		}
+		if out[i], err = exchange.Decode(message); err != nil {
+			t.Fatalf("decoding %s: %v", message, err)
+		}
+	}
+	return out
+}
+
+// singleEvent აბრუნებს შეტყობინების ერთადერთ მოვლენას
+func singleEvent(t *testing.T, decoded decodedMessage) marketEvent {
+	t.Helper()
+	if len(decoded.Events) != 1 {
+		t.Fatalf("got %d events, want 1", len(decoded.Events))
+	}
+	return decoded.Events[0]
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"rdr/common/decimal"
+	"rdr/common/marketdata"
+)
+
+// krakenExchange იყენებს Kraken-ის WebSocket API v2-ს (wss://ws.kraken.com/v2).
+// Kraken-ს URL-ით გამოწერა არ აქვს, ამიტომ ყველა ნაკადი დაკავშირების შემდეგ subscribe მეთოდით ემატება.
+// Kraken-ის book არხი ჯერ სრულ snapshot-ს აგზავნის, შემდეგ კი ცვლილებებს.
+type krakenExchange struct {
+	baseURL string
+
+	// Kraken-ის book არხს update id არ აქვს, ამიტომ თითოეულ სიმბოლოზე საკუთარ მიმდევრობას ვითვლით
+	mu       sync.Mutex
+	depthSeq map[string]int64
+	// წიგნის ლოკალური ასლი checksum-ის შესამოწმებლად და krakenBookDepth-ზე მოსაჭრელად;
+	// სიმბოლო აქ არ არის, სანამ მისი snapshot არ მოსულა
+	books map[string]*krakenLocalBook
+	// ბოლო ღია სანთელი თითოეულ სიმბოლო+ინტერვალზე; ახალი interval_begin-ისას ის დახურულად ხელახლა ქვეყნდება
+	candles map[string]marketdata.Kline
+}
+
+// Kraken-ის book არხის სიღრმე (დასაშვებია 10, 25, 100, 500, 1000)
+const krakenBookDepth = 1000
+
+// Kraken-ის OHLC ინტერვალები წუთებში
+var krakenIntervals = map[string]int{
+	"1m": 1, "5m": 5, "15m": 15, "30m": 30, "1h": 60, "4h": 240, "1d": 1440, "1w": 10080,
+}
+
+// quote ვალუტები, რომლებითაც სიმბოლოს (მაგ. btcusdt) BTC/USDT ფორმატად ვყოფთ; გრძელი სუფიქსები პირველია
+var krakenQuoteAssets = []string{"USDT", "USDC", "USD", "EUR", "GBP", "BTC", "ETH"}
+
+func newKrakenExchange(baseURL string) *krakenExchange {
+	return &krakenExchange{baseURL: baseURL, depthSeq: make(map[string]int64), books: make(map[string]*krakenLocalBook), candles: make(map[string]marketdata.Kline)}
+}
+
+func (k *krakenExchange) Name() string { return "kraken" }
+
+// krakenPair გარდაქმნის btcusdt-ს BTC/USDT-ად
+func krakenPair(symbol string) (string, error) {
+	upper := strings.ToUpper(symbol)
+	for _, quote := range krakenQuoteAssets {
+		if base, ok := strings.CutSuffix(upper, quote); ok && base != "" {
+			return base + "/" + quote, nil
+		}
+	}
+	return "", fmt.Errorf("cannot map symbol %q to a Kraken pair", symbol)
+}
+
+// krakenSymbol გარდაქმნის BTC/USDT-ს btcusdt-ად
+func krakenSymbol(pair string) string {
+	return strings.ToLower(strings.ReplaceAll(pair, "/", ""))
+}
+
+func (k *krakenExchange) StreamURL(specs []streamSpec) (string, []streamSpec) {
+	return k.baseURL, nil
+}
+
+func (k *krakenExchange) ControlMessages(op controlOp, specs []streamSpec, nextID func() int64) ([]controlMessage, error) {
+	method := "subscribe"
+	if op == opUnsubscribe {
+		method = "unsubscribe"
+	}
+
+	// Kraken-ში ერთი მოთხოვნა = ერთი არხი (და ohlc-სთვის ერთი ინტერვალი) ბევრი სიმბოლოთი
+	type channelKey struct {
+		channel  string
+		interval string
+	}
+	var order []channelKey
+	groups := make(map[channelKey][]string)
+	for _, spec := range specs {
+		pair, err := krakenPair(spec.Symbol)
+		if err != nil {
+			return nil, err
+		}
+		var key channelKey
+		switch spec.Kind {
+		case "trade":
+			key = channelKey{channel: "trade"}
+		case "kline":
+			if _, ok := krakenIntervals[spec.Interval]; !ok {
+				return nil, fmt.Errorf("kline interval %q is not supported by Kraken", spec.Interval)
+			}
+			key = channelKey{channel: "ohlc", interval: spec.Interval}
+		default:
+			key = channelKey{channel: "book"}
+		}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], pair)
+	}
+
+	messages := make([]controlMessage, 0, len(order))
+	for _, key := range order {
+		params := map[string]any{"channel": key.channel, "symbol": groups[key]}
+		switch key.channel {
+		case "ohlc":
+			params["interval"] = krakenIntervals[key.interval]
+		case "book":
+			params["depth"] = krakenBookDepth
+		}
+		id := nextID()
+		messages = append(messages, controlMessage{ID: id, Payload: map[string]any{"method": method, "params": params, "req_id": id}})
+	}
+	return messages, nil
+}
+
+// --- Kraken-ის შეტყობინებები ---
+type krakenMessage struct {
+	Channel string          `json:"channel"`
+	Type    string          `json:"type"`
+	Data    json.RawMessage `json:"data"`
+
+	// მეთოდის პასუხის ველები
+	Method  string `json:"method"`
+	ReqID   *int64 `json:"req_id"`
+	Success bool   `json:"success"`
+	Error   string `json:"error"`
+}
+
+type krakenTrade struct {
+	Symbol    string      `json:"symbol"`
+	Side      string      `json:"side"`
+	Price     json.Number `json:"price"`
+	Qty       json.Number `json:"qty"`
+	TradeID   int64       `json:"trade_id"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+type krakenOHLC struct {
+	Symbol        string      `json:"symbol"`
+	Open          json.Number `json:"open"`
+	High          json.Number `json:"high"`
+	Low           json.Number `json:"low"`
+	Close         json.Number `json:"close"`
+	Volume        json.Number `json:"volume"`
+	Trades        int64       `json:"trades"`
+	IntervalBegin time.Time   `json:"interval_begin"`
+	Interval      int         `json:"interval"`
+	Timestamp     time.Time   `json:"timestamp"`
+}
+
+type krakenBookLevel struct {
+	Price json.Number `json:"price"`
+	Qty   json.Number `json:"qty"`
+}
+
+type krakenBook struct {
+	Symbol    string            `json:"symbol"`
+	Bids      []krakenBookLevel `json:"bids"`
+	Asks      []krakenBookLevel `json:"asks"`
+	Checksum  uint32            `json:"checksum"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+func (k *krakenExchange) Decode(message []byte) (decodedMessage, error) {
+	var msg krakenMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return decodedMessage{}, err
+	}
+
+	if msg.Method != "" {
+		if msg.ReqID == nil {
+			// მაგ. pong
+			return decodedMessage{}, nil
+		}
+		reply := &controlReply{ID: *msg.ReqID}
+		if !msg.Success {
+			reply.Err = fmt.Errorf("rejected by Kraken: %s", msg.Error)
+		}
+		return decodedMessage{Reply: reply}, nil
+	}
+
+	switch msg.Channel {
+	case "trade":
+		return k.decodeTrades(msg.Data)
+	case "ohlc":
+		return k.decodeOHLC(msg.Data)
+	case "book":
+		return k.decodeBook(msg.Type, msg.Data)
+	default:
+		// heartbeat, status და სხვა სამსახურებრივი არხები
+		return decodedMessage{}, nil
+	}
+}
+
+func (k *krakenExchange) decodeTrades(data json.RawMessage) (decodedMessage, error) {
+	var trades []krakenTrade
+	if err := json.Unmarshal(data, &trades); err != nil {
+		return decodedMessage{}, err
+	}
+	var out decodedMessage
+	for _, t := range trades {
+		symbol := krakenSymbol(t.Symbol)
+		upper := strings.ToUpper(symbol)
+		out.Events = append(out.Events, newMarketEvent(streamKey("trade", symbol, ""), marketdata.TradeSubject(k.Name(), upper), marketdata.Trade{
+			Version:  marketdata.SchemaVersion,
+			Exchange: k.Name(),
+			Symbol:   upper,
+			TradeID:  t.TradeID,
+			Price:    t.Price.String(),
+			Quantity: t.Qty.String(),
+			Side:     marketdata.Side(t.Side),
+			Time:     t.Timestamp.UnixMilli(),
+		}))
+	}
+	return out, nil
+}
+
+func (k *krakenExchange) decodeOHLC(data json.RawMessage) (decodedMessage, error) {
+	var candles []krakenOHLC
+	if err := json.Unmarshal(data, &candles); err != nil {
+		return decodedMessage{}, err
+	}
+	var out decodedMessage
+	for _, c := range candles {
+		interval := ""
+		for name, minutes := range krakenIntervals {
+			if minutes == c.Interval {
+				interval = name
+			}
+		}
+		if interval == "" {
+			continue
+		}
+		symbol := krakenSymbol(c.Symbol)
+		upper := strings.ToUpper(symbol)
+		start := c.IntervalBegin.UnixMilli()
+		key := streamKey("kline", symbol, interval)
+		subject := marketdata.KlineSubject(k.Name(), upper, interval)
+		kline := marketdata.Kline{
+			Version:   marketdata.SchemaVersion,
+			Exchange:  k.Name(),
+			Symbol:    upper,
+			Interval:  interval,
+			OpenTime:  start,
+			CloseTime: start + int64(c.Interval)*time.Minute.Milliseconds() - 1,
+			Open:      c.Open.String(),
+			High:      c.High.String(),
+			Low:       c.Low.String(),
+			Close:     c.Close.String(),
+			Volume:    c.Volume.String(),
+			Trades:    c.Trades,
+			// Kraken სანთლის დახურვას ცალკე არ აცხადებს; ის ახალი interval_begin-ით იცვლება
+			Closed:    false,
+			EventTime: c.Timestamp.UnixMilli(),
+		}
+
+		k.mu.Lock()
+		prev, ok := k.candles[key]
+		if ok && prev.OpenTime > start {
+			// უკვე დახურული სანთელი (მაგ. ხელახალი მიერთების snapshot-იდან)
+			k.mu.Unlock()
+			continue
+		}
+		k.candles[key] = kline
+		k.mu.Unlock()
+		if ok && prev.OpenTime < start {
+			// წინა სანთელი დასრულდა: ბოლო მდგომარეობით, დახურულად და ახალი მოვლენის დროით
+			prev.Closed = true
+			prev.EventTime = kline.EventTime
+			out.Events = append(out.Events, newMarketEvent(key, subject, prev))
+		}
+		out.Events = append(out.Events, newMarketEvent(key, subject, kline))
+	}
+	return out, nil
+}
+
+// decodeBook: snapshot ქვეყნდება BookSnapshot-ად, update კი DepthDelta-დ ლოკალური მიმდევრობის ნომრით.
+// ყოველი შეტყობინება ლოკალურ წიგნზე დაიდება და მოწმდება მისი checksum. Kraken წიგნს
+// krakenBookDepth დონეზე ჭრის და ფანჯრიდან გასულ დონეებს არ აცხადებს, ამიტომ მოჭრილი დონეები
+// delta-ს ნულოვანი რაოდენობით ემატება. checksum-ის შეუსაბამობისას სიმბოლო Resync-ში ბრუნდება
+// და მისი განახლებები ახალ snapshot-მდე გამოიტოვება.
+func (k *krakenExchange) decodeBook(kind string, data json.RawMessage) (decodedMessage, error) {
+	var books []krakenBook
+	if err := json.Unmarshal(data, &books); err != nil {
+		return decodedMessage{}, err
+	}
+	var out decodedMessage
+	for _, b := range books {
+		symbol := krakenSymbol(b.Symbol)
+		upper := strings.ToUpper(symbol)
+		key := streamKey("depth", symbol, "")
+
+		k.mu.Lock()
+		book, synced := k.books[symbol]
+		if kind == "snapshot" {
+			book = &krakenLocalBook{bids: krakenSide{bids: true}}
+			k.books[symbol] = book
+			k.depthSeq[symbol] = 0
+		} else if !synced {
+			// ხელახალი გამოწერის snapshot ჯერ არ მოსულა
+			k.mu.Unlock()
+			continue
+		}
+		removedBids, removedAsks, err := book.apply(b.Bids, b.Asks)
+		if err == nil && book.checksum() != b.Checksum {
+			err = fmt.Errorf("checksum %d, want %d", book.checksum(), b.Checksum)
+		}
+		if err != nil {
+			delete(k.books, symbol)
+			k.mu.Unlock()
+			log.Printf("Kraken book for %s is out of sync (%v). Resubscribing...", upper, err)
+			out.Resync = append(out.Resync, depthStream(symbol))
+			continue
+		}
+		k.depthSeq[symbol]++
+		seq := k.depthSeq[symbol]
+		k.mu.Unlock()
+
+		eventTime := b.Timestamp.UnixMilli()
+		if b.Timestamp.IsZero() {
+			eventTime = time.Now().UnixMilli()
+		}
+
+		var event marketEvent
+		if kind == "snapshot" {
+			event = newMarketEvent(key, marketdata.BookSnapshotSubject(k.Name(), upper), marketdata.BookSnapshot{
+				Version:      marketdata.SchemaVersion,
+				Exchange:     k.Name(),
+				Symbol:       upper,
+				LastUpdateID: seq,
+				Bids:         book.bids.marketLevels(),
+				Asks:         book.asks.marketLevels(),
+				Time:         eventTime,
+			})
+		} else {
+			event = newMarketEvent(key, marketdata.DepthSubject(k.Name(), upper), marketdata.DepthDelta{
+				Version:       marketdata.SchemaVersion,
+				Exchange:      k.Name(),
+				Symbol:        upper,
+				FirstUpdateID: seq,
+				FinalUpdateID: seq,
+				Bids:          append(krakenLevels(b.Bids), removedBids...),
+				Asks:          append(krakenLevels(b.Asks), removedAsks...),
+				Time:          eventTime,
+			})
+		}
+		out.Events = append(out.Events, event)
+	}
+	return out, nil
+}
+
+func krakenLevels(levels []krakenBookLevel) []marketdata.Level {
+	out := make([]marketdata.Level, len(levels))
+	for i, l := range levels {
+		out[i] = marketdata.Level{l.Price.String(), l.Qty.String()}
+	}
+	return out
+}
+
+// --- Kraken-ის წიგნის ლოკალური ასლი ---
+
+// krakenChecksumLevels არის დონეები თითო მხარეს, რომლებსაც Kraken-ის checksum მოიცავს
+const krakenChecksumLevels = 10
+
+// krakenLocalLevel ინახავს Kraken-ის მიერ გამოგზავნილ ტექსტს, რადგან checksum მას ეყრდნობა
+type krakenLocalLevel struct {
+	price decimal.Decimal
+	level krakenBookLevel
+}
+
+// krakenSide არის წიგნის ერთი მხარე, საუკეთესო ფასით დაწყებული
+type krakenSide struct {
+	bids   bool
+	levels []krakenLocalLevel
+}
+
+func (s *krakenSide) search(price decimal.Decimal) (int, bool) {
+	return slices.BinarySearchFunc(s.levels, price, func(l krakenLocalLevel, p decimal.Decimal) int {
+		if s.bids {
+			return cmp.Compare(p, l.price)
+		}
+		return cmp.Compare(l.price, p)
+	})
+}
+
+// update ცვლის, ამატებს ან (ნულოვანი რაოდენობისას) შლის დონეს
+func (s *krakenSide) update(l krakenBookLevel) error {
+	price, err := decimal.Parse(l.Price.String())
+	if err != nil {
+		return err
+	}
+	qty, err := decimal.Parse(l.Qty.String())
+	if err != nil {
+		return err
+	}
+	i, found := s.search(price)
+	switch {
+	case qty.IsZero() && found:
+		s.levels = slices.Delete(s.levels, i, i+1)
+	case qty.IsZero():
+	case found:
+		s.levels[i].level = l
+	default:
+		s.levels = slices.Insert(s.levels, i, krakenLocalLevel{price: price, level: l})
+	}
+	return nil
+}
+
+// truncate აშორებს depth-ს მიღმა დარჩენილ დონეებს და აბრუნებს მათ წაშლის სახით
+func (s *krakenSide) truncate(depth int) []marketdata.Level {
+	if len(s.levels) <= depth {
+		return nil
+	}
+	removed := make([]marketdata.Level, 0, len(s.levels)-depth)
+	for _, l := range s.levels[depth:] {
+		removed = append(removed, marketdata.Level{l.level.Price.String(), "0"})
+	}
+	s.levels = s.levels[:depth]
+	return removed
+}
+
+func (s *krakenSide) marketLevels() []marketdata.Level {
+	out := make([]marketdata.Level, len(s.levels))
+	for i, l := range s.levels {
+		out[i] = marketdata.Level{l.level.Price.String(), l.level.Qty.String()}
+	}
+	return out
+}
+
+// writeChecksum წერს საუკეთესო krakenChecksumLevels დონეს: ფასი და რაოდენობა წერტილისა და
+// წინა ნულების გარეშე. Kraken რიცხვებს წყვილის სიზუსტით აგზავნის, ამიტომ მისი ტექსტი გამოიყენება
+func (s *krakenSide) writeChecksum(b *strings.Builder) {
+	for _, l := range s.levels[:min(krakenChecksumLevels, len(s.levels))] {
+		for _, v := range []json.Number{l.level.Price, l.level.Qty} {
+			b.WriteString(strings.TrimLeft(strings.ReplaceAll(v.String(), ".", ""), "0"))
+		}
+	}
+}
+
+type krakenLocalBook struct {
+	bids, asks krakenSide
+}
+
+// apply ადებს snapshot-ის ან update-ის დონეებს და ჭრის ორივე მხარეს krakenBookDepth-ზე
+func (b *krakenLocalBook) apply(bids, asks []krakenBookLevel) (removedBids, removedAsks []marketdata.Level, err error) {
+	for _, l := range bids {
+		if err := b.bids.update(l); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, l := range asks {
+		if err := b.asks.update(l); err != nil {
+			return nil, nil, err
+		}
+	}
+	return b.bids.truncate(krakenBookDepth), b.asks.truncate(krakenBookDepth), nil
+}
+
+// checksum არის CRC32 ask-ების (ზრდადობით), შემდეგ კი bid-ების (კლებადობით) საუკეთესო დონეებზე
+func (b *krakenLocalBook) checksum() uint32 {
+	var sb strings.Builder
+	b.asks.writeChecksum(&sb)
+	b.bids.writeChecksum(&sb)
+	return crc32.ChecksumIEEE([]byte(sb.String()))
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"rdr/common/codec"
+	"rdr/common/marketdata"
+)
+
+func krakenMillis(t *testing.T, s string) int64 {
+	t.Helper()
+	ts, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts.UnixMilli()
+}
+
+func TestKrakenStream(t *testing.T) {
+	url := fakeVenue(t, func(r *http.Request, conn *websocket.Conn) {
+		// ერთი მოთხოვნა თითო არხზე: trade, ohlc (1 წუთი) და book
+		want := []string{
+			`{"method":"subscribe","params":{"channel":"trade","symbol":["BTC/USDT"]},"req_id":1}`,
+			`{"method":"subscribe","params":{"channel":"ohlc","interval":1,"symbol":["BTC/USDT"]},"req_id":2}`,
+			`{"method":"subscribe","params":{"channel":"book","depth":1000,"symbol":["BTC/USDT"]},"req_id":3}`,
+		}
+		for i, w := range want {
+			_, req, err := conn.ReadMessage()
+			if err != nil {
+				t.Errorf("reading subscribe %d: %v", i, err)
+				return
+			}
+			if got := string(bytes.TrimSpace(req)); got != w {
+				t.Errorf("subscribe %d = %s, want %s", i, got, w)
+			}
+			reply := bytes.Replace(fixture(t, "kraken/subscribe_reply.json"), []byte(`"req_id":1`), fmt.Appendf(nil, `"req_id":%d`, i+1), 1)
+			conn.WriteMessage(websocket.TextMessage, reply)
+		}
+		for _, name := range []string{"heartbeat.json", "book_snapshot.json", "book_update.json", "trade.json", "ohlc.json", "ohlc_next.json"} {
+			conn.WriteMessage(websocket.TextMessage, fixture(t, "kraken/"+name))
+		}
+	})
+
+	// Kraken-ის URL-ში ნაკადები არ იწერება; ყველაფერი subscribe მოთხოვნით ემატება
+	exchange := newKrakenExchange(url)
+	specs := buildStreamSpecs([]string{"btcusdt"}, []string{"1m"})
+	streamURL, dialed := exchange.StreamURL(specs)
+	if len(dialed) != 0 {
+		t.Fatalf("StreamURL dialed %v, want none", dialed)
+	}
+	conn := dialVenue(t, exchange, streamURL, specs)
+	decoded := decodeNext(t, exchange, conn, 9)
+
+	for i, d := range decoded[:3] {
+		if d.Reply == nil || d.Reply.ID != int64(i+1) || d.Reply.Err != nil {
+			t.Fatalf("subscribe reply %d = %+v", i, d.Reply)
+		}
+	}
+	if heartbeat := decoded[3]; heartbeat.Reply != nil || len(heartbeat.Events) != 0 {
+		t.Errorf("heartbeat decoded to %+v, want nothing", heartbeat)
+	}
+
+	kline := marketdata.Kline{
+		Version: 1, Exchange: "kraken", Symbol: "BTCUSDT", Interval: "1m",
+		OpenTime:  krakenMillis(t, "2023-11-14T22:13:00Z"),
+		CloseTime: krakenMillis(t, "2023-11-14T22:13:59.999Z"),
+		Open:      "37001.1", High: "37015.0", Low: "36998.2", Close: "37012.3",
+		Volume: "1.28410218", Trades: 84, Closed: false,
+		EventTime: krakenMillis(t, "2023-11-14T22:13:59.9Z"),
+	}
+	tests := []struct {
+		key     string
+		subject string
+		payload any
+	}{
+		{"depth:btcusdt", "md.v1.book.kraken.BTCUSDT", marketdata.BookSnapshot{
+			Version: 1, Exchange: "kraken", Symbol: "BTCUSDT", LastUpdateID: 1,
+			Bids: []marketdata.Level{{"37012.2", "0.8"}, {"37011.9", "1.5"}},
+			Asks: []marketdata.Level{{"37012.4", "0.3"}},
+			Time: krakenMillis(t, "2023-11-14T22:13:19.602101Z"),
+		}},
+		{"depth:btcusdt", "md.v1.depth.kraken.BTCUSDT", marketdata.DepthDelta{
+			Version: 1, Exchange: "kraken", Symbol: "BTCUSDT", FirstUpdateID: 2, FinalUpdateID: 2,
+			Bids: []marketdata.Level{{"37011.9", "0"}},
+			Asks: []marketdata.Level{{"37012.5", "2.1"}},
+			Time: krakenMillis(t, "2023-11-14T22:13:20.220518Z"),
+		}},
+		{"trade:btcusdt", "md.v1.trade.kraken.BTCUSDT", marketdata.Trade{
+			Version: 1, Exchange: "kraken", Symbol: "BTCUSDT", TradeID: 7418822,
+			Price: "37012.3", Quantity: "0.0015", Side: marketdata.Sell,
+			Time: krakenMillis(t, "2023-11-14T22:13:20.120511Z"),
+		}},
+		{"kline:btcusdt:1m", "md.v1.kline.kraken.BTCUSDT.1m", kline},
+	}
+	for i, tt := range tests {
+		event := singleEvent(t, decoded[i+4])
+		if event.Key != tt.key || event.Subject != tt.subject {
+			t.Errorf("event %d routed to %s on %s, want %s on %s", i, event.Key, event.Subject, tt.key, tt.subject)
+		}
+		if !reflect.DeepEqual(event.Payload, tt.payload) {
+			t.Errorf("event %d payload = %+v, want %+v", i, event.Payload, tt.payload)
+		}
+	}
+
+	// ახალი interval_begin წინა სანთელს ხურავს: ჯერ ის ქვეყნდება დახურულად, შემდეგ ახალი
+	next := decoded[8].Events
+	if len(next) != 2 {
+		t.Fatalf("new candle decoded to %d events, want the closed previous one and the new one", len(next))
+	}
+	closed := kline
+	closed.Closed = true
+	closed.EventTime = krakenMillis(t, "2023-11-14T22:14:00.41Z")
+	if !reflect.DeepEqual(next[0].Payload, closed) {
+		t.Errorf("closed candle = %+v, want %+v", next[0].Payload, closed)
+	}
+	if k := next[1].Payload.(marketdata.Kline); k.Closed || k.OpenTime != krakenMillis(t, "2023-11-14T22:14:00Z") {
+		t.Errorf("new candle = %+v, want the open 22:14 candle", k)
+	}
+
+	// უკვე დახურული სანთლის დაგვიანებული განახლება აღარ ქვეყნდება
+	stale, err := exchange.Decode(fixture(t, "kraken/ohlc.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale.Events) != 0 {
+		t.Errorf("stale candle decoded to %+v, want nothing", stale.Events)
+	}
+}
+
+func TestKrakenRejectedSubscribe(t *testing.T) {
+	decoded, err := newKrakenExchange("").Decode([]byte(`{"method":"subscribe","req_id":4,"success":false,"error":"Currency pair not supported","time_in":"2023-11-14T22:13:19.512343Z","time_out":"2023-11-14T22:13:19.512401Z"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Reply == nil || decoded.Reply.ID != 4 || decoded.Reply.Err == nil {
+		t.Errorf("reply = %+v, want an error for request 4", decoded.Reply)
+	}
+}
+
+// krakenBookMessage აგებს book შეტყობინებას BTC/USDT-ზე; დონეები ფასი/რაოდენობის წყვილებია
+func krakenBookMessage(kind string, bids, asks [][2]string, checksum uint32) []byte {
+	levels := func(side [][2]string) string {
+		parts := make([]string, len(side))
+		for i, l := range side {
+			parts[i] = fmt.Sprintf(`{"price":%s,"qty":%s}`, l[0], l[1])
+		}
+		return "[" + strings.Join(parts, ",") + "]"
+	}
+	return fmt.Appendf(nil, `{"channel":"book","type":%q,"data":[{"symbol":"BTC/USDT","bids":%s,"asks":%s,"checksum":%d,"timestamp":"2023-11-14T22:13:19.602101Z"}]}`, kind, levels(bids), levels(asks), checksum)
+}
+
+// krakenTestChecksum ითვლის checksum-ს Kraken-ის დოკუმენტაციის მიხედვით საუკეთესო დონეებიდან
+// (ask-ები ზრდადობით, bid-ები კლებადობით)
+func krakenTestChecksum(asks, bids [][2]string) uint32 {
+	var b strings.Builder
+	for _, side := range [][][2]string{asks, bids} {
+		for _, l := range side[:min(10, len(side))] {
+			for _, v := range l {
+				b.WriteString(strings.TrimLeft(strings.ReplaceAll(v, ".", ""), "0"))
+			}
+		}
+	}
+	return crc32.ChecksumIEEE([]byte(b.String()))
+}
+
+func TestKrakenBookChecksum(t *testing.T) {
+	// ფიქსჩერების checksum-ები: ჯერ ask-ები, შემდეგ bid-ები, წერტილისა და წინა ნულების გარეშე
+	snapshot := crc32.ChecksumIEEE([]byte("3701243" + "370122837011915"))
+	update := crc32.ChecksumIEEE([]byte("370124337012521" + "3701228"))
+	exchange := newKrakenExchange("")
+	for _, tt := range []struct {
+		fixture  string
+		checksum uint32
+	}{{"book_snapshot.json", snapshot}, {"book_update.json", update}} {
+		if !bytes.Contains(fixture(t, "kraken/"+tt.fixture), fmt.Appendf(nil, `"checksum":%d`, tt.checksum)) {
+			t.Fatalf("%s does not carry checksum %d", tt.fixture, tt.checksum)
+		}
+		decoded, err := exchange.Decode(fixture(t, "kraken/"+tt.fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(decoded.Events) != 1 || len(decoded.Resync) != 0 {
+			t.Errorf("%s decoded to %d events and resync %v, want one event", tt.fixture, len(decoded.Events), decoded.Resync)
+		}
+	}
+
+	// ფასისა და რაოდენობის წინა ნულები checksum-ში არ შედის
+	book := krakenBookMessage("snapshot", [][2]string{{"0.5666", "4831.75496356"}}, [][2]string{{"0.5667", "0.01000000"}}, crc32.ChecksumIEEE([]byte("56671000000"+"5666483175496356")))
+	if decoded, err := newKrakenExchange("").Decode(book); err != nil || len(decoded.Events) != 1 {
+		t.Errorf("snapshot with leading zeros decoded to %+v, %v", decoded, err)
+	}
+}
+
+func TestKrakenBookTruncatedToDepth(t *testing.T) {
+	var bids, asks [][2]string
+	for i := range krakenBookDepth {
+		bids = append(bids, [2]string{fmt.Sprint(30000 - i), "1.5"})
+		asks = append(asks, [2]string{fmt.Sprint(40000 + i), "2.5"})
+	}
+	exchange := newKrakenExchange("")
+	if _, err := exchange.Decode(krakenBookMessage("snapshot", bids, asks, krakenTestChecksum(asks, bids))); err != nil {
+		t.Fatal(err)
+	}
+
+	// ახალი საუკეთესო დონეები ფანჯრიდან გადის ყველაზე შორეულს
+	newBid, newAsk := [2]string{"30000.5", "0.7"}, [2]string{"39999.5", "0.9"}
+	bids = append([][2]string{newBid}, bids[:krakenBookDepth-1]...)
+	asks = append([][2]string{newAsk}, asks[:krakenBookDepth-1]...)
+	decoded, err := exchange.Decode(krakenBookMessage("update", [][2]string{newBid}, [][2]string{newAsk}, krakenTestChecksum(asks, bids)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	delta := singleEvent(t, decoded).Payload.(marketdata.DepthDelta)
+	wantBids := []marketdata.Level{{"30000.5", "0.7"}, {fmt.Sprint(30000 - krakenBookDepth + 1), "0"}}
+	wantAsks := []marketdata.Level{{"39999.5", "0.9"}, {fmt.Sprint(40000 + krakenBookDepth - 1), "0"}}
+	if !slices.Equal(delta.Bids, wantBids) || !slices.Equal(delta.Asks, wantAsks) {
+		t.Errorf("delta bids %v asks %v, want %v and %v", delta.Bids, delta.Asks, wantBids, wantAsks)
+	}
+
+	// მოჭრილი დონის წაშლა ფანჯრის გარეთ აღარაფერს ცვლის
+	decoded, err = exchange.Decode(krakenBookMessage("update", [][2]string{{fmt.Sprint(30000 - krakenBookDepth + 1), "0"}}, nil, krakenTestChecksum(asks, bids)))
+	if err != nil || len(decoded.Resync) != 0 {
+		t.Errorf("deleting a truncated level: resync %v, %v", decoded.Resync, err)
+	}
+}
+
+func TestKrakenBookChecksumMismatch(t *testing.T) {
+	bids, asks := [][2]string{{"37012.2", "0.8"}}, [][2]string{{"37012.4", "0.3"}}
+	exchange := newKrakenExchange("")
+	exchange.Decode(krakenBookMessage("snapshot", bids, asks, krakenTestChecksum(asks, bids)))
+
+	// ცვლილება, რომელიც checksum-ს არ ემთხვევა, არ ქვეყნდება და წიგნი ხელახლა გამოიწერება
+	decoded, err := exchange.Decode(krakenBookMessage("update", [][2]string{{"37012.1", "1"}}, nil, 12345))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Events) != 0 || !slices.Equal(decoded.Resync, []streamSpec{depthStream("btcusdt")}) {
+		t.Fatalf("mismatched update decoded to %+v, want no events and a depth resync", decoded)
+	}
+
+	// ახალ snapshot-მდე განახლებები გამოიტოვება
+	decoded, _ = exchange.Decode(krakenBookMessage("update", [][2]string{{"37012.0", "1"}}, nil, krakenTestChecksum(asks, [][2]string{{"37012.2", "0.8"}, {"37012.0", "1"}})))
+	if len(decoded.Events) != 0 || len(decoded.Resync) != 0 {
+		t.Errorf("update before the new snapshot decoded to %+v, want nothing", decoded)
+	}
+
+	decoded, _ = exchange.Decode(krakenBookMessage("snapshot", bids, asks, krakenTestChecksum(asks, bids)))
+	if snapshot := singleEvent(t, decoded).Payload.(marketdata.BookSnapshot); snapshot.LastUpdateID != 1 {
+		t.Errorf("new snapshot has LastUpdateID %d, want the sequence restarted at 1", snapshot.LastUpdateID)
+	}
+	decoded, _ = exchange.Decode(krakenBookMessage("update", [][2]string{{"37012.1", "1"}}, nil, krakenTestChecksum(asks, [][2]string{{"37012.2", "0.8"}, {"37012.1", "1"}})))
+	if delta := singleEvent(t, decoded).Payload.(marketdata.DepthDelta); delta.FirstUpdateID != 2 {
+		t.Errorf("update after the new snapshot has id %d, want 2", delta.FirstUpdateID)
+	}
+}
+
+// checksum-ის შეუსაბამობისას ნაკადი ბირჟაზე book არხს ხსნის და თავიდან იწერს
+func TestKrakenStreamResubscribesBook(t *testing.T) {
+	bids, asks := [][2]string{{"37012.2", "0.8"}}, [][2]string{{"37012.4", "0.3"}}
+	requests := make(chan string, 10)
+	url := fakeVenue(t, func(r *http.Request, conn *websocket.Conn) {
+		books := 0
+		for {
+			var req struct {
+				Method string         `json:"method"`
+				Params map[string]any `json:"params"`
+				ReqID  int64          `json:"req_id"`
+			}
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			if req.Params["channel"] == "book" {
+				requests <- req.Method
+			}
+			conn.WriteJSON(map[string]any{"method": req.Method, "req_id": req.ReqID, "success": true})
+			if req.Method == "subscribe" && req.Params["channel"] == "book" {
+				conn.WriteMessage(websocket.TextMessage, krakenBookMessage("snapshot", bids, asks, krakenTestChecksum(asks, bids)))
+				if books++; books == 1 {
+					// პირველი გამოწერის შემდეგ მოდის checksum-ით შეუსაბამო ცვლილება
+					conn.WriteMessage(websocket.TextMessage, krakenBookMessage("update", [][2]string{{"37012.1", "1"}}, nil, 1))
+				}
+			}
+		}
+	})
+	stream := newMarketStream(&recordingPublisher{}, nil, codec.JSON, newKrakenExchange(url), []string{"btcusdt"}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		stream.run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	want := []string{"subscribe", "unsubscribe", "subscribe"}
+	for i, w := range want {
+		select {
+		case got := <-requests:
+			if got != w {
+				t.Errorf("book request %d = %s, want %s", i, got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("venue received no book request %d (%s)", i, w)
+		}
+	}
+}
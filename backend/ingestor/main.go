@@ -1,134 +1,149 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"log"
-	"os"
-	"os/signal"
-	"syscall"
+	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/nats-io/nats.go"
-)
-
-// --- კონფიგურაცია ---
-// აქ ვწერთ, რომელი წყვილები და ინტერვალები გვაინტერესებს
-var symbols = []string{"btcusdt", "ethusdt", "solusdt"}
-var klineIntervals = []string{"1m", "5m"}
-
-// ფუნქცია ტრეიდების ნაკადთან დასაკავშირებლად
-func connectToTradeStream(nc *nats.Conn, symbol string) {
-	streamURL := fmt.Sprintf("wss://stream.binance.com:9443/ws/%s@trade", symbol)
-	log.Printf("Connecting to Trade Stream: %s", streamURL)
+	"github.com/nats-io/nats.go/jetstream"
 
-	c, _, err := websocket.DefaultDialer.Dial(streamURL, nil)
-	if err != nil {
-		log.Printf("Trade stream WebSocket dial error for %s: %v", streamURL, err)
-		return
-	}
-	defer c.Close()
-	log.Printf("✅ Successfully connected to Binance Trade stream for %s.", symbol)
+	"rdr/common/codec"
+	"rdr/common/config"
+	"rdr/common/lifecycle"
+	"rdr/common/mdstream"
+)
 
-	natsSubject := fmt.Sprintf("trades.%s", symbol)
+// დაუდასტურებელი JetStream შეტყობინებების ზღვარი; მის მიღწევისას გამოქვეყნება მოკლედ ჩერდება
+const jetstreamMaxPending = 4096
 
-	for {
-		_, message, err := c.ReadMessage()
-		if err != nil {
-			log.Printf("Trade stream read error for %s: %v", streamURL, err)
-			return
-		}
-		err = nc.Publish(natsSubject, message)
-		if err != nil {
-			log.Printf("Error publishing trade to NATS on subject %s: %v", natsSubject, err)
-		}
-	}
+// streamSpec აღწერს ერთ ნაკადს ბირჟისგან დამოუკიდებლად.
+// Symbol ყოველთვის Binance-ის სტილშია (მაგ. btcusdt); ბირჟის ადაპტერი თავად გარდაქმნის საკუთარ ფორმატში.
+type streamSpec struct {
+	Kind     string
+	Symbol   string
+	Interval string
 }
 
-// ფუნქცია სანთლების (K-Line) ნაკადთან დასაკავშირებლად
-func connectToKlineStream(nc *nats.Conn, symbol string, interval string) {
-	streamURL := fmt.Sprintf("wss://stream.binance.com:9443/ws/%s@kline_%s", symbol, interval)
-	log.Printf("Connecting to Kline Stream: %s", streamURL)
+// Key აბრუნებს ნაკადის უნიკალურ გასაღებს (მაგ. kline:btcusdt:1m)
+func (s streamSpec) Key() string {
+	return streamKey(s.Kind, s.Symbol, s.Interval)
+}
 
-	c, _, err := websocket.DefaultDialer.Dial(streamURL, nil)
-	if err != nil {
-		log.Printf("Kline stream WebSocket dial error for %s: %v", streamURL, err)
-		return
+// Name აბრუნებს ნაკადის მოკლე სახელს ლოგებისთვის (მაგ. btcusdt ან btcusdt/1m)
+func (s streamSpec) Name() string {
+	if s.Interval != "" {
+		return s.Symbol + "/" + s.Interval
 	}
-	defer c.Close()
-	log.Printf("✅ Successfully connected to Binance Kline stream for %s/%s.", symbol, interval)
-
-	natsSubject := fmt.Sprintf("klines.%s.%s", symbol, interval)
+	return s.Symbol
+}
 
-	for {
-		_, message, err := c.ReadMessage()
-		if err != nil {
-			log.Printf("Kline stream read error for %s: %v", streamURL, err)
-			return
-		}
-		err = nc.Publish(natsSubject, message)
-		if err != nil {
-			log.Printf("Error publishing kline to NATS on subject %s: %v", natsSubject, err)
-		}
+func streamKey(kind, symbol, interval string) string {
+	if interval != "" {
+		return kind + ":" + symbol + ":" + interval
 	}
+	return kind + ":" + symbol
 }
 
-// ახალი ფუნქცია Order Book-ის (Depth) ნაკადთან დასაკავშირებლად
-func connectToDepthStream(nc *nats.Conn, symbol string) {
-	streamURL := fmt.Sprintf("wss://stream.binance.com:9443/ws/%s@depth", symbol)
-	log.Printf("Connecting to Depth Stream: %s", streamURL)
+// ტრეიდების ნაკადი
+func tradeStream(symbol string) streamSpec {
+	return streamSpec{Kind: "trade", Symbol: symbol}
+}
 
-	c, _, err := websocket.DefaultDialer.Dial(streamURL, nil)
-	if err != nil {
-		log.Printf("Depth stream WebSocket dial error for %s: %v", streamURL, err)
-		return
-	}
-	defer c.Close()
-	log.Printf("✅ Successfully connected to Binance Depth stream for %s.", symbol)
+// სანთლების (K-Line) ნაკადი
+func klineStream(symbol string, interval string) streamSpec {
+	return streamSpec{Kind: "kline", Symbol: symbol, Interval: interval}
+}
 
-	natsSubject := fmt.Sprintf("depth.%s", symbol)
+// Order Book-ის (Depth) ნაკადი
+func depthStream(symbol string) streamSpec {
+	return streamSpec{Kind: "depth", Symbol: symbol}
+}
 
-	for {
-		_, message, err := c.ReadMessage()
-		if err != nil {
-			log.Printf("Depth stream read error for %s: %v", streamURL, err)
-			return
-		}
-		// ვაქვეყნებთ Order Book-ის განახლებას NATS-ის შესაბამის თემაზე
-		err = nc.Publish(natsSubject, message)
-		if err != nil {
-			log.Printf("Error publishing depth to NATS on subject %s: %v", natsSubject, err)
+// buildStreamSpecs აგებს ნაკადების სიას სიმბოლოებისა და ინტერვალების მიხედვით
+func buildStreamSpecs(symbols []string, intervals []string) []streamSpec {
+	var specs []streamSpec
+	for _, symbol := range symbols {
+		specs = append(specs, tradeStream(symbol))
+		for _, interval := range intervals {
+			specs = append(specs, klineStream(symbol, interval))
 		}
+		specs = append(specs, depthStream(symbol))
 	}
+	return specs
 }
 
-
 func main() {
-	natsURL := "nats://nats:4222"
+	// SIGINT/SIGTERM აუქმებს ctx-ს; გაჩერება იწყება ქვემოთ, <-ctx.Done()-ის შემდეგ
+	ctx, stop := lifecycle.Context()
+	defer stop()
+
+	// კონფიგურაცია საერთოა ყველა სერვისისთვის (config.yaml + RDR_* გარემოს ცვლადები)
+	cfg := config.MustLoad()
+	log.Printf("Market universe: symbols=%v intervals=%v (fingerprint %s)", cfg.Market.Symbols, cfg.Market.KlineIntervals, cfg.Market.Fingerprint())
+
+	natsURL := cfg.NATS.URL
 	nc, err := nats.Connect(natsURL)
 	if err != nil {
 		log.Fatalf("ERROR: could not connect to NATS: %v", err)
 	}
-	defer nc.Close()
 	log.Println("✅ Ingestor service successfully connected to NATS server at", natsURL)
 
-	// ვიწყებთ ყველა ნაკადის გაშვებას კონფიგურაციის მიხედვით
-	for _, symbol := range symbols {
-		// ვიწყებთ ტრეიდების ნაკადს
-		go connectToTradeStream(nc, symbol)
+	// trade, kline და depth ქვეყნდება JetStream-ში (MD_TRADES, MD_KLINES, MD_DEPTH), რომ არქივატორის გადატვირთვისას მონაცემები არ დაიკარგოს
+	js, err := jetstream.New(nc,
+		jetstream.WithPublishAsyncMaxPending(jetstreamMaxPending),
+		jetstream.WithPublishAsyncErrHandler(func(_ jetstream.JetStream, msg *nats.Msg, err error) {
+			log.Printf("JetStream did not acknowledge message on %s: %v", msg.Subject, err)
+		}),
+	)
+	if err != nil {
+		log.Fatalf("ERROR: could not create JetStream context: %v", err)
+	}
+	setupCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	err = mdstream.Ensure(setupCtx, js, cfg.NATS.JetStream)
+	cancel()
+	if err != nil {
+		log.Fatalf("ERROR: could not set up JetStream streams (is the server running with -js?): %v", err)
+	}
 
-		// ვიწყებთ სანთლების ნაკადს
-		for _, interval := range klineIntervals {
-			go connectToKlineStream(nc, symbol, interval)
-		}
+	exchange, err := newExchange(cfg)
+	if err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+	log.Printf("Ingesting market data from %s.", exchange.Name())
 
-		// ვიწყებთ Order Book-ის ნაკადს
-		go connectToDepthStream(nc, symbol)
+	payloadCodec, err := codec.ByName(cfg.NATS.Codec)
+	if err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+	log.Printf("Publishing market data encoded as %s.", payloadCodec.Name())
+
+	// ვხსნით ერთ საერთო კავშირს კონფიგურაციის მიხედვით; ნაკადების დამატება/მოხსნა შემდეგ control plane-ით ხდება
+	stream := newMarketStream(nc, js, payloadCodec, exchange, cfg.Market.LowerSymbols(), cfg.Market.KlineIntervals)
+	streamDone := make(chan struct{})
+	go func() {
+		defer close(streamDone)
+		stream.run(ctx)
+	}()
+
+	if err := registerControlHandlers(nc, stream); err != nil {
+		log.Fatalf("ERROR: could not register control handlers: %v", err)
 	}
 
 	// ველით შეწყვეტის სიგნალს
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	<-ctx.Done()
 
 	log.Println("👋 Ingestor service shutting down...")
-}
\ No newline at end of file
+	// ჯერ ვხურავთ ბირჟის კავშირს, რომ ახალი შეტყობინებები აღარ გამოქვეყნდეს
+	<-streamDone
+	// ველოდებით უკვე გაგზავნილი JetStream შეტყობინებების დადასტურებას
+	select {
+	case <-js.PublishAsyncComplete():
+	case <-time.After(lifecycle.ShutdownTimeout):
+		log.Printf("Timed out waiting for %d JetStream acknowledgements", js.PublishAsyncPending())
+	}
+	if err := lifecycle.DrainNATS(nc); err != nil {
+		log.Printf("NATS drain error: %v", err)
+	}
+	log.Println("Ingestor stopped.")
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"rdr/common/codec"
+	"rdr/common/marketdata"
+	"rdr/common/mdstream"
+)
+
+// --- ხელახალი დაკავშირების პარამეტრები ---
+// ცვლადებია, რომ ტესტებმა შეამოკლონ
+var (
+	backoffBase = 1 * time.Second
+	backoffMax  = 60 * time.Second
+	// თუ კავშირმა ამდენ ხანს იმუშავა, backoff-ის მრიცხველი ნულდება
+	stableAfter = 1 * time.Minute
+)
+
+const (
+	// Binance ყოველ 24 საათში ძალით წყვეტს კავშირს, ამიტომ ცოტა ადრე თავად ვხსნით ახალს
+	maxConnLifetime = 23*time.Hour + 30*time.Minute
+	// Binance ყოველ 20 წამში აგზავნის ping-ს; თუ ამ ხნის განმავლობაში არაფერი მოვიდა, კავშირი მკვდარია
+	readTimeout = 1 * time.Minute
+	// რამდენ ხანს ველოდებით პასუხს SUBSCRIBE/UNSUBSCRIBE მოთხოვნაზე
+	methodTimeout = 5 * time.Second
+	// გაჩერებისას close frame-ის პასუხის მოლოდინის ზღვარი, სანამ კავშირს ძალით დავხურავთ
+	closeGracePeriod = time.Second
+)
+
+var errConnExpired = errors.New("connection lifetime reached")
+
+// backoffDelay აბრუნებს jitter-იან ექსპონენციალურ დაყოვნებას attempt-ური ცდისთვის
+func backoffDelay(attempt int) time.Duration {
+	d := backoffMax
+	if attempt < 16 {
+		d = min(backoffBase<<(attempt-1), backoffMax)
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// natsPublisher არის *nats.Conn-ის ის ნაწილი, რომლითაც ნაკადი აქვეყნებს; ტესტებში ის ჩანაცვლებადია
+type natsPublisher interface {
+	Publish(subject string, data []byte) error
+	PublishMsg(msg *nats.Msg) error
+}
+
+// marketStream ინარჩუნებს ერთ საერთო კავშირს ბირჟასთან ყველა აქტიური ნაკადისთვის.
+// ნაკადების ნაკრები შეიძლება შეიცვალოს გაშვების დროს (subscribe/unsubscribe).
+type marketStream struct {
+	nc       natsPublisher
+	js       jetstream.JetStream
+	codec    codec.Codec
+	exchange Exchange
+
+	// controlMu ერთმანეთის მიყოლებით ასრულებს subscribe/unsubscribe ოპერაციებს
+	controlMu sync.Mutex
+
+	mu        sync.Mutex
+	symbols   []string
+	intervals []string
+	routes    map[string]streamSpec
+	conn      *websocket.Conn
+	nextID    int64
+	pending   map[int64]chan controlReply
+
+	writeMu sync.Mutex
+}
+
+func newMarketStream(nc natsPublisher, js jetstream.JetStream, payloadCodec codec.Codec, exchange Exchange, symbols []string, intervals []string) *marketStream {
+	s := &marketStream{
+		nc:        nc,
+		js:        js,
+		codec:     payloadCodec,
+		exchange:  exchange,
+		symbols:   slices.Clone(symbols),
+		intervals: slices.Clone(intervals),
+		routes:    make(map[string]streamSpec),
+		pending:   make(map[int64]chan controlReply),
+	}
+	for _, spec := range buildStreamSpecs(s.symbols, s.intervals) {
+		s.routes[spec.Key()] = spec
+	}
+	return s
+}
+
+// specs აბრუნებს აქტიური ნაკადების სიას სიმბოლოებისა და ინტერვალების თანმიმდევრობით
+func (s *marketStream) specs() []streamSpec {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return buildStreamSpecs(s.symbols, s.intervals)
+}
+
+// state აბრუნებს აქტიურ სიმბოლოებს, ინტერვალებს და ნაკადების გასაღებებს
+func (s *marketStream) state() (symbols []string, intervals []string, streams []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, spec := range buildStreamSpecs(s.symbols, s.intervals) {
+		streams = append(streams, spec.Key())
+	}
+	return slices.Clone(s.symbols), slices.Clone(s.intervals), streams
+}
+
+// run ინარჩუნებს კავშირს, სანამ ctx არ გაუქმდება: აკავშირებს, კითხულობს და შეცდომისას თავიდან
+// უკავშირდება. გაუქმებისას ბირჟას close frame-ს უგზავნის და ბრუნდება.
+func (s *marketStream) run(ctx context.Context) {
+	name := s.exchange.Name()
+	attempt := 0
+	var lastErr error
+	var disconnectedAt time.Time
+
+	for {
+		if attempt > 0 {
+			delay := backoffDelay(attempt)
+			log.Printf("Reconnecting to %s stream in %s (attempt %d)", name, delay.Round(time.Millisecond), attempt)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		// URL-ს ყოველ ჯერზე თავიდან ვაწყობთ, რადგან ნაკადების ნაკრები შეიძლება შეცვლილიყო
+		specs := s.specs()
+		streamURL, dialed := s.exchange.StreamURL(specs)
+		log.Printf("Connecting to %s stream with %d streams: %s", name, len(specs), streamURL)
+		c, _, err := websocket.DefaultDialer.DialContext(ctx, streamURL, nil)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("%s stream WebSocket dial error: %v", name, err)
+			if disconnectedAt.IsZero() {
+				disconnectedAt = time.Now()
+			}
+			lastErr = err
+			attempt++
+			continue
+		}
+		log.Printf("✅ Successfully connected to %s stream (%d streams).", name, len(specs))
+
+		if lastErr != nil {
+			downtime := time.Since(disconnectedAt)
+			for _, spec := range specs {
+				publishReconnect(s.nc, name, spec, attempt, lastErr, downtime)
+			}
+		}
+
+		s.mu.Lock()
+		s.conn = c
+		s.mu.Unlock()
+		go s.reconcile(dialed)
+
+		// გაჩერებისას კითხვა close frame-ით წყდება; ბირჟა პასუხს თუ არ დააბრუნებს, კავშირს ვხურავთ
+		stop := context.AfterFunc(ctx, func() {
+			c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(closeGracePeriod))
+			time.AfterFunc(closeGracePeriod, func() { c.Close() })
+		})
+
+		connectedAt := time.Now()
+		err = s.pump(c)
+		stop()
+
+		s.mu.Lock()
+		s.conn = nil
+		s.mu.Unlock()
+		c.Close()
+		s.failPending(err)
+
+		if ctx.Err() != nil {
+			log.Printf("%s stream closed.", name)
+			return
+		}
+		disconnectedAt = time.Now()
+		lastErr = err
+
+		switch {
+		case errors.Is(err, errConnExpired):
+			log.Printf("%s stream reached its lifetime, redialing.", name)
+			attempt = 0
+		case time.Since(connectedAt) >= stableAfter:
+			log.Printf("%s stream read error: %v", name, err)
+			attempt = 1
+		default:
+			log.Printf("%s stream read error: %v", name, err)
+			attempt++
+		}
+	}
+}
+
+// pump კითხულობს შეტყობინებებს, ანაწილებს ნაკადების მიხედვით და აქვეყნებს NATS-ზე,
+// სანამ კავშირი არ გაწყდება ან არ ამოიწურება
+func (s *marketStream) pump(c *websocket.Conn) error {
+	expiresAt := time.Now().Add(maxConnLifetime)
+
+	c.SetReadDeadline(time.Now().Add(readTimeout))
+	c.SetPingHandler(func(appData string) error {
+		c.SetReadDeadline(time.Now().Add(readTimeout))
+		err := c.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(time.Second))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		return err
+	})
+
+	for {
+		if time.Now().After(expiresAt) {
+			c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			return errConnExpired
+		}
+
+		_, message, err := c.ReadMessage()
+		if err != nil {
+			return err
+		}
+		c.SetReadDeadline(time.Now().Add(readTimeout))
+
+		decoded, err := s.exchange.Decode(message)
+		if err != nil {
+			log.Printf("Error decoding %s stream message: %v", s.exchange.Name(), err)
+			continue
+		}
+		if decoded.Reply != nil {
+			s.resolvePending(*decoded.Reply)
+		}
+		if len(decoded.Resync) > 0 {
+			// პასუხებს pump კითხულობს, ამიტომ მოთხოვნები ცალკე goroutine-ში იგზავნება
+			go func() {
+				if err := s.resubscribe(decoded.Resync); err != nil {
+					log.Printf("Error resyncing %d streams: %v", len(decoded.Resync), err)
+				}
+			}()
+		}
+
+		for _, event := range decoded.Events {
+			s.mu.Lock()
+			_, ok := s.routes[event.Key]
+			s.mu.Unlock()
+			if !ok {
+				log.Printf("Received message for unknown stream %q", event.Key)
+				continue
+			}
+
+			msg, err := codec.NewMsg(event.Subject, s.codec, event.Payload)
+			if err != nil {
+				log.Printf("Error encoding message for subject %s: %v", event.Subject, err)
+				continue
+			}
+			s.publish(msg)
+		}
+	}
+}
+
+// publish აგზავნის შეტყობინებას: trade/kline/depth JetStream-ში (არქივატორი მათ გადატვირთვის შემდეგაც
+// მიიღებს), დანარჩენი (book) ჩვეულებრივ NATS-ზე
+func (s *marketStream) publish(msg *nats.Msg) {
+	if !mdstream.Persisted(msg.Subject) {
+		if err := s.nc.PublishMsg(msg); err != nil {
+			log.Printf("Error publishing to NATS on subject %s: %v", msg.Subject, err)
+		}
+		return
+	}
+	// ასინქრონული გამოქვეყნება websocket-ის კითხვას არ აბრკოლებს; დადასტურების შეცდომებს
+	// jetstream-ის error handler-ი წერს ლოგში
+	if _, err := s.js.PublishMsgAsync(msg); err != nil {
+		log.Printf("Error publishing to JetStream on subject %s: %v", msg.Subject, err)
+	}
+}
+
+// subscribe ამატებს სიმბოლოებსა და ინტერვალებს; ღია კავშირზე ახალი ნაკადები subscribe მოთხოვნით ემატება
+func (s *marketStream) subscribe(symbols []string, intervals []string) error {
+	s.controlMu.Lock()
+	defer s.controlMu.Unlock()
+
+	s.mu.Lock()
+	oldSymbols, oldIntervals := s.symbols, s.intervals
+	newSymbols, newIntervals := slices.Clone(oldSymbols), slices.Clone(oldIntervals)
+	for _, symbol := range symbols {
+		if !slices.Contains(newSymbols, symbol) {
+			newSymbols = append(newSymbols, symbol)
+		}
+	}
+	for _, interval := range intervals {
+		if !slices.Contains(newIntervals, interval) {
+			newIntervals = append(newIntervals, interval)
+		}
+	}
+	var added []streamSpec
+	for _, spec := range buildStreamSpecs(newSymbols, newIntervals) {
+		if _, ok := s.routes[spec.Key()]; !ok {
+			added = append(added, spec)
+			s.routes[spec.Key()] = spec
+		}
+	}
+	s.symbols, s.intervals = newSymbols, newIntervals
+	s.mu.Unlock()
+
+	if len(added) == 0 {
+		return nil
+	}
+	if err := s.sendControl(opSubscribe, added); err != nil {
+		// ვაბრუნებთ წინა მდგომარეობას
+		s.mu.Lock()
+		for _, spec := range added {
+			delete(s.routes, spec.Key())
+		}
+		s.symbols, s.intervals = oldSymbols, oldIntervals
+		s.mu.Unlock()
+		return err
+	}
+	log.Printf("✅ Subscribed to %d new streams.", len(added))
+	return nil
+}
+
+// unsubscribe აშორებს სიმბოლოებსა და ინტერვალებს; ღია კავშირზე ნაკადები unsubscribe მოთხოვნით იხსნება
+func (s *marketStream) unsubscribe(symbols []string, intervals []string) error {
+	s.controlMu.Lock()
+	defer s.controlMu.Unlock()
+
+	s.mu.Lock()
+	newSymbols := slices.DeleteFunc(slices.Clone(s.symbols), func(symbol string) bool { return slices.Contains(symbols, symbol) })
+	newIntervals := slices.DeleteFunc(slices.Clone(s.intervals), func(interval string) bool { return slices.Contains(intervals, interval) })
+	keep := make(map[string]bool)
+	for _, spec := range buildStreamSpecs(newSymbols, newIntervals) {
+		keep[spec.Key()] = true
+	}
+	var removed []streamSpec
+	for key, spec := range s.routes {
+		if !keep[key] {
+			removed = append(removed, spec)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(removed) > 0 {
+		if err := s.sendControl(opUnsubscribe, removed); err != nil {
+			return err
+		}
+	}
+
+	// მარშრუტებს მხოლოდ წარმატებული unsubscribe-ის შემდეგ ვშლით, რომ გზაში მყოფი შეტყობინებები არ დაიკარგოს
+	s.mu.Lock()
+	for _, spec := range removed {
+		delete(s.routes, spec.Key())
+	}
+	s.symbols, s.intervals = newSymbols, newIntervals
+	s.mu.Unlock()
+	if len(removed) > 0 {
+		log.Printf("✅ Unsubscribed from %d streams.", len(removed))
+	}
+	return nil
+}
+
+// reconcile გამოიწერს იმ ნაკადებს, რომლებიც URL-ში არ მოხვდა (ან ნაკრები შეიცვალა URL-ის აწყობასა და
+// კავშირის გახსნას შორის), და ხსნის იმ ნაკადებს, რომლებიც ამასობაში მოიხსნა
+func (s *marketStream) reconcile(dialed []streamSpec) {
+	s.controlMu.Lock()
+	defer s.controlMu.Unlock()
+
+	s.mu.Lock()
+	inURL := make(map[string]bool, len(dialed))
+	for _, spec := range dialed {
+		inURL[spec.Key()] = true
+	}
+	var added, removed []streamSpec
+	for key, spec := range s.routes {
+		if !inURL[key] {
+			added = append(added, spec)
+		}
+	}
+	for _, spec := range dialed {
+		if _, ok := s.routes[spec.Key()]; !ok {
+			removed = append(removed, spec)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(added) > 0 {
+		if err := s.sendControl(opSubscribe, added); err != nil {
+			log.Printf("Error subscribing to %d pending streams: %v", len(added), err)
+		}
+	}
+	if len(removed) > 0 {
+		if err := s.sendControl(opUnsubscribe, removed); err != nil {
+			log.Printf("Error unsubscribing from %d stale streams: %v", len(removed), err)
+		}
+	}
+}
+
+// resubscribe ხსნის და თავიდან გამოიწერს ნაკადებს, რომლებიც ჯერ კიდევ აქტიურია; გამოუწერელი
+// ნაკადები გამოიტოვება
+func (s *marketStream) resubscribe(specs []streamSpec) error {
+	s.controlMu.Lock()
+	defer s.controlMu.Unlock()
+
+	s.mu.Lock()
+	specs = slices.DeleteFunc(slices.Clone(specs), func(spec streamSpec) bool {
+		_, ok := s.routes[spec.Key()]
+		return !ok
+	})
+	s.mu.Unlock()
+	if len(specs) == 0 {
+		return nil
+	}
+	if err := s.sendControl(opUnsubscribe, specs); err != nil {
+		return fmt.Errorf("unsubscribing: %w", err)
+	}
+	if err := s.sendControl(opSubscribe, specs); err != nil {
+		return fmt.Errorf("resubscribing: %w", err)
+	}
+	log.Printf("✅ Resubscribed to %d streams.", len(specs))
+	return nil
+}
+
+// sendControl აგზავნის subscribe/unsubscribe მოთხოვნებს და ელოდება ბირჟის პასუხს თითოეულზე.
+// თუ კავშირი არ არის ღია, არაფერს აგზავნის — შემდეგი დაკავშირება ისედაც ახალ ნაკრებს გამოიყენებს.
+func (s *marketStream) sendControl(op controlOp, specs []streamSpec) error {
+	s.mu.Lock()
+	c := s.conn
+	s.mu.Unlock()
+	if c == nil {
+		return nil
+	}
+
+	messages, err := s.exchange.ControlMessages(op, specs, func() int64 {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.nextID++
+		return s.nextID
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range messages {
+		if err := s.sendAndWait(c, op, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *marketStream) sendAndWait(c *websocket.Conn, op controlOp, msg controlMessage) error {
+	reply := make(chan controlReply, 1)
+	s.mu.Lock()
+	s.pending[msg.ID] = reply
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, msg.ID)
+		s.mu.Unlock()
+	}()
+
+	s.writeMu.Lock()
+	c.SetWriteDeadline(time.Now().Add(methodTimeout))
+	err := c.WriteJSON(msg.Payload)
+	s.writeMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("sending %s: %w", op, err)
+	}
+
+	select {
+	case resp := <-reply:
+		if resp.Err != nil {
+			return fmt.Errorf("%s: %w", op, resp.Err)
+		}
+		return nil
+	case <-time.After(methodTimeout):
+		return fmt.Errorf("%s timed out after %s", op, methodTimeout)
+	}
+}
+
+func (s *marketStream) resolvePending(resp controlReply) {
+	s.mu.Lock()
+	reply, ok := s.pending[resp.ID]
+	s.mu.Unlock()
+	if !ok {
+		// ზოგი ბირჟა (მაგ. Kraken) ერთ მოთხოვნაზე რამდენიმე პასუხს აგზავნის; პირველის შემდეგ დანარჩენს ვტოვებთ
+		if resp.Err != nil {
+			log.Printf("Late control error from %s: %v", s.exchange.Name(), resp.Err)
+		}
+		return
+	}
+	select {
+	case reply <- resp:
+	default:
+	}
+}
+
+// failPending ასრულებს პასუხის მომლოდინე ყველა მოთხოვნას, როცა კავშირი წყდება
+func (s *marketStream) failPending(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, reply := range s.pending {
+		select {
+		case reply <- controlReply{ID: id, Err: fmt.Errorf("connection closed: %v", err)}:
+		default:
+		}
+		delete(s.pending, id)
+	}
+}
+
+func publishReconnect(nc natsPublisher, exchange string, spec streamSpec, attempts int, reason error, downtime time.Duration) {
+	event := marketdata.ReconnectEvent{
+		Exchange:   exchange,
+		Kind:       spec.Kind,
+		Symbol:     spec.Symbol,
+		Interval:   spec.Interval,
+		Attempts:   attempts,
+		Reason:     reason.Error(),
+		DowntimeMs: downtime.Milliseconds(),
+		Time:       time.Now().UnixMilli(),
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error marshaling reconnect event for %s: %v", spec.Name(), err)
+		return
+	}
+	subject := marketdata.ReconnectSubject(spec.Symbol)
+	if err := nc.Publish(subject, data); err != nil {
+		log.Printf("Error publishing reconnect event on subject %s: %v", subject, err)
+	}
+}
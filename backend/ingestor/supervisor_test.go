@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nats-io/nats.go"
+
+	"rdr/common/codec"
+	"rdr/common/marketdata"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 500 * time.Millisecond, time.Second},
+		{2, time.Second, 2 * time.Second},
+		{6, 16 * time.Second, 32 * time.Second},
+		// 64 წამი ზღვარს (60) სცდება
+		{7, 30 * time.Second, 60 * time.Second},
+		{16, 30 * time.Second, 60 * time.Second},
+		// დიდ ცდებზე წანაცვლება აღარ გადაივსება
+		{100, 30 * time.Second, 60 * time.Second},
+	}
+	for _, tt := range tests {
+		for range 200 {
+			if d := backoffDelay(tt.attempt); d < tt.min || d > tt.max {
+				t.Fatalf("backoffDelay(%d) = %s, want within [%s, %s]", tt.attempt, d, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+// fastBackoff ამოკლებს დაყოვნებებს ტესტის ხანგრძლივობით
+func fastBackoff(t *testing.T, stable time.Duration) {
+	base, max, after := backoffBase, backoffMax, stableAfter
+	backoffBase, backoffMax, stableAfter = time.Millisecond, 5*time.Millisecond, stable
+	t.Cleanup(func() { backoffBase, backoffMax, stableAfter = base, max, after })
+}
+
+// recordingPublisher იმახსოვრებს NATS-ზე გამოქვეყნებულ შეტყობინებებს
+type recordingPublisher struct {
+	mu   sync.Mutex
+	msgs []*nats.Msg
+}
+
+func (p *recordingPublisher) Publish(subject string, data []byte) error {
+	return p.PublishMsg(&nats.Msg{Subject: subject, Data: data})
+}
+
+func (p *recordingPublisher) PublishMsg(msg *nats.Msg) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.msgs = append(p.msgs, msg)
+	return nil
+}
+
+// waitReconnects ელოდება n ხელახალი დაკავშირების მოვლენას
+func (p *recordingPublisher) waitReconnects(t *testing.T, n int) []marketdata.ReconnectEvent {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p.mu.Lock()
+		var events []marketdata.ReconnectEvent
+		for _, msg := range p.msgs {
+			if !strings.HasPrefix(msg.Subject, "ingestor.reconnect.") {
+				continue
+			}
+			var event marketdata.ReconnectEvent
+			if err := json.Unmarshal(msg.Data, &event); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Subject != marketdata.ReconnectSubject(event.Symbol) {
+				t.Errorf("event for %s published on %s", event.Symbol, msg.Subject)
+			}
+			events = append(events, event)
+		}
+		p.mu.Unlock()
+		if len(events) >= n {
+			return events
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d reconnect events, want %d", len(events), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// scriptedVenue არის fakeVenue, რომელიც ყოველ კავშირს სკრიპტის თავისი ნაბიჯით ემსახურება: "drop" მაშინვე
+// წყვეტს კავშირს, ხანგრძლივობა კი კავშირს იმდენ ხანს ინახავს. სკრიპტის ბოლოს კავშირი ღია რჩება,
+// სანამ კლიენტი არ დახურავს.
+func scriptedVenue(t *testing.T, script ...string) (string, *atomic.Int32) {
+	t.Helper()
+	var connections atomic.Int32
+	url := fakeVenue(t, func(r *http.Request, conn *websocket.Conn) {
+		n := int(connections.Add(1))
+		if n > len(script) {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}
+		if d, err := time.ParseDuration(script[n-1]); err == nil {
+			time.Sleep(d)
+		}
+	})
+	return url, &connections
+}
+
+// runStream უშვებს ნაკადს btcusdt-ის trade, kline (1m) და depth ნაკადებით
+func runStream(t *testing.T, url string, publisher *recordingPublisher) *marketStream {
+	t.Helper()
+	stream := newMarketStream(publisher, nil, codec.JSON, newBinanceExchange(url), []string{"btcusdt"}, []string{"1m"})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		stream.run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return stream
+}
+
+func TestReconnectEvents(t *testing.T) {
+	fastBackoff(t, time.Minute)
+	url, connections := scriptedVenue(t, "drop", "drop")
+	publisher := &recordingPublisher{}
+	runStream(t, url, publisher)
+
+	// პირველი კავშირი მოვლენას არ აქვეყნებს; მეორე და მესამე თითო მოვლენას აქვეყნებს თითო ნაკადზე,
+	// მანამდე გაწყვეტილი კავშირების რაოდენობით
+	events := publisher.waitReconnects(t, 6)
+	if len(events) != 6 {
+		t.Fatalf("got %d reconnect events, want 6: %+v", len(events), events)
+	}
+	wantKinds := []string{"trade", "kline", "depth"}
+	for i, event := range events {
+		attempts := 1 + i/3
+		if event.Exchange != "binance" || event.Symbol != "btcusdt" || event.Kind != wantKinds[i%3] || event.Attempts != attempts {
+			t.Errorf("event %d = %+v, want %s btcusdt after %d attempts", i, event, wantKinds[i%3], attempts)
+		}
+		if event.DowntimeMs < 0 || event.Time == 0 {
+			t.Errorf("event %d has downtime %dms at %d", i, event.DowntimeMs, event.Time)
+		}
+	}
+	if interval := events[1].Interval; interval != "1m" {
+		t.Errorf("kline event interval = %q, want 1m", interval)
+	}
+	if events[0].Reason == "" {
+		t.Error("reconnect event without a reason")
+	}
+	if n := connections.Load(); n != 3 {
+		t.Errorf("%d connections, want 3", n)
+	}
+}
+
+func TestBackoffResetsAfterStableConnection(t *testing.T) {
+	fastBackoff(t, 50*time.Millisecond)
+	// მეორე კავშირი stableAfter-ზე მეტხანს ცოცხლობს, ამიტომ მისი გაწყვეტის შემდეგ ცდები თავიდან ითვლება
+	url, _ := scriptedVenue(t, "drop", "100ms")
+	publisher := &recordingPublisher{}
+	runStream(t, url, publisher)
+
+	events := publisher.waitReconnects(t, 6)
+	for i, event := range events {
+		if event.Attempts != 1 {
+			t.Errorf("event %d after %d attempts, want 1", i, event.Attempts)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"rdr/common/codec"
+	"rdr/common/decimal"
+	"rdr/common/marketdata"
+	"rdr/common/orderbook"
+	"rdr/common/symbols"
+)
+
+// --- Order Book-ის მენეჯერი ---
+
+// snapshot-ის მოლოდინში ბუფერში შენახული განახლებების ზღვარი; გადაჭარბებისას უძველესი იყრება
+const maxBufferedDeltas = 5000
+
+// REST snapshot-ის ხელახლა მოთხოვნამდე დაყოვნება (შეცდომის ან მოძველებული snapshot-ის შემთხვევაში);
+// იგივე ზღვარი აქვს ingestor-ისთვის resync მოთხოვნებს. ცვლადია, რომ ტესტებმა შეამოკლონ
+var snapshotRetryDelay = time.Second
+
+// ingestorResyncSubject არის ingestor-ის control მოთხოვნა (იხ. ingestor/control.go), რომელიც
+// depth ნაკადს ხელახლა გამოიწერს, რომ ნაკადში snapshot-ის გამგზავნმა ბირჟამ ახალი გამოგზავნოს
+const ingestorResyncSubject = "control.ingestor.resync"
+
+// resyncTimeout არის ingestor-ის პასუხის მოლოდინის ზღვარი; ის ბირჟისგან ორ პასუხს ელოდება
+const resyncTimeout = 10 * time.Second
+
+// requester არის *nats.Conn-ის ის ნაწილი, რომლითაც ingestor-ს resync-ს ვთხოვთ; ტესტებში ის ჩანაცვლებადია
+type requester interface {
+	Request(subject string, data []byte, timeout time.Duration) (*nats.Msg, error)
+}
+
+// OrderBook არის ერთი სიმბოლოს ლოკალური წიგნი (დალაგებული დონეებით, იხ. rdr/common/orderbook).
+// სანამ synced=false, წიგნი snapshot-ს ელოდება და depth განახლებები buffer-ში გროვდება
+// (Binance-ის "buffered sync" პროცედურა).
+type OrderBook struct {
+	*orderbook.Book
+	mu sync.RWMutex
+
+	synced bool
+	buffer []marketdata.DepthDelta
+	// fetching=true, როცა REST snapshot-ის ან ingestor-ის resync მოთხოვნა უკვე მიმდინარეობს
+	fetching bool
+
+	// გამოქვეყნების მდგომარეობა (იხ. publish.go): ფასები, რომლებიც diffFrom-ის შემდეგ შეიცვალა
+	dirtyBids map[decimal.Decimal]struct{}
+	dirtyAsks map[decimal.Decimal]struct{}
+	diffFrom  int64
+	// ბოლო გამოქვეყნებული snapshot-ის LastUpdateID; -1 ნიშნავს, რომ snapshot დაუყოვნებლივ უნდა გამოქვეყნდეს
+	publishedID  int64
+	nextSnapshot time.Time
+	nextDiff     time.Time
+	// ბოლო გამოქვეყნებული სტატისტიკის LastUpdateID (-1 — დაუყოვნებლივ)
+	statsID   int64
+	nextStats time.Time
+	// არქივის checkpoint-ები; resync-ის შემდეგ checkpoint მაშინვე ქვეყნდება, რომ ისტორიული
+	// რეკონსტრუქცია ხვრელის გადაღმა ძველ checkpoint-ს არ დაეყრდნოს
+	checkpointID   int64
+	nextCheckpoint time.Time
+}
+
+// reset ტვირთავს snapshot-ს. ძველი diff-ები ახალ წიგნთან აღარ აკავშირდება, ამიტომ
+// შემდეგი გამოქვეყნება სრული snapshot იქნება.
+func (book *OrderBook) reset(lastUpdateID int64, bids, asks []marketdata.Level) error {
+	if err := book.Reset(lastUpdateID, bids, asks); err != nil {
+		return err
+	}
+	book.dirtyBids = make(map[decimal.Decimal]struct{})
+	book.dirtyAsks = make(map[decimal.Decimal]struct{})
+	book.diffFrom = lastUpdateID
+	book.publishedID = -1
+	book.nextSnapshot = time.Time{}
+	book.statsID = -1
+	book.nextStats = time.Time{}
+	book.checkpointID = -1
+	book.nextCheckpoint = time.Time{}
+	return nil
+}
+
+// apply ადებს განახლებას და იმახსოვრებს შეცვლილ ფასებს შემდეგი diff-ისთვის. Apply უკვე ასახულ
+// (მოძველებულ) განახლებას უგულებელყოფს და LastUpdateID-ს არ ცვლის; ასეთი განახლების ფასები არ შეცვლილა.
+func (book *OrderBook) apply(update marketdata.DepthDelta) error {
+	before := book.LastUpdateID
+	if err := book.Apply(update); err != nil {
+		return err
+	}
+	if book.LastUpdateID == before {
+		return nil
+	}
+	// Apply-მ ფასები უკვე შეამოწმა, ამიტომ შეცდომა აქ აღარ მოსალოდნელია
+	markDirty := func(dirty map[decimal.Decimal]struct{}, levels []marketdata.Level) {
+		for _, l := range levels {
+			if price, err := decimal.Parse(l.Price()); err == nil {
+				dirty[book.Precision.Price(price)] = struct{}{}
+			}
+		}
+	}
+	markDirty(book.dirtyBids, update.Bids)
+	markDirty(book.dirtyAsks, update.Asks)
+	return nil
+}
+
+type OrderBookManager struct {
+	books map[string]*OrderBook
+	mu    sync.RWMutex
+	// ბირჟა, რომლის მონაცემებსაც ingestor აქვეყნებს
+	exchange string
+	restURL  string
+	// control-ით ვთხოვთ ingestor-ს snapshot-ს ბირჟებისთვის, რომლებიც მას ნაკადში აგზავნიან
+	control requester
+	// სიმბოლოების tick/step ზომები exchangeInfo-დან (config-ის market.precision უპირატესია)
+	registry *symbols.Registry
+}
+
+func (obm *OrderBookManager) getBook(symbol string) *OrderBook {
+	obm.mu.RLock()
+	defer obm.mu.RUnlock()
+	return obm.books[symbol]
+}
+
+// bookFor აბრუნებს სიმბოლოს წიგნს და საჭიროებისას ქმნის მას. ასე control plane-ით
+// დამატებული სიმბოლოები პირველივე depth განახლებაზე იწყებენ სინქრონიზაციას.
+func (obm *OrderBookManager) bookFor(symbol string) *OrderBook {
+	obm.mu.Lock()
+	defer obm.mu.Unlock()
+	book, ok := obm.books[symbol]
+	if !ok {
+		book = &OrderBook{Book: orderbook.NewBook(obm.registry.Precision(symbol))}
+		obm.books[symbol] = book
+	}
+	return book
+}
+
+// resync აბრუნებს წიგნს სინქრონიზაციის რეჟიმში. Binance-ისთვის snapshot-ს REST-ით ვიღებთ; სხვა ბირჟები
+// (მაგ. Kraken) snapshot-ს ნაკადში აგზავნიან, ამიტომ ingestor-ს ვთხოვთ, ხელახლა გამოიწეროს წიგნი.
+func (obm *OrderBookManager) resync(symbol string) {
+	book := obm.bookFor(symbol)
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	book.synced = false
+	if obm.exchange != "binance" {
+		// ნაკადში მოსული snapshot-ის შემდეგ დაგროვილი განახლებები ისედაც ძველია
+		book.buffer = nil
+	}
+	obm.startFetchLocked(symbol, book)
+}
+
+// startFetchLocked იწყებს snapshot-ის მოპოვებას, თუ ის უკვე არ მიმდინარეობს: Binance-ისთვის REST-ით,
+// სხვა ბირჟებისთვის ingestor-ის resync მოთხოვნით. book.mu დაბლოკილი უნდა იყოს.
+func (obm *OrderBookManager) startFetchLocked(symbol string, book *OrderBook) {
+	if book.fetching {
+		return
+	}
+	book.fetching = true
+	if obm.exchange != "binance" {
+		go obm.requestResync(symbol)
+		return
+	}
+	go obm.fetchSnapshot(symbol)
+}
+
+// requestResync სთხოვს ingestor-ს symbol-ის წიგნის ხელახლა გამოწერას; ახალი snapshot loadSnapshot-ით
+// ჩაიტვირთება. შემდეგი მოთხოვნა snapshotRetryDelay-ზე ადრე არ იგზავნება, რომ ყოველმა
+// დაუსინქრონებელმა განახლებამ ბირჟაზე ხელახალი გამოწერა არ გამოიწვიოს; თუ ამ დროში snapshot არ
+// მოვიდა, მოთხოვნა მეორდება, განახლებები რომც აღარ მოდიოდეს.
+func (obm *OrderBookManager) requestResync(symbol string) {
+	defer time.AfterFunc(snapshotRetryDelay, func() { obm.resyncDone(symbol) })
+	log.Printf("Asking the ingestor to resync the %s %s order book...", obm.exchange, symbol)
+	data, err := json.Marshal(map[string][]string{"symbols": {symbol}})
+	if err != nil {
+		log.Printf("Error encoding resync request for %s: %v", symbol, err)
+		return
+	}
+	msg, err := obm.control.Request(ingestorResyncSubject, data, resyncTimeout)
+	if err != nil {
+		log.Printf("Resync request for %s failed: %v", symbol, err)
+		return
+	}
+	var resp struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(msg.Data, &resp); err != nil {
+		log.Printf("Error decoding resync response for %s: %v", symbol, err)
+		return
+	}
+	if !resp.OK {
+		log.Printf("Ingestor rejected the resync of %s: %s", symbol, resp.Error)
+	}
+}
+
+func (obm *OrderBookManager) fetchSnapshot(symbol string) {
+	log.Printf("Fetching snapshot for %s...", symbol)
+	snapshot, err := obm.requestSnapshot(symbol)
+	if err != nil {
+		log.Printf("Error fetching snapshot for %s: %v", symbol, err)
+		// შემდეგი depth განახლება ხელახლა სცდის, მაგრამ არა snapshotRetryDelay-ზე ადრე
+		time.AfterFunc(snapshotRetryDelay, func() { obm.fetchDone(symbol) })
+		return
+	}
+
+	book := obm.bookFor(symbol)
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	book.fetching = false
+	if obm.syncLocked(symbol, book, snapshot.LastUpdateID, snapshot.Bids, snapshot.Asks) {
+		return
+	}
+	// snapshot ბუფერის პირველ განახლებაზე ძველია — ვითხოვთ ახალს
+	book.fetching = true
+	time.AfterFunc(snapshotRetryDelay, func() { obm.fetchSnapshot(symbol) })
+}
+
+func (obm *OrderBookManager) fetchDone(symbol string) {
+	book := obm.bookFor(symbol)
+	book.mu.Lock()
+	book.fetching = false
+	book.mu.Unlock()
+}
+
+func (obm *OrderBookManager) resyncDone(symbol string) {
+	book := obm.bookFor(symbol)
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	book.fetching = false
+	if !book.synced {
+		obm.startFetchLocked(symbol, book)
+	}
+}
+
+func (obm *OrderBookManager) requestSnapshot(symbol string) (OrderBookSnapshot, error) {
+	url := fmt.Sprintf("%s/api/v3/depth?symbol=%s&limit=1000", obm.restURL, symbol)
+	resp, err := http.Get(url)
+	if err != nil {
+		return OrderBookSnapshot{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return OrderBookSnapshot{}, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var snapshot OrderBookSnapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
+		return OrderBookSnapshot{}, fmt.Errorf("decoding snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+// loadSnapshot იღებს ნაკადში გამოგზავნილ snapshot-ს (მაგ. Kraken). ასეთი snapshot ყოველთვის
+// უსწრებს იმავე კავშირის განახლებებს, ამიტომ მანამდე დაგროვილი buffer ძველია.
+func (obm *OrderBookManager) loadSnapshot(symbol string, lastUpdateID int64, bids, asks []marketdata.Level) {
+	book := obm.bookFor(symbol)
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	book.buffer = nil
+	obm.syncLocked(symbol, book, lastUpdateID, bids, asks)
+}
+
+// syncLocked ტვირთავს snapshot-ს და მასზე ადებს დაგროვილ განახლებებს:
+//  1. ვყრით განახლებებს, რომელთა FinalUpdateID <= lastUpdateID (snapshot-ში უკვე შედის);
+//  2. პირველი დარჩენილი უნდა აკმაყოფილებდეს FirstUpdateID <= lastUpdateID+1 <= FinalUpdateID;
+//  3. დანარჩენი თანმიმდევრულად ედება, თითოეული წინას FinalUpdateID+1-დან იწყება.
+//
+// აბრუნებს false-ს, თუ snapshot ბუფერის პირველ განახლებაზე ძველია და ახალია საჭირო.
+// book.mu დაბლოკილი უნდა იყოს.
+func (obm *OrderBookManager) syncLocked(symbol string, book *OrderBook, lastUpdateID int64, bids, asks []marketdata.Level) bool {
+	buffered := book.buffer
+	for len(buffered) > 0 && buffered[0].FinalUpdateID <= lastUpdateID {
+		buffered = buffered[1:]
+	}
+	if len(buffered) > 0 && buffered[0].FirstUpdateID > lastUpdateID+1 {
+		log.Printf("Snapshot for %s (lastUpdateId %d) is older than the first buffered update (U=%d). Retrying...", symbol, lastUpdateID, buffered[0].FirstUpdateID)
+		book.buffer = buffered
+		return false
+	}
+
+	if err := book.reset(lastUpdateID, bids, asks); err != nil {
+		// გაუმართავი snapshot-ის შემდეგ წიგნი ცარიელი რჩება; შემდეგი განახლება ხელახლა ითხოვს მას
+		log.Printf("Error loading snapshot for %s: %v", symbol, err)
+		book.synced = false
+		return true
+	}
+	book.buffer = nil
+	book.synced = true
+
+	for i, update := range buffered {
+		if err := book.apply(update); err != nil {
+			if !errors.Is(err, orderbook.ErrGap) {
+				log.Printf("Dropping malformed %s update: %v", symbol, err)
+				continue
+			}
+			log.Printf("Cannot apply buffered %s update: %v", symbol, err)
+			// ბუფერში ხვრელია; დარჩენილ განახლებებს ვინახავთ და თავიდან ვიწყებთ
+			book.synced = false
+			book.buffer = append([]marketdata.DepthDelta(nil), buffered[i:]...)
+			obm.startFetchLocked(symbol, book)
+			return true
+		}
+	}
+	log.Printf("✅ Snapshot for %s loaded. LastUpdateID: %d (%d buffered updates applied)", symbol, book.LastUpdateID, len(buffered))
+	return true
+}
+
+// handleDepth გაშიფრავს NATS-ის depth შეტყობინებას და ადებს მას, თუ ის ჩვენი ბირჟისაა
+func (obm *OrderBookManager) handleDepth(msg *nats.Msg) {
+	var update marketdata.DepthDelta
+	if err := codec.Decode(msg, &update); err == nil && update.Exchange == obm.exchange {
+		obm.applyUpdate(update)
+	}
+}
+
+// applyUpdate ადებს depth განახლებას სინქრონიზებულ წიგნზე, ან ინახავს ბუფერში, სანამ snapshot არ მოვა
+func (obm *OrderBookManager) applyUpdate(update marketdata.DepthDelta) {
+	book := obm.bookFor(update.Symbol)
+	book.mu.Lock()
+	defer book.mu.Unlock()
+
+	if !book.synced {
+		book.buffer = append(book.buffer, update)
+		if len(book.buffer) > maxBufferedDeltas {
+			book.buffer = book.buffer[len(book.buffer)-maxBufferedDeltas:]
+		}
+		obm.startFetchLocked(update.Symbol, book)
+		return
+	}
+
+	err := book.apply(update)
+	if err != nil && !errors.Is(err, orderbook.ErrGap) {
+		log.Printf("Dropping malformed %s update: %v", update.Symbol, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Order book %s is out of sync (%v). Refetching snapshot...", update.Symbol, err)
+		book.synced = false
+		book.buffer = []marketdata.DepthDelta{update}
+		obm.startFetchLocked(update.Symbol, book)
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"rdr/common/codec"
+	"rdr/common/marketdata"
+	"rdr/common/orderbook"
+	"rdr/common/symbols"
+)
+
+func init() {
+	snapshotRetryDelay = 10 * time.Millisecond
+}
+
+// recordedDeltas კითხულობს ჩაწერილ BTCUSDT depth ნაკადს (U/u: 95-99, 100-104, 105-110, 111-115, 120-125, 126-130)
+func recordedDeltas(t *testing.T) []marketdata.DepthDelta {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", "depth_btcusdt.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var deltas []marketdata.DepthDelta
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var d marketdata.DepthDelta
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			t.Fatal(err)
+		}
+		deltas = append(deltas, d)
+	}
+	return deltas
+}
+
+// fakeBinance ემსახურება /api/v3/depth-ს snapshots-ის მიმდევრობით; gate-ის დახურვამდე პასუხს აყოვნებს
+type fakeBinance struct {
+	mu        sync.Mutex
+	snapshots []string
+	requests  int
+	gate      chan struct{}
+}
+
+func (f *fakeBinance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.gate != nil {
+		<-f.gate
+	}
+	f.mu.Lock()
+	name := f.snapshots[min(f.requests, len(f.snapshots)-1)]
+	f.requests++
+	f.mu.Unlock()
+	http.ServeFile(w, r, filepath.Join("testdata", name))
+}
+
+func (f *fakeBinance) requestCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+func newSyncManager(t *testing.T, venue *fakeBinance) *OrderBookManager {
+	srv := httptest.NewServer(venue)
+	t.Cleanup(srv.Close)
+	return &OrderBookManager{books: make(map[string]*OrderBook), exchange: "binance", restURL: srv.URL, registry: symbols.NewRegistry(nil)}
+}
+
+// waitSynced ელოდება, სანამ წიგნი lastUpdateID-მდე სინქრონიზდება
+func waitSynced(t *testing.T, obm *OrderBookManager, lastUpdateID int64) *OrderBook {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		book := obm.getBook("BTCUSDT")
+		book.mu.RLock()
+		done := book.synced && book.LastUpdateID == lastUpdateID
+		book.mu.RUnlock()
+		if done {
+			return book
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	book := obm.getBook("BTCUSDT")
+	t.Fatalf("book not synced at %d: synced=%v lastUpdateId=%d buffered=%d", lastUpdateID, book.synced, book.LastUpdateID, len(book.buffer))
+	return nil
+}
+
+func levelStrings(levels []orderbook.Level) []string {
+	out := make([]string, len(levels))
+	for i, l := range levels {
+		out[i] = l.Price.String() + "x" + l.Quantity.String()
+	}
+	return out
+}
+
+func checkLevels(t *testing.T, book *OrderBook, bids, asks []string) {
+	t.Helper()
+	book.mu.RLock()
+	defer book.mu.RUnlock()
+	if got := levelStrings(book.Bids.Top(10)); !slices.Equal(got, bids) {
+		t.Errorf("bids = %v, want %v", got, bids)
+	}
+	if got := levelStrings(book.Asks.Top(10)); !slices.Equal(got, asks) {
+		t.Errorf("asks = %v, want %v", got, asks)
+	}
+}
+
+func TestBufferedSync(t *testing.T) {
+	deltas := recordedDeltas(t)
+	venue := &fakeBinance{snapshots: []string{"snapshot_102.json"}, gate: make(chan struct{})}
+	obm := newSyncManager(t, venue)
+
+	// snapshot-ის პასუხამდე მოსული განახლებები ბუფერში გროვდება
+	for _, d := range deltas[:3] {
+		obm.applyUpdate(d)
+	}
+	if book := obm.getBook("BTCUSDT"); book.synced || len(book.buffer) != 3 {
+		t.Fatalf("before the snapshot: synced=%v buffered=%d, want 3 buffered", book.synced, len(book.buffer))
+	}
+	close(venue.gate)
+
+	// 95-99 snapshot-ში უკვე შედის და იყრება; 100-104 ფარავს 103-ს და ედება; 105-110 მას აგრძელებს
+	book := waitSynced(t, obm, 110)
+	checkLevels(t, book,
+		[]string{"37000.5x0.7", "37000x1.5", "36999x2"},
+		[]string{"37001.5x3", "37002x2"})
+
+	obm.applyUpdate(deltas[3])
+	book = waitSynced(t, obm, 115)
+	checkLevels(t, book,
+		[]string{"37000.5x0.7", "37000x1.5", "36999x2"},
+		[]string{"37001.5x3", "37002x2.5"})
+	if n := venue.requestCount(); n != 1 {
+		t.Errorf("%d snapshot requests, want 1", n)
+	}
+}
+
+func TestSnapshotOlderThanBuffer(t *testing.T) {
+	deltas := recordedDeltas(t)
+	// პირველი snapshot (102) ბუფერის პირველ განახლებაზე (U=105) ძველია, ამიტომ მეორე (122) ითხოვება
+	venue := &fakeBinance{snapshots: []string{"snapshot_102.json", "snapshot_122.json"}, gate: make(chan struct{})}
+	obm := newSyncManager(t, venue)
+	obm.applyUpdate(deltas[2])
+	obm.applyUpdate(deltas[3])
+	close(venue.gate)
+
+	// 105-110 და 111-115 122-ში შედის; 120-125 ფარავს 123-ს
+	waitSynced(t, obm, 122)
+	obm.applyUpdate(deltas[4])
+	obm.applyUpdate(deltas[5])
+	book := waitSynced(t, obm, 130)
+	checkLevels(t, book,
+		[]string{"37000.5x0.7", "37000x1.5", "36998x4"},
+		[]string{"37001.5x3", "37002x2.5"})
+	if n := venue.requestCount(); n != 2 {
+		t.Errorf("%d snapshot requests, want 2", n)
+	}
+}
+
+func TestGapResync(t *testing.T) {
+	deltas := recordedDeltas(t)
+	venue := &fakeBinance{snapshots: []string{"snapshot_102.json", "snapshot_122.json"}}
+	obm := newSyncManager(t, venue)
+	obm.applyUpdate(deltas[1])
+	waitSynced(t, obm, 104)
+	obm.applyUpdate(deltas[2])
+	obm.applyUpdate(deltas[3])
+	waitSynced(t, obm, 115)
+
+	// 116-119 დაიკარგა: წიგნი სინქრონიზაციას კარგავს და ახალ snapshot-ს ითხოვს, 120-125 კი ბუფერში რჩება
+	obm.applyUpdate(deltas[4])
+	obm.applyUpdate(deltas[5])
+	book := waitSynced(t, obm, 130)
+	checkLevels(t, book,
+		[]string{"37000.5x0.7", "37000x1.5", "36998x4"},
+		[]string{"37001.5x3", "37002x2.5"})
+	if n := venue.requestCount(); n != 2 {
+		t.Errorf("%d snapshot requests, want 2", n)
+	}
+}
+
+// fakeIngestor პასუხობს resync მოთხოვნებს და, ბირჟის მსგავსად, BTCUSDT-ის ახალ snapshot-ს აგზავნის
+// snapshots-ის მიმდევრობით; ისინი რომ ამოიწურება, snapshot აღარ მოდის
+type fakeIngestor struct {
+	obm       *OrderBookManager
+	mu        sync.Mutex
+	snapshots []int64
+	requests  []string
+}
+
+func (f *fakeIngestor) Request(subject string, data []byte, timeout time.Duration) (*nats.Msg, error) {
+	f.mu.Lock()
+	f.requests = append(f.requests, subject+" "+string(data))
+	next := int64(-1)
+	if len(f.snapshots) > 0 {
+		next, f.snapshots = f.snapshots[0], f.snapshots[1:]
+	}
+	f.mu.Unlock()
+	if next >= 0 {
+		f.obm.loadSnapshot("BTCUSDT", next, []marketdata.Level{{"37000", "1"}}, []marketdata.Level{{"37001", "1"}})
+	}
+	return &nats.Msg{Data: []byte(`{"ok":true}`)}, nil
+}
+
+func (f *fakeIngestor) requestLog() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.requests)
+}
+
+func newKrakenManager() (*OrderBookManager, *fakeIngestor) {
+	obm := &OrderBookManager{books: make(map[string]*OrderBook), exchange: "kraken", registry: symbols.NewRegistry(nil)}
+	ingestor := &fakeIngestor{obm: obm}
+	obm.control = ingestor
+	return obm, ingestor
+}
+
+// ნაკადში მოსული snapshot (მაგ. Kraken) მანამდე დაგროვილ ბუფერს ძველად თვლის
+func TestStreamedSnapshot(t *testing.T) {
+	obm, _ := newKrakenManager()
+	obm.applyUpdate(marketdata.DepthDelta{Symbol: "BTCUSDT", FirstUpdateID: 7, FinalUpdateID: 7, Bids: []marketdata.Level{{"1", "1"}}})
+	obm.loadSnapshot("BTCUSDT", 1, []marketdata.Level{{"37000", "1"}}, []marketdata.Level{{"37001", "1"}})
+	obm.applyUpdate(marketdata.DepthDelta{Symbol: "BTCUSDT", FirstUpdateID: 2, FinalUpdateID: 2, Asks: []marketdata.Level{{"37001", "0"}}})
+
+	book := waitSynced(t, obm, 2)
+	checkLevels(t, book, []string{"37000x1"}, []string{})
+}
+
+// მოძველებული განახლება წიგნს არ ცვლის, ამიტომ შემდეგ diff-ში არც მისი ფასები უნდა მოხვდეს
+func TestStaleUpdateNotDirty(t *testing.T) {
+	book := &OrderBook{Book: orderbook.NewBook(symbols.NewRegistry(nil).Precision("BTCUSDT"))}
+	if err := book.reset(100, []marketdata.Level{{"37000", "1"}}, []marketdata.Level{{"37001", "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	stale := marketdata.DepthDelta{Symbol: "BTCUSDT", FirstUpdateID: 95, FinalUpdateID: 100, Bids: []marketdata.Level{{"36999", "2"}}}
+	if err := book.apply(stale); err != nil {
+		t.Fatal(err)
+	}
+	if len(book.dirtyBids) != 0 {
+		t.Errorf("stale update marked %d bids dirty", len(book.dirtyBids))
+	}
+
+	fresh := marketdata.DepthDelta{Symbol: "BTCUSDT", FirstUpdateID: 99, FinalUpdateID: 101, Bids: []marketdata.Level{{"36999", "2"}}, Asks: []marketdata.Level{{"37001", "0"}}}
+	if err := book.apply(fresh); err != nil {
+		t.Fatal(err)
+	}
+	if len(book.dirtyBids) != 1 || len(book.dirtyAsks) != 1 {
+		t.Errorf("applied update marked %d bids and %d asks dirty, want 1 each", len(book.dirtyBids), len(book.dirtyAsks))
+	}
+}
+
+// depth შეტყობინების გაშიფვრა და წიგნზე დადება handleDepth-ით: codec-ის გარეშე გაგზავნილი JSON
+// (Rdr-Codec სათაურის გარეშე) ძველი json.Unmarshal გზაა, რომელსაც codec-ები ედრება
+func benchmarkHandleDepth(b *testing.B, c codec.Codec) {
+	obm := newTestManager(b, 5000)
+	update := marketdata.DepthDelta{Exchange: "binance", Symbol: "BTCUSDT", FirstUpdateID: 2, FinalUpdateID: 2}
+	for i := range 20 {
+		update.Bids = append(update.Bids, marketdata.Level{fmt.Sprintf("59999.%02d", 99-i), "0.75"})
+		update.Asks = append(update.Asks, marketdata.Level{fmt.Sprintf("60000.%02d", i), "0"})
+	}
+	msg := nats.NewMsg(marketdata.DepthSubjects)
+	if c == nil {
+		data, err := json.Marshal(update)
+		if err != nil {
+			b.Fatal(err)
+		}
+		msg.Data = data
+	} else {
+		var err error
+		if msg, err = codec.NewMsg(marketdata.DepthSubjects, c, update); err != nil {
+			b.Fatal(err)
+		}
+	}
+	book := obm.getBook("BTCUSDT")
+	b.SetBytes(int64(len(msg.Data)))
+	b.ReportAllocs()
+	for b.Loop() {
+		// ერთი და იგივე განახლება ყოველ ჯერზე ახალია
+		book.mu.Lock()
+		book.LastUpdateID = 1
+		book.mu.Unlock()
+		obm.handleDepth(msg)
+	}
+	if book.LastUpdateID != 2 {
+		b.Fatalf("update not applied: LastUpdateID %d", book.LastUpdateID)
+	}
+}
+
+func BenchmarkHandleDepthLegacyJSON(b *testing.B) { benchmarkHandleDepth(b, nil) }
+func BenchmarkHandleDepthJSON(b *testing.B)       { benchmarkHandleDepth(b, codec.JSON) }
+func BenchmarkHandleDepthMsgPack(b *testing.B)    { benchmarkHandleDepth(b, codec.MsgPack) }
+
+// snapshot-ს ნაკადში აგზავნილი ბირჟის წიგნი სინქრონიზაციას ingestor-ის resync მოთხოვნით აღადგენს:
+// გადატვირთვის შემდეგაც და ხვრელის შემდეგაც
+func TestStreamedSnapshotResync(t *testing.T) {
+	obm, ingestor := newKrakenManager()
+	ingestor.snapshots = []int64{1, 10}
+	update := func(id int64) marketdata.DepthDelta {
+		return marketdata.DepthDelta{Exchange: "kraken", Symbol: "BTCUSDT", FirstUpdateID: id, FinalUpdateID: id, Bids: []marketdata.Level{{"36999", "1"}}}
+	}
+
+	// გადატვირთვის შემდეგ snapshot არ გვაქვს: პირველივე განახლება resync-ს ითხოვს
+	obm.applyUpdate(update(5))
+	waitSynced(t, obm, 1)
+	obm.applyUpdate(update(2))
+	waitSynced(t, obm, 2)
+
+	// 3 დაიკარგა: წიგნი სინქრონიზაციას კარგავს და ახალ snapshot-ს ითხოვს
+	obm.applyUpdate(update(4))
+	book := waitSynced(t, obm, 10)
+	checkLevels(t, book, []string{"37000x1"}, []string{"37001x1"})
+
+	want := ingestorResyncSubject + ` {"symbols":["BTCUSDT"]}`
+	if got := ingestor.requestLog(); len(got) != 2 || got[0] != want || got[1] != want {
+		t.Errorf("resync requests = %q, want two of %q", got, want)
+	}
+}
+
+// snapshot-ის მოლოდინში resync მეორდება, განახლებები რომც აღარ მოდიოდეს
+func TestStreamedSnapshotResyncRetries(t *testing.T) {
+	obm, ingestor := newKrakenManager()
+	obm.resync("BTCUSDT")
+	deadline := time.Now().Add(5 * time.Second)
+	for len(ingestor.requestLog()) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d resync requests while waiting for a snapshot, want repeated ones", len(ingestor.requestLog()))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	ingestor.mu.Lock()
+	ingestor.snapshots = []int64{1}
+	ingestor.mu.Unlock()
+	waitSynced(t, obm, 1)
+}
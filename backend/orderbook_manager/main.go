@@ -1,221 +1,250 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
-	"os/signal"
-	"sort"
 	"strconv"
 	"strings" // <-- ეს ხაზი დაბრუნებულია
-	"sync"
-	"syscall"
 	"time"
 
 	"github.com/nats-io/nats.go"
-)
 
-// --- კონფიგურაცია ---
-var symbols = []string{"BTCUSDT", "ETHUSDT", "SOLUSDT"}
+	"rdr/common/codec"
+	"rdr/common/config"
+	"rdr/common/decimal"
+	"rdr/common/lifecycle"
+	"rdr/common/marketdata"
+	"rdr/common/orderbook"
+	"rdr/common/symbols"
+)
 
 // --- მონაცემთა სტრუქტურები ---
-type OrderLevel [2]string
-
+// Binance-ის REST snapshot-ის პასუხი (/api/v3/depth)
 type OrderBookSnapshot struct {
-	LastUpdateID int64        `json:"lastUpdateId"`
-	Bids         []OrderLevel `json:"bids"`
-	Asks         []OrderLevel `json:"asks"`
-}
-
-type DepthUpdate struct {
-	EventType     string       `json:"e"`
-	EventTime     int64        `json:"E"`
-	Symbol        string       `json:"s"`
-	FirstUpdateID int64        `json:"U"`
-	FinalUpdateID int64        `json:"u"`
-	Bids          []OrderLevel `json:"b"`
-	Asks          []OrderLevel `json:"a"`
-}
-
-// --- Order Book-ის მენეჯერი ---
-type OrderBook struct {
-	Bids         map[string]float64
-	Asks         map[string]float64
-	LastUpdateID int64
-	mu           sync.RWMutex
-}
-type OrderBookManager struct {
-	books map[string]*OrderBook
-	mu    sync.RWMutex
-}
-
-type SortedLevel struct {
-	Price  float64 `json:"price"`
-	Amount float64 `json:"amount"`
-}
-
-func (obm *OrderBookManager) getBook(symbol string) *OrderBook {
-	obm.mu.RLock()
-	defer obm.mu.RUnlock()
-	return obm.books[symbol]
-}
-
-func (obm *OrderBookManager) fetchSnapshot(symbol string) {
-	log.Printf("Fetching snapshot for %s...", symbol)
-	url := fmt.Sprintf("https://api.binance.com/api/v3/depth?symbol=%s&limit=1000", symbol)
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Printf("Error fetching snapshot for %s: %v", symbol, err)
-		return
-	}
-	defer resp.Body.Close()
-
-	var snapshot OrderBookSnapshot
-	if err := json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
-		log.Printf("Error decoding snapshot for %s: %v", symbol, err)
-		return
-	}
-
-	bids := make(map[string]float64)
-	asks := make(map[string]float64)
-	for _, bid := range snapshot.Bids {
-		qty, _ := strconv.ParseFloat(bid[1], 64)
-		bids[bid[0]] = qty
-	}
-	for _, ask := range snapshot.Asks {
-		qty, _ := strconv.ParseFloat(ask[1], 64)
-		asks[ask[0]] = qty
-	}
-
-	obm.mu.Lock()
-	obm.books[symbol] = &OrderBook{Bids: bids, Asks: asks, LastUpdateID: snapshot.LastUpdateID}
-	obm.mu.Unlock()
-	log.Printf("✅ Initial snapshot for %s loaded. LastUpdateID: %d", symbol, snapshot.LastUpdateID)
+	LastUpdateID int64              `json:"lastUpdateId"`
+	Bids         []marketdata.Level `json:"bids"`
+	Asks         []marketdata.Level `json:"asks"`
 }
 
-func (obm *OrderBookManager) applyUpdate(update DepthUpdate) {
-	book := obm.getBook(update.Symbol)
-	if book == nil {
-		return
-	}
-
-	book.mu.Lock()
-	defer book.mu.Unlock()
-
-	if update.FinalUpdateID <= book.LastUpdateID {
-		return
-	}
-	if update.FirstUpdateID > book.LastUpdateID+1 {
-		log.Printf("Gap detected in %s order book. Refetching snapshot...", update.Symbol)
-		go obm.fetchSnapshot(update.Symbol)
-		return
-	}
+// GET /orderbook-ის სიღრმის შეზღუდვები
+const (
+	defaultDepth = 20
+	maxDepth     = 1000
+)
 
-	updateLevels := func(levels map[string]float64, updates []OrderLevel) {
-		for _, u := range updates {
-			qty, _ := strconv.ParseFloat(u[1], 64)
-			if qty == 0 {
-				delete(levels, u[0])
-			} else {
-				levels[u[0]] = qty
-			}
-		}
-	}
-	updateLevels(book.Bids, update.Bids)
-	updateLevels(book.Asks, update.Asks)
-	book.LastUpdateID = update.FinalUpdateID
+// OrderBookResponse არის GET /orderbook-ის პასუხი. დონეები ჯგუფდება group სიგანის ფასის
+// კალათებში (bid-ები ქვემოთ, ask-ები ზემოთ მრგვალდება), total არის კუმულატიური რაოდენობა.
+type OrderBookResponse struct {
+	Symbol       string                      `json:"symbol"`
+	Bids         []orderbook.AggregatedLevel `json:"bids"`
+	Asks         []orderbook.AggregatedLevel `json:"asks"`
+	LastUpdateID int64                       `json:"lastUpdateId"`
+	Depth        int                         `json:"depth"`
+	Group        decimal.Decimal             `json:"group"`
+	// Imbalance = (bid - ask) / (bid + ask) დაბრუნებულ სიღრმეზე; მხოლოდ ?imbalance=true-ზე
+	Imbalance *float64 `json:"imbalance,omitempty"`
 }
 
+// getOrderBookHandler: GET /orderbook?symbol=BTCUSDT&depth=50&group=0.5&imbalance=true
 func (obm *OrderBookManager) getOrderBookHandler(w http.ResponseWriter, r *http.Request) {
-	symbol := strings.ToUpper(r.URL.Query().Get("symbol"))
+	query := r.URL.Query()
+	symbol := strings.ToUpper(query.Get("symbol"))
 	if symbol == "" {
 		http.Error(w, "Missing symbol parameter", http.StatusBadRequest)
 		return
 	}
 
+	depth := defaultDepth
+	if v := query.Get("depth"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxDepth {
+			http.Error(w, fmt.Sprintf("depth must be between 1 and %d", maxDepth), http.StatusBadRequest)
+			return
+		}
+		depth = n
+	}
+	var group decimal.Decimal
+	if v := query.Get("group"); v != "" {
+		g, err := decimal.Parse(v)
+		if err != nil || g.Sign() < 0 {
+			http.Error(w, "Invalid group parameter", http.StatusBadRequest)
+			return
+		}
+		group = g
+	}
+	withImbalance := false
+	if v := query.Get("imbalance"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "Invalid imbalance parameter", http.StatusBadRequest)
+			return
+		}
+		withImbalance = b
+	}
+
 	book := obm.getBook(symbol)
 	if book == nil {
 		http.NotFound(w, r)
 		return
 	}
 
+	// პასუხი ბლოკის ქვეშ იწყობა, კლიენტს კი ბლოკის გარეშე იწერება, რომ ნელმა მკითხველმა
+	// წიგნის განახლება არ შეაჩეროს
 	book.mu.RLock()
-	defer book.mu.RUnlock()
-
-	bids := make([]SortedLevel, 0, len(book.Bids))
-	asks := make([]SortedLevel, 0, len(book.Asks))
-	for p, a := range book.Bids {
-		price, _ := strconv.ParseFloat(p, 64)
-		bids = append(bids, SortedLevel{Price: price, Amount: a})
-	}
-	for p, a := range book.Asks {
-		price, _ := strconv.ParseFloat(p, 64)
-		asks = append(asks, SortedLevel{Price: price, Amount: a})
+	if !book.synced {
+		book.mu.RUnlock()
+		http.Error(w, "Order book is syncing", http.StatusServiceUnavailable)
+		return
 	}
-
-	sort.Slice(bids, func(i, j int) bool { return bids[i].Price > bids[j].Price })
-	sort.Slice(asks, func(i, j int) bool { return asks[i].Price < asks[j].Price })
-
-	if len(bids) > 20 {
-		bids = bids[:20]
+	// კალათა tick-ის ჯერადი უნდა იყოს, თორემ კალათის ფასი ვერ იქნება რეალური ფასის დონე
+	if tick := book.Precision.Tick; group > 0 && tick > 0 && group.Floor(tick) != group {
+		book.mu.RUnlock()
+		http.Error(w, fmt.Sprintf("group must be a multiple of the tick size %s", tick), http.StatusBadRequest)
+		return
 	}
-	if len(asks) > 20 {
-		asks = asks[:20]
+	response := OrderBookResponse{
+		Symbol:       symbol,
+		Bids:         book.Bids.Aggregate(depth, group),
+		Asks:         book.Asks.Aggregate(depth, group),
+		LastUpdateID: book.LastUpdateID,
+		Depth:        depth,
+		Group:        group,
 	}
+	book.mu.RUnlock()
 
-	response := map[string]interface{}{"bids": bids, "asks": asks, "lastUpdateId": book.LastUpdateID}
+	if withImbalance {
+		imbalance := orderbook.Imbalance(response.Bids, response.Asks)
+		response.Imbalance = &imbalance
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	json.NewEncoder(w).Encode(response)
 }
 
+// loadSymbols განაახლებს სიმბოლოების რეესტრს; უკვე შექმნილი წიგნები თავიანთ სიზუსტეს ინარჩუნებენ
+func (obm *OrderBookManager) loadSymbols(cfg config.Config) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	infos, err := symbols.Load(ctx, cfg)
+	if err != nil {
+		log.Printf("Unable to load symbol metadata: %v", err)
+		return
+	}
+	obm.registry.Set(infos)
+	log.Printf("Loaded metadata for %d symbols.", len(infos))
+}
 
 func main() {
-	obm := &OrderBookManager{books: make(map[string]*OrderBook)}
-	for _, s := range symbols {
-		go obm.fetchSnapshot(s)
-	}
+	// SIGINT/SIGTERM აუქმებს ctx-ს; გაჩერება იწყება ქვემოთ, <-ctx.Done()-ის შემდეგ
+	ctx, stop := lifecycle.Context()
+	defer stop()
+
+	// კონფიგურაცია საერთოა ყველა სერვისისთვის (config.yaml + RDR_* გარემოს ცვლადები)
+	cfg := config.MustLoad()
+	log.Printf("Market universe: symbols=%v intervals=%v (fingerprint %s)", cfg.Market.Symbols, cfg.Market.KlineIntervals, cfg.Market.Fingerprint())
 
-	natsURL := "nats://nats:4222"
-	nc, err := nats.Connect(natsURL)
+	// წიგნები ზარმაცად იქმნება პირველ depth განახლებაზე: განახლებები ბუფერში გროვდება და
+	// snapshot მხოლოდ ამის შემდეგ ითხოვება, რომ მასსა და ნაკადს შორის არაფერი დაიკარგოს
+	obm := &OrderBookManager{books: make(map[string]*OrderBook), exchange: cfg.Ingestor.Exchange, restURL: cfg.Binance.RestURL, registry: symbols.NewRegistry(cfg.Market.Precision)}
+
+	// სიმბოლოების მეტამონაცემები საჭიროა წიგნების შექმნამდე, რომ დონეები სწორ tick-ზე დამრგვალდეს;
+	// Binance-ის მიუწვდომლობისას ქეშირებული exchangeInfo ფაილი გამოიყენება
+	obm.loadSymbols(cfg)
+	go func() {
+		for range time.Tick(cfg.Metadata.RefreshInterval) {
+			obm.loadSymbols(cfg)
+		}
+	}()
+
+	nc, err := nats.Connect(cfg.NATS.URL)
 	if err != nil {
 		log.Fatalf("NATS connect error: %v\n", err)
 	}
-	defer nc.Close()
 	log.Println("✅ Orderbook Manager service connected to NATS server.")
 
-	nc.Subscribe("depth.*", func(msg *nats.Msg) {
-		var update DepthUpdate
-		if err := json.Unmarshal(msg.Data, &update); err == nil {
-			obm.applyUpdate(update)
+	obm.control = nc
+	nc.Subscribe(marketdata.DepthSubjects, obm.handleDepth)
+
+	// ბირჟები, რომლებიც snapshot-ს ნაკადში აგზავნიან (მაგ. Kraken)
+	nc.Subscribe(marketdata.BookSnapshotSubjects, func(msg *nats.Msg) {
+		var snapshot marketdata.BookSnapshot
+		if err := codec.Decode(msg, &snapshot); err == nil && snapshot.Exchange == obm.exchange {
+			obm.loadSnapshot(snapshot.Symbol, snapshot.LastUpdateID, snapshot.Bids, snapshot.Asks)
 		}
 	})
 
+	// ingestor-ის depth ნაკადი ხელახლა დაუკავშირდა — შუალედში განახლებები დაიკარგა, ამიტომ snapshot-ს თავიდან ვიღებთ
+	nc.Subscribe(marketdata.ReconnectSubjects, func(msg *nats.Msg) {
+		var event marketdata.ReconnectEvent
+		if err := json.Unmarshal(msg.Data, &event); err != nil {
+			log.Printf("Error decoding reconnect event: %v", err)
+			return
+		}
+		if event.Kind != "depth" {
+			return
+		}
+		symbol := strings.ToUpper(event.Symbol)
+		log.Printf("Depth stream for %s reconnected after %dms. Resyncing order book...", symbol, event.DowntimeMs)
+		go obm.resync(symbol)
+	})
+
+	// წიგნების snapshot-ები და diff-ები NATS-ზე (api მათ websocket-ით აგზავნის)
+	payloadCodec, err := codec.ByName(cfg.NATS.Codec)
+	if err != nil {
+		log.Fatalf("Codec error: %v\n", err)
+	}
+	publisher := &bookPublisher{nc: nc, codec: payloadCodec, cfg: cfg.OrderBook.Publish, obm: obm}
+	publisherDone := make(chan struct{})
+	go func() {
+		defer close(publisherDone)
+		publisher.run(ctx)
+	}()
+
 	http.HandleFunc("/orderbook", obm.getOrderBookHandler)
+	http.HandleFunc("/orderbook/stats", obm.getStatsHandler)
+	server := &http.Server{Addr: cfg.OrderBook.Addr}
 	go func() {
-		log.Println("✅ Orderbook Manager's API is starting on http://localhost:8081")
-		if err := http.ListenAndServe(":8081", nil); err != nil {
+		log.Printf("✅ Orderbook Manager's API is starting on %s", cfg.OrderBook.Addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Orderbook Manager failed to start server: %v", err)
 		}
 	}()
 
-	// დემონსტრაციისთვის: ყოველ 5 წამში ვბეჭდავთ BTCUSDT-ს Order Book-ის ზომას
+	// დემონსტრაციისთვის: ყოველ 5 წამში ვბეჭდავთ პირველი სიმბოლოს Order Book-ის ზომას
+	statusSymbol := cfg.Market.Symbols[0]
 	go func() {
 		for {
 			time.Sleep(5 * time.Second)
-			book := obm.getBook("BTCUSDT")
+			book := obm.getBook(statusSymbol)
 			if book != nil {
 				book.mu.RLock()
-				log.Printf("[STATUS] BTCUSDT Order Book state: Bids=%d levels, Asks=%d levels, LastUpdateID=%d", len(book.Bids), len(book.Asks), book.LastUpdateID)
+				if book.synced {
+					log.Printf("[STATUS] %s Order Book state: Bids=%d levels, Asks=%d levels, LastUpdateID=%d", statusSymbol, book.Bids.Len(), book.Asks.Len(), book.LastUpdateID)
+				} else {
+					log.Printf("[STATUS] %s Order Book is syncing: %d updates buffered", statusSymbol, len(book.buffer))
+				}
 				book.mu.RUnlock()
 			}
 		}
 	}()
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	<-ctx.Done()
 	log.Println("👋 Orderbook Manager service shutting down...")
-}
\ No newline at end of file
+	shutdown(server, publisherDone, nc)
+	log.Println("Orderbook Manager stopped.")
+}
+
+// shutdown: ჯერ ვწყვეტთ HTTP მოთხოვნების მიღებას და ვასრულებთ მიმდინარეებს, შემდეგ ველოდებით
+// გამოქვეყნების დასრულებას, ბოლოს კი NATS-ის drain-ით ვაგზავნით უკვე გამოქვეყნებულს
+func shutdown(server *http.Server, publisherDone <-chan struct{}, nc *nats.Conn) {
+	if err := lifecycle.ShutdownServer(server); err != nil {
+		log.Printf("HTTP server shutdown error: %v", err)
+	}
+	<-publisherDone
+	if err := lifecycle.DrainNATS(nc); err != nil {
+		log.Printf("NATS drain error: %v", err)
+	}
+}
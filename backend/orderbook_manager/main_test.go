@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"rdr/common/decimal"
+	"rdr/common/lifecycle/lifecycletest"
+	"rdr/common/marketdata"
+	"rdr/common/orderbook"
+	"rdr/common/symbols"
+)
+
+// newTestManager აბრუნებს მენეჯერს BTCUSDT-ის სინქრონიზებული წიგნით, levels დონით თითო მხარეს
+func newTestManager(tb testing.TB, levels int) *OrderBookManager {
+	tb.Helper()
+	obm := &OrderBookManager{books: make(map[string]*OrderBook), exchange: "binance", registry: symbols.NewRegistry(nil)}
+	bids := make([]marketdata.Level, levels)
+	asks := make([]marketdata.Level, levels)
+	for i := range levels {
+		bids[i] = marketdata.Level{fmt.Sprintf("%d.%02d", 59999-i/100, 99-i%100), "0.5"}
+		asks[i] = marketdata.Level{fmt.Sprintf("%d.%02d", 60000+i/100, i%100), "0.5"}
+	}
+	book := obm.bookFor("BTCUSDT")
+	if err := book.reset(1, bids, asks); err != nil {
+		tb.Fatal(err)
+	}
+	book.synced = true
+	return obm
+}
+
+func getOrderBook(tb testing.TB, obm *OrderBookManager, query string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	obm.getOrderBookHandler(rec, httptest.NewRequest(http.MethodGet, "/orderbook?"+query, nil))
+	return rec
+}
+
+func TestGetOrderBook(t *testing.T) {
+	obm := newTestManager(t, 5000)
+	rec := getOrderBook(t, obm, "symbol=btcusdt&depth=3")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var response OrderBookResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Bids) != 3 || len(response.Asks) != 3 {
+		t.Fatalf("got %d bids and %d asks, want 3 each", len(response.Bids), len(response.Asks))
+	}
+	// საუკეთესო ფასი პირველია: bid-ები კლებადობით, ask-ები ზრდადობით
+	if got := response.Bids[0].Price.String() + " " + response.Bids[2].Price.String(); got != "59999.99 59999.97" {
+		t.Errorf("bids = %s, want 59999.99 down to 59999.97", got)
+	}
+	if got := response.Asks[0].Price.String() + " " + response.Asks[2].Price.String(); got != "60000 60000.02" {
+		t.Errorf("asks = %s, want 60000 up to 60000.02", got)
+	}
+
+	obm.getBook("BTCUSDT").synced = false
+	if rec := getOrderBook(t, obm, "symbol=BTCUSDT"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("syncing book: status %d, want 503", rec.Code)
+	}
+	if rec := getOrderBook(t, obm, "symbol=ETHUSDT"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown book: status %d, want 404", rec.Code)
+	}
+}
+
+func TestGetOrderBookParams(t *testing.T) {
+	obm := newTestManager(t, 5000)
+	obm.getBook("BTCUSDT").Precision.Tick = decimal.MustParse("0.01")
+	tests := []struct {
+		query  string
+		status int
+		// დაბრუნებული bid-ები: ფასი x რაოდენობა = კუმულატიური ჯამი
+		bids string
+	}{
+		{"symbol=BTCUSDT", http.StatusOK, ""},
+		{"symbol=BTCUSDT&depth=1000", http.StatusOK, ""},
+		{"symbol=BTCUSDT&depth=2&group=0.05", http.StatusOK, "59999.95x2.5=2.5 59999.9x2.5=5"},
+		{"symbol=BTCUSDT&depth=2&group=1", http.StatusOK, "59999x50=50 59998x50=100"},
+		{"symbol=BTCUSDT&depth=1&group=0", http.StatusOK, "59999.99x0.5=0.5"},
+		{"symbol=BTCUSDT&depth=1&imbalance=true", http.StatusOK, ""},
+		{"", http.StatusBadRequest, ""},
+		{"symbol=BTCUSDT&depth=0", http.StatusBadRequest, ""},
+		{"symbol=BTCUSDT&depth=-5", http.StatusBadRequest, ""},
+		{"symbol=BTCUSDT&depth=1001", http.StatusBadRequest, ""},
+		{"symbol=BTCUSDT&depth=ten", http.StatusBadRequest, ""},
+		{"symbol=BTCUSDT&group=-1", http.StatusBadRequest, ""},
+		{"symbol=BTCUSDT&group=abc", http.StatusBadRequest, ""},
+		// tick-ის ჯერადი არაა
+		{"symbol=BTCUSDT&group=0.015", http.StatusBadRequest, ""},
+		{"symbol=BTCUSDT&imbalance=maybe", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		rec := getOrderBook(t, obm, tt.query)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.query, rec.Code, tt.status, rec.Body)
+			continue
+		}
+		if rec.Code != http.StatusOK {
+			continue
+		}
+		var response OrderBookResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Bids) != response.Depth || len(response.Asks) != response.Depth {
+			t.Errorf("%s: got %d bids and %d asks at depth %d", tt.query, len(response.Bids), len(response.Asks), response.Depth)
+		}
+		if tt.bids != "" && bucketList(response.Bids) != tt.bids {
+			t.Errorf("%s: bids = %s, want %s", tt.query, bucketList(response.Bids), tt.bids)
+		}
+		if (response.Imbalance != nil) != strings.Contains(tt.query, "imbalance=true") {
+			t.Errorf("%s: imbalance = %v", tt.query, response.Imbalance)
+		}
+	}
+	if rec := getOrderBook(t, obm, "symbol=BTCUSDT"); !strings.Contains(rec.Body.String(), `"depth":20`) {
+		t.Errorf("default depth: %s", rec.Body)
+	}
+}
+
+// blockingWriter აჩერებს პასუხის ჩაწერას, სანამ release არ დაიხურება, ნელი კლიენტის მსგავსად
+type blockingWriter struct {
+	http.ResponseWriter
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	close(w.writing)
+	<-w.release
+	return w.ResponseWriter.Write(p)
+}
+
+// ნელი კლიენტი წიგნის ბლოკს არ იკავებს: განახლება პასუხის ჩაწერის დროსაც გადის
+func TestGetOrderBookSlowClient(t *testing.T) {
+	obm := newTestManager(t, 5000)
+	w := &blockingWriter{ResponseWriter: httptest.NewRecorder(), writing: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		obm.getOrderBookHandler(w, httptest.NewRequest(http.MethodGet, "/orderbook?symbol=BTCUSDT&depth=1000", nil))
+		close(done)
+	}()
+	<-w.writing
+
+	book := obm.getBook("BTCUSDT")
+	locked := make(chan struct{})
+	go func() {
+		book.mu.Lock()
+		book.mu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(2 * time.Second):
+		t.Error("book stayed locked while the response was written")
+	}
+	close(w.release)
+	<-done
+}
+
+func bucketList(levels []orderbook.AggregatedLevel) string {
+	out := make([]string, len(levels))
+	for i, l := range levels {
+		out[i] = fmt.Sprintf("%sx%s=%s", l.Price, l.Quantity, l.Total)
+	}
+	return strings.Join(out, " ")
+}
+
+// GET /orderbook-ის დაყოვნება სრულ, 5000-დონიან წიგნზე
+func benchmarkGetOrderBook(b *testing.B, query string) {
+	obm := newTestManager(b, 5000)
+	b.ReportAllocs()
+	for b.Loop() {
+		if rec := getOrderBook(b, obm, query); rec.Code != http.StatusOK {
+			b.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+	}
+}
+
+func BenchmarkGetOrderBookDepth20(b *testing.B) {
+	benchmarkGetOrderBook(b, "symbol=BTCUSDT&depth=20")
+}
+func BenchmarkGetOrderBookDepth1000(b *testing.B) {
+	benchmarkGetOrderBook(b, "symbol=BTCUSDT&depth=1000")
+}
+func BenchmarkGetOrderBookGrouped(b *testing.B) {
+	benchmarkGetOrderBook(b, "symbol=BTCUSDT&depth=50&group=1")
+}
+
+// გაჩერებისას მიმდინარე /orderbook მოთხოვნა სრულდება, ახალი აღარ მიიღება, NATS კი
+// მხოლოდ გამომქვეყნებლის დასრულების შემდეგ იხურება
+func TestShutdownFinishesRequestsBeforeDrainingNATS(t *testing.T) {
+	fake := lifecycletest.NewFakeNATS(t)
+	nc, err := nats.Connect(fake.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obm := newTestManager(t, 10)
+	started, release := make(chan struct{}), make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/orderbook", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		obm.getOrderBookHandler(w, r)
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: mux}
+	go server.Serve(l)
+	url := "http://" + l.Addr().String() + "/orderbook?symbol=BTCUSDT&depth=1"
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	response := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			response <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		response <- result{resp.StatusCode, string(body), err}
+	}()
+	<-started
+
+	publisherDone := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		shutdown(server, publisherDone, nc)
+		close(stopped)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	if res := <-response; res.err != nil || res.status != http.StatusOK || !strings.Contains(res.body, "BTCUSDT") {
+		t.Errorf("in-flight request got %d %q, %v; want it to complete", res.status, res.body, res.err)
+	}
+
+	// HTTP სერვერი გაჩერებულია, NATS კი ჯერ ღიაა, სანამ გამომქვეყნებელი მუშაობს
+	time.Sleep(50 * time.Millisecond)
+	if _, err := http.Get(url); err == nil {
+		t.Error("server still accepts requests after shutdown")
+	}
+	if nc.IsClosed() {
+		t.Error("NATS connection closed before the publisher stopped")
+	}
+	close(publisherDone)
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("shutdown did not return after the publisher stopped")
+	}
+	if !nc.IsClosed() {
+		t.Error("NATS connection still open after shutdown")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"rdr/common/codec"
+	"rdr/common/config"
+	"rdr/common/decimal"
+	"rdr/common/orderbook"
+)
+
+// publishTick არის გამოქვეყნების შემოწმების სიხშირე; ყველაზე მცირე cadence-ზე მეტი სიზუსტე არ გვჭირდება
+const publishTick = 50 * time.Millisecond
+
+// msgPublisher არის *nats.Conn-ის ის ნაწილი, რომლითაც bookPublisher აქვეყნებს; ტესტებში ის ჩანაცვლებადია
+type msgPublisher interface {
+	PublishMsg(msg *nats.Msg) error
+}
+
+// bookPublisher აქვეყნებს წიგნებს NATS-ზე: top-N snapshot-ებს orderbook.snapshot.<SYMBOL>-ზე,
+// სრული სიღრმის ცვლილებებს orderbook.diff.<SYMBOL>-ზე და წარმოებულ მეტრიკებს
+// orderbook.stats.<SYMBOL>-ზე, თითოეულს სიმბოლოს cadence-ით. არქივისთვის ღრმა checkpoint-ები
+// orderbook.checkpoint.<SYMBOL>-ზე უფრო იშვიათად ქვეყნდება.
+type bookPublisher struct {
+	nc    msgPublisher
+	codec codec.Codec
+	cfg   config.BookPublishConfig
+	obm   *OrderBookManager
+}
+
+// run აქვეყნებს, სანამ ctx არ გაუქმდება
+func (p *bookPublisher) run(ctx context.Context) {
+	ticker := time.NewTicker(publishTick)
+	defer ticker.Stop()
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		p.obm.mu.RLock()
+		books := make(map[string]*OrderBook, len(p.obm.books))
+		for symbol, book := range p.obm.books {
+			books[symbol] = book
+		}
+		p.obm.mu.RUnlock()
+
+		for symbol, book := range books {
+			p.publishDue(symbol, book, now)
+		}
+	}
+}
+
+// publishDue აქვეყნებს snapshot-ს, diff-ს ან სტატისტიკას, თუ მათი დრო დადგა და წიგნი შეიცვალა
+func (p *bookPublisher) publishDue(symbol string, book *OrderBook, now time.Time) {
+	cadence := p.cfg.Cadence(symbol)
+
+	book.mu.Lock()
+	if !book.synced {
+		book.mu.Unlock()
+		return
+	}
+	var snapshot *orderbook.Snapshot
+	var diff *orderbook.Diff
+	var stats *orderbook.Stats
+	var checkpoint *orderbook.Snapshot
+	if !now.Before(book.nextSnapshot) && book.publishedID != book.LastUpdateID {
+		snapshot = &orderbook.Snapshot{
+			Exchange:     p.obm.exchange,
+			Symbol:       symbol,
+			LastUpdateID: book.LastUpdateID,
+			Bids:         book.Bids.Top(p.cfg.Depth),
+			Asks:         book.Asks.Top(p.cfg.Depth),
+			Time:         now.UnixMilli(),
+		}
+		book.publishedID = book.LastUpdateID
+		book.nextSnapshot = now.Add(cadence.SnapshotInterval)
+	}
+	// diff-ის ჯაჭვი snapshot-ის შემდეგაც არ წყდება: მომხმარებელს prevUpdateId-ით შეუძლია შეამოწმოს უწყვეტობა
+	if !now.Before(book.nextDiff) && book.diffFrom != book.LastUpdateID {
+		diff = &orderbook.Diff{
+			Exchange:     p.obm.exchange,
+			Symbol:       symbol,
+			PrevUpdateID: book.diffFrom,
+			LastUpdateID: book.LastUpdateID,
+			Bids:         dirtyLevels(book.Bids, book.dirtyBids),
+			Asks:         dirtyLevels(book.Asks, book.dirtyAsks),
+			Time:         now.UnixMilli(),
+		}
+		clear(book.dirtyBids)
+		clear(book.dirtyAsks)
+		book.diffFrom = book.LastUpdateID
+		book.nextDiff = now.Add(cadence.DiffInterval)
+	}
+	if !now.Before(book.nextStats) && book.statsID != book.LastUpdateID {
+		if s, ok := p.obm.stats(symbol, book, now); ok {
+			stats = &s
+		}
+		book.statsID = book.LastUpdateID
+		book.nextStats = now.Add(cadence.StatsInterval)
+	}
+	if !now.Before(book.nextCheckpoint) && book.checkpointID != book.LastUpdateID {
+		checkpoint = &orderbook.Snapshot{
+			Exchange:     p.obm.exchange,
+			Symbol:       symbol,
+			LastUpdateID: book.LastUpdateID,
+			Bids:         book.Bids.Top(p.cfg.Checkpoint.Depth),
+			Asks:         book.Asks.Top(p.cfg.Checkpoint.Depth),
+			Time:         now.UnixMilli(),
+		}
+		book.checkpointID = book.LastUpdateID
+		book.nextCheckpoint = now.Add(p.cfg.Checkpoint.Interval)
+	}
+	book.mu.Unlock()
+
+	if snapshot != nil {
+		p.publish(orderbook.SnapshotSubject(symbol), snapshot)
+	}
+	if diff != nil {
+		p.publish(orderbook.DiffSubject(symbol), diff)
+	}
+	if stats != nil {
+		p.publish(orderbook.StatsSubject(symbol), stats)
+	}
+	if checkpoint != nil {
+		p.publish(orderbook.CheckpointSubject(symbol), checkpoint)
+	}
+}
+
+// dirtyLevels აბრუნებს შეცვლილი ფასების ამჟამინდელ რაოდენობებს (0 — დონე წაიშალა)
+func dirtyLevels(side *orderbook.Side, dirty map[decimal.Decimal]struct{}) []orderbook.Level {
+	out := make([]orderbook.Level, 0, len(dirty))
+	for price := range dirty {
+		qty, _ := side.Get(price)
+		out = append(out, orderbook.Level{Price: price, Quantity: qty})
+	}
+	return out
+}
+
+func (p *bookPublisher) publish(subject string, v any) {
+	msg, err := codec.NewMsg(subject, p.codec, v)
+	if err != nil {
+		log.Printf("Error encoding message for subject %s: %v", subject, err)
+		return
+	}
+	if err := p.nc.PublishMsg(msg); err != nil {
+		log.Printf("Error publishing to NATS on subject %s: %v", subject, err)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"rdr/common/codec"
+	"rdr/common/config"
+	"rdr/common/marketdata"
+	"rdr/common/orderbook"
+	"rdr/common/symbols"
+)
+
+// recordingPublisher იმახსოვრებს გამოქვეყნებულ შეტყობინებებს
+type recordingPublisher struct {
+	msgs []*nats.Msg
+}
+
+func (p *recordingPublisher) PublishMsg(msg *nats.Msg) error {
+	p.msgs = append(p.msgs, msg)
+	return nil
+}
+
+// take აბრუნებს ბოლო გამოძახების შემდეგ გამოქვეყნებულ შეტყობინებებს და ასუფთავებს სიას
+func (p *recordingPublisher) take() []*nats.Msg {
+	msgs := p.msgs
+	p.msgs = nil
+	return msgs
+}
+
+func subjects(msgs []*nats.Msg) []string {
+	out := make([]string, len(msgs))
+	for i, msg := range msgs {
+		out[i] = msg.Subject
+	}
+	return out
+}
+
+func decodeMsg[T any](t *testing.T, msg *nats.Msg) T {
+	t.Helper()
+	var v T
+	if err := codec.Decode(msg, &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func newTestPublisher(t *testing.T) (*bookPublisher, *recordingPublisher, *OrderBook) {
+	t.Helper()
+	obm := &OrderBookManager{books: make(map[string]*OrderBook), exchange: "binance", registry: symbols.NewRegistry(nil)}
+	book := obm.bookFor("BTCUSDT")
+	bids := []marketdata.Level{{"100", "1"}, {"99", "2"}, {"98", "3"}}
+	asks := []marketdata.Level{{"101", "1"}, {"102", "2"}, {"103", "3"}}
+	if err := book.reset(100, bids, asks); err != nil {
+		t.Fatal(err)
+	}
+	book.synced = true
+	recorder := &recordingPublisher{}
+	return &bookPublisher{
+		nc:    recorder,
+		codec: codec.JSON,
+		cfg: config.BookPublishConfig{
+			Depth:       2,
+			BookCadence: config.BookCadence{SnapshotInterval: time.Second, DiffInterval: 100 * time.Millisecond, StatsInterval: time.Second},
+			Checkpoint:  config.CheckpointConfig{Interval: time.Minute, Depth: 10},
+		},
+		obm: obm,
+	}, recorder, book
+}
+
+func applyDelta(t *testing.T, book *OrderBook, id int64, bids, asks []marketdata.Level) {
+	t.Helper()
+	if err := book.apply(marketdata.DepthDelta{Symbol: "BTCUSDT", FirstUpdateID: id, FinalUpdateID: id, Bids: bids, Asks: asks}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func levelList(levels []orderbook.Level) string {
+	out := make([]string, len(levels))
+	for i, l := range levels {
+		out[i] = l.Price.String() + "x" + l.Quantity.String()
+	}
+	slices.Sort(out)
+	return strings.Join(out, " ")
+}
+
+func TestPublishDiffCoalesces(t *testing.T) {
+	publisher, recorder, book := newTestPublisher(t)
+	t0 := time.UnixMilli(1_700_000_000_000)
+	publisher.publishDue("BTCUSDT", book, t0)
+	recorder.take()
+
+	// ერთი tick-ის განმავლობაში შეცვლილი დონეები ერთ diff-ში ერთიანდება, თითო ფასი — ბოლო რაოდენობით
+	applyDelta(t, book, 101, []marketdata.Level{{"100", "5"}}, nil)
+	applyDelta(t, book, 102, []marketdata.Level{{"100", "6"}, {"99", "0"}}, []marketdata.Level{{"101.5", "4"}})
+	applyDelta(t, book, 103, []marketdata.Level{{"100", "7"}}, nil)
+	publisher.publishDue("BTCUSDT", book, t0.Add(50*time.Millisecond))
+	msgs := recorder.take()
+	if got := subjects(msgs); !slices.Equal(got, []string{"orderbook.diff.BTCUSDT"}) {
+		t.Fatalf("published %v, want one diff", got)
+	}
+	diff := decodeMsg[orderbook.Diff](t, msgs[0])
+	if diff.PrevUpdateID != 100 || diff.LastUpdateID != 103 {
+		t.Errorf("diff covers %d-%d, want 100-103", diff.PrevUpdateID, diff.LastUpdateID)
+	}
+	if got := levelList(diff.Bids); got != "100x7 99x0" {
+		t.Errorf("diff bids = %s, want 100x7 99x0", got)
+	}
+	if got := levelList(diff.Asks); got != "101.5x4" {
+		t.Errorf("diff asks = %s, want 101.5x4", got)
+	}
+
+	// შემდეგი diff DiffInterval-ის გასვლამდე არ ქვეყნდება და წინას აგრძელებს
+	applyDelta(t, book, 104, nil, []marketdata.Level{{"101", "0"}})
+	publisher.publishDue("BTCUSDT", book, t0.Add(100*time.Millisecond))
+	if msgs := recorder.take(); len(msgs) != 0 {
+		t.Fatalf("published %v before the diff interval", subjects(msgs))
+	}
+	publisher.publishDue("BTCUSDT", book, t0.Add(150*time.Millisecond))
+	msgs = recorder.take()
+	if len(msgs) != 1 {
+		t.Fatalf("published %v, want one diff", subjects(msgs))
+	}
+	if diff := decodeMsg[orderbook.Diff](t, msgs[0]); diff.PrevUpdateID != 103 || diff.LastUpdateID != 104 || levelList(diff.Asks) != "101x0" {
+		t.Errorf("second diff = %+v, want 103-104 removing ask 101", diff)
+	}
+
+	// უცვლელი წიგნი diff-ს აღარ აქვეყნებს
+	publisher.publishDue("BTCUSDT", book, t0.Add(300*time.Millisecond))
+	if msgs := recorder.take(); len(msgs) != 0 {
+		t.Errorf("unchanged book published %v", subjects(msgs))
+	}
+}
+
+func TestPublishSnapshotCadence(t *testing.T) {
+	publisher, recorder, book := newTestPublisher(t)
+	t0 := time.UnixMilli(1_700_000_000_000)
+
+	// სინქრონიზაციის შემდეგ snapshot, სტატისტიკა და checkpoint მაშინვე ქვეყნდება
+	publisher.publishDue("BTCUSDT", book, t0)
+	msgs := recorder.take()
+	want := []string{"orderbook.snapshot.BTCUSDT", "orderbook.stats.BTCUSDT", "orderbook.checkpoint.BTCUSDT"}
+	if got := subjects(msgs); !slices.Equal(got, want) {
+		t.Fatalf("first publication = %v, want %v", got, want)
+	}
+	snapshot := decodeMsg[orderbook.Snapshot](t, msgs[0])
+	if snapshot.LastUpdateID != 100 || levelList(snapshot.Bids) != "100x1 99x2" || levelList(snapshot.Asks) != "101x1 102x2" {
+		t.Errorf("snapshot = %+v, want the top 2 levels at 100", snapshot)
+	}
+	if checkpoint := decodeMsg[orderbook.Snapshot](t, msgs[2]); len(checkpoint.Bids) != 3 || len(checkpoint.Asks) != 3 {
+		t.Errorf("checkpoint has %d bids and %d asks, want the full book", len(checkpoint.Bids), len(checkpoint.Asks))
+	}
+
+	// წიგნი ყოველ 100ms-ში იცვლება, snapshot კი მხოლოდ წამში ერთხელ ქვეყნდება
+	var snapshots []int64
+	for i := range 25 {
+		applyDelta(t, book, int64(101+i), []marketdata.Level{{"100", "2"}}, nil)
+		for _, msg := range recorder.take() {
+			if msg.Subject == "orderbook.snapshot.BTCUSDT" {
+				snapshots = append(snapshots, decodeMsg[orderbook.Snapshot](t, msg).LastUpdateID)
+			}
+		}
+		publisher.publishDue("BTCUSDT", book, t0.Add(time.Duration(i+1)*100*time.Millisecond))
+	}
+	for _, msg := range recorder.take() {
+		if msg.Subject == "orderbook.snapshot.BTCUSDT" {
+			snapshots = append(snapshots, decodeMsg[orderbook.Snapshot](t, msg).LastUpdateID)
+		}
+	}
+	// t0+1s-ზე (update 110) და t0+2s-ზე (update 120)
+	if !slices.Equal(snapshots, []int64{110, 120}) {
+		t.Errorf("snapshots at updates %v, want 110 and 120", snapshots)
+	}
+
+	// 2s-ის შემდეგ შეცვლილი წიგნი შემდეგ snapshot-ში ხვდება, უცვლელის snapshot კი აღარ მეორდება
+	publisher.publishDue("BTCUSDT", book, t0.Add(3*time.Second))
+	if got := subjects(recorder.take()); !slices.Contains(got, "orderbook.snapshot.BTCUSDT") {
+		t.Errorf("changed book published %v at 3s, want a snapshot", got)
+	}
+	publisher.publishDue("BTCUSDT", book, t0.Add(4*time.Second))
+	if got := subjects(recorder.take()); len(got) != 0 {
+		t.Errorf("unchanged book published %v", got)
+	}
+
+	// სიმბოლოს საკუთარი cadence ნაგულისხმევს ცვლის
+	publisher.cfg.Symbols = map[string]config.BookCadence{"BTCUSDT": {SnapshotInterval: 10 * time.Second}}
+	book.nextSnapshot = time.Time{}
+	applyDelta(t, book, 126, []marketdata.Level{{"100", "3"}}, nil)
+	publisher.publishDue("BTCUSDT", book, t0.Add(5*time.Second))
+	applyDelta(t, book, 127, []marketdata.Level{{"100", "4"}}, nil)
+	publisher.publishDue("BTCUSDT", book, t0.Add(7*time.Second))
+	count := 0
+	for _, subject := range subjects(recorder.take()) {
+		if subject == "orderbook.snapshot.BTCUSDT" {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("%d snapshots within the 10s symbol cadence, want 1", count)
+	}
+}
+
+// სინქრონიზაციის მოლოდინში მყოფი წიგნი არაფერს აქვეყნებს
+func TestPublishSkipsUnsyncedBook(t *testing.T) {
+	publisher, recorder, book := newTestPublisher(t)
+	book.synced = false
+	publisher.publishDue("BTCUSDT", book, time.Now())
+	if got := subjects(recorder.take()); len(got) != 0 {
+		t.Errorf("syncing book published %v", got)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"rdr/common/orderbook"
+)
+
+// stats ითვლის წიგნის წარმოებულ მეტრიკებს (mid, spread, microprice, imbalance, სიღრმე bps-ებში).
+// book.mu დაბლოკილი უნდა იყოს.
+func (obm *OrderBookManager) stats(symbol string, book *OrderBook, now time.Time) (orderbook.Stats, bool) {
+	if !book.synced {
+		return orderbook.Stats{}, false
+	}
+	s, ok := book.Stats()
+	s.Exchange, s.Symbol, s.Time = obm.exchange, symbol, now.UnixMilli()
+	return s, ok
+}
+
+// getStatsHandler: GET /orderbook/stats?symbol=BTCUSDT აბრუნებს ერთი წიგნის მეტრიკებს,
+// symbol-ის გარეშე კი ყველა სინქრონიზებული წიგნისას (სიმბოლოების მიხედვით დალაგებულს)
+func (obm *OrderBookManager) getStatsHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if symbol := strings.ToUpper(r.URL.Query().Get("symbol")); symbol != "" {
+		book := obm.getBook(symbol)
+		if book == nil {
+			http.NotFound(w, r)
+			return
+		}
+		book.mu.RLock()
+		s, ok := obm.stats(symbol, book, now)
+		book.mu.RUnlock()
+		if !ok {
+			http.Error(w, "Order book is syncing", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s)
+		return
+	}
+
+	obm.mu.RLock()
+	symbols := make([]string, 0, len(obm.books))
+	for symbol := range obm.books {
+		symbols = append(symbols, symbol)
+	}
+	obm.mu.RUnlock()
+	slices.Sort(symbols)
+
+	all := []orderbook.Stats{}
+	for _, symbol := range symbols {
+		book := obm.getBook(symbol)
+		book.mu.RLock()
+		if s, ok := obm.stats(symbol, book, now); ok {
+			all = append(all, s)
+		}
+		book.mu.RUnlock()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(all)
+}
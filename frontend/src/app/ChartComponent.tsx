@@ -3,26 +3,63 @@
 import React, { useEffect, useRef } from 'react';
 import { createChart, ColorType } from 'lightweight-charts';
 import useWebSocket from 'react-use-websocket';
+import { SymbolInfo } from './symbols';
 
 // Props და Stream-ის მონაცემების ტიპები
 interface ChartComponentProps {
-  initialData: { time: string; open: number; high: number; low: number; close: number; }[];
+  initialData: KlineRecord[];
+  // უფრო ძველი გვერდის cursor; არ არსებობს, თუ ისტორია ბოლომდეა ჩატვირთული
+  nextCursor?: string;
+  indicators?: IndicatorSeries[];
   symbol: string;
   interval: string;
+  info?: SymbolInfo;
 }
-interface KlineStreamData { s: string; k: { t: number; i: string; o: string; h: string; l: string; c: string; };}
-interface TradeStreamData { s: string; p: string; }
+// backend-ის ნორმალიზებული (ბირჟისგან დამოუკიდებელი) შეტყობინებები
+interface KlineStreamData { symbol: string; interval: string; openTime: number; open: string; high: string; low: string; close: string; }
+interface TradeStreamData { symbol: string; price: string; }
+interface KlineRecord { time: string; open: number; high: number; low: number; close: number; }
+// api-ის ინდიკატორის სერია: values მიჰყვება outputs-ის რიგს
+interface IndicatorSeries { name: string; outputs: string[]; points: { time: string; values: number[] }[]; }
 
-export const ChartComponent: React.FC<ChartComponentProps> = ({ initialData, symbol, interval }) => {
+// სანთლების რაოდენობა ერთ გვერდზე; ძველ გვერდებს გრაფიკი თვითონ ტვირთავს მარცხნივ გადახვევისას
+export const KLINE_PAGE_SIZE = 500;
+// გრაფიკზე დახატული მოძრავი საშუალო
+export const SMA_INDICATOR = 'sma:20';
+// ამდენ სანთელზე ნაკლები რომ დარჩება მარცხნივ, ვტვირთავთ წინა გვერდს
+const LOAD_MORE_THRESHOLD = 20;
+
+const toCandle = (d: KlineRecord) => ({
+  time: new Date(d.time).getTime() / 1000,
+  open: d.open,
+  high: d.high,
+  low: d.low,
+  close: d.close,
+});
+
+// ინდიკატორის პირველი output-ის წერტილები ხაზოვანი სერიისთვის
+const smaPoints = (indicators?: IndicatorSeries[]) =>
+  (indicators?.find(s => s.name === SMA_INDICATOR)?.points || []).map(p => ({
+    time: new Date(p.time).getTime() / 1000,
+    value: p.values[0],
+  }));
+
+export const ChartComponent: React.FC<ChartComponentProps> = ({ initialData, nextCursor, indicators, symbol, interval, info }) => {
   const chartContainerRef = useRef<HTMLDivElement>(null);
   const chartRef = useRef<any>(null);
   const seriesRef = useRef<any>(null);
+  const smaSeriesRef = useRef<any>(null);
   const lastCandleRef = useRef<any>(null);
+  const cursorRef = useRef<string | undefined>(undefined);
+  const loadingRef = useRef(false);
+  const loadOlderRef = useRef<() => void>(() => {});
 
   const { lastJsonMessage, sendJsonMessage } = useWebSocket('ws://localhost:8080/ws', {
     onOpen: () => {
       console.log(`✅ WebSocket connected, subscribing to ${symbol}/${interval}`);
-      sendJsonMessage({ symbol, interval });
+      // api ინდიკატორს live kline-ებზე ითვლის და თითოეულ შეტყობინებას ატანს (იხ. backend/api/websocket.md)
+      sendJsonMessage({ op: 'subscribe', id: 'chart-kline', channel: `kline:${interval}`, symbols: [symbol], indicators: SMA_INDICATOR });
+      sendJsonMessage({ op: 'subscribe', id: 'chart-trades', channel: 'trades', symbols: [symbol] });
     },
     shouldReconnect: (closeEvent) => true,
   });
@@ -39,29 +76,64 @@ export const ChartComponent: React.FC<ChartComponentProps> = ({ initialData, sym
         upColor: '#26a69a', downColor: '#ef5350', borderDownColor: '#ef5350',
         borderUpColor: '#26a69a', wickDownColor: '#ef5350', wickUpColor: '#26a69a',
     });
+    const smaSeries = chart.addLineSeries({ color: '#f0b90b', lineWidth: 1, priceLineVisible: false, lastValueVisible: false });
     chartRef.current = chart;
     seriesRef.current = candlestickSeries;
+    smaSeriesRef.current = smaSeries;
+    // მარცხენა კიდესთან მიახლოებისას ისტორიის შემდეგი გვერდი
+    chart.timeScale().subscribeVisibleLogicalRangeChange(range => {
+      if (range && range.from < LOAD_MORE_THRESHOLD) loadOlderRef.current();
+    });
     const handleResize = () => chart.applyOptions({ width: chartContainerRef.current!.clientWidth });
     window.addEventListener('resize', handleResize);
     return () => { window.removeEventListener('resize', handleResize); chart.remove(); };
   }, []);
 
+  // ფასების შკალა ინსტრუმენტის tick ზომით
+  useEffect(() => {
+    if (seriesRef.current && info) {
+      seriesRef.current.applyOptions({ priceFormat: { type: 'price', precision: info.priceDecimals, minMove: info.tickSize } });
+    }
+  }, [info]);
+
   // ეფექტი #2: ისტორიული მონაცემების დაყენება
   useEffect(() => {
     if (seriesRef.current && initialData?.length > 0) {
       // --- მთავარი შესწორება: ფორმატირება ხდება აქ ---
-      const formattedData = initialData.map(d => ({
-        time: new Date(d.time).getTime() / 1000,
-        open: d.open,
-        high: d.high,
-        low: d.low,
-        close: d.close,
-      }));
+      const formattedData = initialData.map(toCandle);
       seriesRef.current.setData(formattedData);
+      smaSeriesRef.current?.setData(smaPoints(indicators));
       lastCandleRef.current = formattedData[formattedData.length - 1];
       chartRef.current?.timeScale().fitContent();
     }
-  }, [initialData]);
+    cursorRef.current = nextCursor;
+  }, [initialData, nextCursor, indicators]);
+
+  // წინა გვერდის ჩატვირთვა და არსებული სანთლების წინ ჩამატება, ხედის შენარჩუნებით
+  loadOlderRef.current = () => {
+    const cursor = cursorRef.current;
+    if (loadingRef.current || !cursor || !seriesRef.current) return;
+    loadingRef.current = true;
+    fetch(`/api/klines?symbol=${symbol}&interval=${interval}&limit=${KLINE_PAGE_SIZE}&indicators=${SMA_INDICATOR}&cursor=${cursor}`)
+      .then(res => {
+        if (!res.ok) throw new Error(`Kline fetch failed`);
+        return res.json();
+      })
+      .then(page => {
+        // სიმბოლო ან ინტერვალი შეიცვალა, სანამ პასუხს ველოდით
+        if (cursorRef.current !== cursor || !seriesRef.current) return;
+        cursorRef.current = page.nextCursor;
+        const older = (page.klines || []).map(toCandle);
+        if (older.length === 0) return;
+        const timeScale = chartRef.current.timeScale();
+        const range = timeScale.getVisibleLogicalRange();
+        seriesRef.current.setData([...older, ...seriesRef.current.data()]);
+        smaSeriesRef.current?.setData([...smaPoints(page.indicators), ...smaSeriesRef.current.data()]);
+        if (range) timeScale.setVisibleLogicalRange({ from: range.from + older.length, to: range.to + older.length });
+      })
+      .catch(error => console.error("Error fetching older klines:", error))
+      .finally(() => { loadingRef.current = false; });
+  };
 
   // ეფექტი #3: რეალური დროის მონაცემების დამუშავება
   useEffect(() => {
@@ -69,20 +141,22 @@ export const ChartComponent: React.FC<ChartComponentProps> = ({ initialData, sym
     const message = lastJsonMessage as any;
 
     if (message.type === 'kline') {
-      const kline = message.data.k;
-      if (kline.s === symbol && kline.i === interval) {
+      const kline = message.data as KlineStreamData;
+      if (kline.symbol === symbol && kline.interval === interval) {
         const newCandle = {
-            time: kline.t / 1000,
-            open: parseFloat(kline.o), high: parseFloat(kline.h),
-            low: parseFloat(kline.l), close: parseFloat(kline.c),
+            time: kline.openTime / 1000,
+            open: parseFloat(kline.open), high: parseFloat(kline.high),
+            low: parseFloat(kline.low), close: parseFloat(kline.close),
         };
         seriesRef.current.update(newCandle);
         lastCandleRef.current = newCandle;
+        const sma = (message.indicators as { name: string; values: number[] }[] | undefined)?.find(i => i.name === SMA_INDICATOR);
+        if (sma) smaSeriesRef.current?.update({ time: newCandle.time, value: sma.values[0] });
       }
     } else if (message.type === 'trade') {
-      const trade = message.data;
-      if (trade.s === symbol && lastCandleRef.current) {
-        const tradePrice = parseFloat(trade.p);
+      const trade = message.data as TradeStreamData;
+      if (trade.symbol === symbol && lastCandleRef.current) {
+        const tradePrice = parseFloat(trade.price);
         const updatedCandle = { ...lastCandleRef.current };
         updatedCandle.close = tradePrice;
         if (tradePrice > updatedCandle.high) updatedCandle.high = tradePrice;
@@ -2,6 +2,7 @@
 'use client';
 
 import React from 'react';
+import { SymbolInfo, formatDecimals } from './symbols';
 
 // ინტერფეისი გასწორებულია backend-ის შესაბამისად (lowercase)
 interface OrderLevel {
@@ -12,9 +13,11 @@ interface OrderLevel {
 interface OrderBookProps {
   bids: OrderLevel[];
   asks: OrderLevel[];
+  // ფასი tick-ის, რაოდენობა step-ის სიზუსტით
+  info?: SymbolInfo;
 }
 
-export const OrderBook: React.FC<OrderBookProps> = ({ bids, asks }) => {
+export const OrderBook: React.FC<OrderBookProps> = ({ bids, asks, info }) => {
   if (!bids || !asks) {
     return <div className="text-center text-gray-400">Loading Order Book...</div>;
   }
@@ -28,7 +31,7 @@ export const OrderBook: React.FC<OrderBookProps> = ({ bids, asks }) => {
           <table className="w-full">
             <thead>
               <tr className="text-gray-400">
-                <th className="text-left font-normal px-2">Price (USDT)</th>
+                <th className="text-left font-normal px-2">Price ({info?.quoteAsset ?? 'USDT'})</th>
                 <th className="text-right font-normal px-2">Amount</th>
               </tr>
             </thead>
@@ -36,8 +39,8 @@ export const OrderBook: React.FC<OrderBookProps> = ({ bids, asks }) => {
               {/* ვასწორებთ .Price-ს .price-ზე და .Amount-ს .amount-ზე */}
               {[...asks].reverse().map((ask, index) => (
                 <tr key={index} className="relative h-6">
-                  <td className="text-red-400 px-2">{ask.price.toFixed(2)}</td>
-                  <td className="text-right px-2">{ask.amount.toFixed(4)}</td>
+                  <td className="text-red-400 px-2">{formatDecimals(ask.price, info?.priceDecimals)}</td>
+                  <td className="text-right px-2">{formatDecimals(ask.amount, info?.quantityDecimals)}</td>
                 </tr>
               ))}
             </tbody>
@@ -49,7 +52,7 @@ export const OrderBook: React.FC<OrderBookProps> = ({ bids, asks }) => {
           <table className="w-full">
             <thead>
               <tr className="text-gray-400">
-                <th className="text-left font-normal px-2">Price (USDT)</th>
+                <th className="text-left font-normal px-2">Price ({info?.quoteAsset ?? 'USDT'})</th>
                 <th className="text-right font-normal px-2">Amount</th>
               </tr>
             </thead>
@@ -57,8 +60,8 @@ export const OrderBook: React.FC<OrderBookProps> = ({ bids, asks }) => {
               {/* ვასწორებთ .Price-ს .price-ზე და .Amount-ს .amount-ზე */}
               {bids.map((bid, index) => (
                 <tr key={index} className="relative h-6">
-                  <td className="text-green-400 px-2">{bid.price.toFixed(2)}</td>
-                  <td className="text-right px-2">{bid.amount.toFixed(4)}</td>
+                  <td className="text-green-400 px-2">{formatDecimals(bid.price, info?.priceDecimals)}</td>
+                  <td className="text-right px-2">{formatDecimals(bid.amount, info?.quantityDecimals)}</td>
                 </tr>
               ))}
             </tbody>
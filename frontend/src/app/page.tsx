@@ -1,29 +1,43 @@
 'use client';
 
-import { ChartComponent } from './ChartComponent';
+import { ChartComponent, KLINE_PAGE_SIZE, SMA_INDICATOR } from './ChartComponent';
 import { OrderBook } from './OrderBook';
 import React, { useState, useEffect, useCallback } from 'react';
-import useWebSocket from 'react-use-websocket';
+import useWebSocket, { ReadyState } from 'react-use-websocket';
+import { SymbolInfo, fetchSymbols } from './symbols';
 
 const SYMBOLS = ["BTCUSDT", "ETHUSDT", "SOLUSDT"];
-const INTERVALS = ["1m", "5m"];
+// 1m და 5m არქივდება, დანარჩენ ინტერვალებს api მათგან აგრეგირებს (live განახლებები მხოლოდ არქივირებულზეა)
+const INTERVALS = ["1m", "5m", "15m", "1h", "4h", "1d", "1w"];
+// api-ის WebSocket არხი (იხ. backend/api/websocket.md)
+const BOOK_CHANNEL = 'book:20';
 
 export default function HomePage() {
   const [symbol, setSymbol] = useState(SYMBOLS[0]);
   const [interval, setInterval] = useState(INTERVALS[0]);
   
   const [klineData, setKlineData] = useState<any[]>([]);
+  const [klineCursor, setKlineCursor] = useState<string | undefined>(undefined);
+  const [indicatorData, setIndicatorData] = useState<any[]>([]);
   const [orderBookData, setOrderBookData] = useState<any>({ bids: [], asks: [] });
   const [isLoading, setIsLoading] = useState(true);
+  const [symbolInfo, setSymbolInfo] = useState<Record<string, SymbolInfo>>({});
+
+  // tick/step ზომები ფორმატირებისთვის; ერთხელ იტვირთება
+  useEffect(() => {
+    fetchSymbols().then(setSymbolInfo).catch(error => console.error("Error fetching symbols:", error));
+  }, []);
 
   // --- ისტორიული მონაცემების წამოღება ---
   const fetchInitialData = useCallback(async (currentSymbol: string, currentInterval: string) => {
     setIsLoading(true);
     try {
-      const klineRes = await fetch(`/api/klines?symbol=${currentSymbol}&interval=${currentInterval}`);
+      const klineRes = await fetch(`/api/klines?symbol=${currentSymbol}&interval=${currentInterval}&limit=${KLINE_PAGE_SIZE}&indicators=${SMA_INDICATOR}`);
       if (!klineRes.ok) throw new Error(`Kline fetch failed`);
       const klineJson = await klineRes.json();
-      setKlineData(klineJson || []); // ვაწვდით პირდაპირ, დაუმუშავებელ მონაცემს
+      setKlineData(klineJson.klines || []); // ვაწვდით პირდაპირ, დაუმუშავებელ მონაცემს
+      setKlineCursor(klineJson.nextCursor);
+      setIndicatorData(klineJson.indicators || []);
 
       const orderBookRes = await fetch(`http://localhost:8081/orderbook?symbol=${currentSymbol}`);
       if (!orderBookRes.ok) throw new Error(`OrderBook fetch failed`);
@@ -33,6 +47,8 @@ export default function HomePage() {
     } catch (error) {
       console.error("Error fetching initial data:", error);
       setKlineData([]);
+      setKlineCursor(undefined);
+      setIndicatorData([]);
       setOrderBookData({ bids: [], asks: [] });
     } finally {
       setIsLoading(false);
@@ -44,23 +60,26 @@ export default function HomePage() {
   }, [symbol, interval, fetchInitialData]);
 
   // --- WebSocket კავშირის და მონაცემების მართვა ---
-  const { lastJsonMessage, sendJsonMessage } = useWebSocket('ws://localhost:8080/ws', {
+  const { lastJsonMessage, sendJsonMessage, readyState } = useWebSocket('ws://localhost:8080/ws', {
     onOpen: () => console.log(`✅ Central WebSocket connected.`),
     shouldReconnect: (closeEvent) => true,
   });
 
+  // order book-ის არხი არჩეული სიმბოლოსთვის; ხელახალი დაკავშირებისას თავიდან ვიწერთ
   useEffect(() => {
-    console.log(`Subscribing to ${symbol}/${interval}`);
-    sendJsonMessage({ symbol, interval });
-  }, [symbol, interval, sendJsonMessage]);
+    if (readyState !== ReadyState.OPEN) return;
+    console.log(`Subscribing to ${BOOK_CHANNEL} for ${symbol}`);
+    sendJsonMessage({ op: 'subscribe', id: `book-${symbol}`, channel: BOOK_CHANNEL, symbols: [symbol] });
+    return () => sendJsonMessage({ op: 'unsubscribe', id: `book-${symbol}`, channel: BOOK_CHANNEL, symbols: [symbol] });
+  }, [symbol, readyState, sendJsonMessage]);
   
   useEffect(() => {
     if (!lastJsonMessage) return;
     const message = lastJsonMessage as any;
     
-    if (message.type === 'orderbook') {
+    if (message.type === 'book') {
         const book = message.data;
-        if (book.symbol === symbol) {
+        if (message.symbol === symbol) {
             setOrderBookData(book);
         }
     }
@@ -93,14 +112,17 @@ export default function HomePage() {
           ) : (
               <ChartComponent 
                 initialData={klineData} 
+                nextCursor={klineCursor}
+                indicators={indicatorData}
                 symbol={symbol} 
                 interval={interval}
+                info={symbolInfo[symbol]}
                 lastJsonMessage={lastJsonMessage}
               />
           )}
         </div>
         <div className="lg:col-span-1">
-            <OrderBook bids={orderBookData.bids} asks={orderBookData.asks} />
+            <OrderBook bids={orderBookData.bids} asks={orderBookData.asks} info={symbolInfo[symbol]} />
         </div>
       </div>
     </main>
@@ -0,0 +1,26 @@
+// frontend/src/app/symbols.ts
+// ინსტრუმენტების მეტამონაცემები api-ის /symbols-დან (Binance exchangeInfo)
+
+export interface SymbolInfo {
+  symbol: string;
+  baseAsset: string;
+  quoteAsset: string;
+  status: string;
+  tickSize: number;
+  stepSize: number;
+  minQty: number;
+  minNotional: number;
+  priceDecimals: number;
+  quantityDecimals: number;
+}
+
+// მეტამონაცემების ჩატვირთვამდე მნიშვნელობები სრული სიზუსტით ჩანს
+export const formatDecimals = (value: number, decimals?: number): string =>
+  decimals === undefined ? value.toString() : value.toFixed(decimals);
+
+export async function fetchSymbols(): Promise<Record<string, SymbolInfo>> {
+  const res = await fetch('/api/symbols');
+  if (!res.ok) throw new Error(`Symbols fetch failed`);
+  const list: SymbolInfo[] = await res.json();
+  return Object.fromEntries(list.map(info => [info.symbol, info]));
+}